@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"slices"
 	"strings"
 
 	"github.com/danielgtaylor/huma/v2"
@@ -14,7 +19,10 @@ import (
 	"github.com/pranavturlapati28/merget-takehome/internal/api"
 	"github.com/pranavturlapati28/merget-takehome/internal/config"
 	"github.com/pranavturlapati28/merget-takehome/internal/database"
+	"github.com/pranavturlapati28/merget-takehome/internal/llm"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
 	"github.com/pranavturlapati28/merget-takehome/internal/repository"
+	"github.com/pranavturlapati28/merget-takehome/internal/runner"
 	"github.com/pranavturlapati28/merget-takehome/internal/services"
 )
 
@@ -24,6 +32,13 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// "server seed" seeds the database and exits instead of serving
+	seedOnly := len(os.Args) > 1 && os.Args[1] == "seed"
+	reset := false
+	if seedOnly {
+		reset = parseSeedFlags(cfg, os.Args[2:])
+	}
+
 	err = database.Connect()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -35,18 +50,53 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
-	err = database.Seed()
+	provider, err := llm.New(llm.Config{
+		Provider: cfg.LLMProvider,
+		BaseURL:  cfg.LLMBaseURL,
+		APIKey:   cfg.LLMAPIKey,
+		Model:    cfg.LLMModel,
+		Timeout:  cfg.LLMTimeout,
+	})
 	if err != nil {
-		log.Fatalf("Failed to seed database: %v", err)
+		log.Fatalf("Failed to configure LLM provider: %v", err)
+	}
+
+	commands, err := runner.New(runner.Config{
+		Enabled:       cfg.RunnerEnabled,
+		Authenticated: cfg.APIKey != "",
+		WorkDir:       cfg.RunnerWorkDir,
+		Timeout:       cfg.RunnerTimeout,
+		Env:           cfg.RunnerEnv,
+		MaxOutput:     cfg.RunnerMaxOutput,
+	})
+	if err != nil {
+		log.Fatalf("Failed to configure command runner: %v", err)
 	}
 
 	repo := repository.NewPromptRepository()
-	service := services.NewPromptService(repo)
-	handler := api.NewHandler(service)
+	notifier := services.NewNotifier()
+	service := services.NewPromptService(repo, notifier, provider, llm.NewApprox(), cfg.TokenBudget, commands)
+
+	err = service.BackfillMentions()
+	if err != nil {
+		log.Fatalf("Failed to backfill mentions: %v", err)
+	}
+
+	err = seed(service, cfg, reset)
+	if err != nil {
+		log.Fatalf("Failed to seed database: %v", err)
+	}
+	if seedOnly {
+		return
+	}
+
+	idempotency := services.NewIdempotencyService(repo, cfg.IdempotencyWindow)
+	handler := api.NewHandler(service, notifier, idempotency)
 
 	router := chi.NewMux()
 
 	router.Use(middleware.Recoverer)
+	router.Use(legacyPathMiddleware)
 	router.Use(corsMiddleware)
 	router.Use(apiKeyMiddleware(cfg))
 	router.Use(middleware.Logger)
@@ -65,9 +115,76 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+cfg.Port, router))
 }
 
+// seed installs the seed templates and creates the tree from one of them.
+// Without reset an existing tree is left alone.
+func seed(service *services.PromptService, cfg *config.Config, reset bool) error {
+	templates, err := database.LoadSeeds(cfg.SeedPath)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return errors.New("no seed files found")
+	}
+
+	if err := service.InstallTemplates(templates); err != nil {
+		return fmt.Errorf("invalid seed template: %w", err)
+	}
+
+	name := cfg.SeedTemplate
+	if name == "" {
+		name = templates[0].Name
+		if slices.ContainsFunc(templates, func(t models.Template) bool { return t.Name == database.DefaultSeedTemplate }) {
+			name = database.DefaultSeedTemplate
+		}
+	}
+
+	if !reset {
+		return service.SeedIfEmpty(name)
+	}
+	if err := service.InstantiateTemplate(name, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Tree reset from template %q\n", name)
+	return nil
+}
+
+// parseSeedFlags reads "seed [--reset] [--path PATH] [--template NAME]",
+// overriding the configured seed path and template, and reports --reset
+func parseSeedFlags(cfg *config.Config, args []string) bool {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	reset := flags.Bool("reset", false, "replace the current tree even if it is not empty")
+	flags.StringVar(&cfg.SeedPath, "path", cfg.SeedPath, "seed file or directory (default: embedded seeds)")
+	flags.StringVar(&cfg.SeedTemplate, "template", cfg.SeedTemplate, "template to create the tree from")
+	flags.Parse(args)
+	return *reset
+}
+
+// legacyPaths are the routes that were served without a version prefix
+var legacyPaths = []string{"/health", "/events", "/tree", "/prompts", "/batch"}
+
+// legacyPathMiddleware keeps the unversioned paths working by routing them to
+// their /v1 equivalents, marking the response as deprecated.
+func legacyPathMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, legacy := range legacyPaths {
+			if r.URL.Path == legacy || strings.HasPrefix(r.URL.Path, legacy+"/") {
+				r.URL.Path = api.BasePath + r.URL.Path
+				if r.URL.RawPath != "" {
+					r.URL.RawPath = api.BasePath + r.URL.RawPath
+				}
+				w.Header().Set("Deprecation", "true")
+				w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", r.URL.Path))
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/events" || r.URL.Path == "/test-sse" {
+		if r.URL.Path == api.BasePath+"/events" || r.URL.Path == "/test-sse" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -86,8 +203,9 @@ func corsMiddleware(next http.Handler) http.Handler {
 			}
 		}
 		
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, Idempotent-Replayed, Deprecation, Link")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -117,36 +235,38 @@ func apiKeyMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				}
 			}
 			
+			// Running commands executes them on this machine, so it always
+			// takes the key: Origin is a header any client can send
+			commands := runsCommands(r)
+
 			if cfg.APIKey == "" {
+				if commands {
+					writeUnauthorized(w, "Running commands requires the server to have an API_KEY")
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
 			
-			if isAllowedOrigin {
+			if isAllowedOrigin && !commands {
 				next.ServeHTTP(w, r)
 				return
 			}
 			
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprintf(w, `{"error":"API key required. Use Authorization: Bearer <your-api-key>"}`)
+				writeUnauthorized(w, "API key required. Use Authorization: Bearer <your-api-key>")
 				return
 			}
 			
 			parts := strings.Split(authHeader, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprintf(w, `{"error":"Invalid authorization format. Use Authorization: Bearer <your-api-key>"}`)
+				writeUnauthorized(w, "Invalid authorization format. Use Authorization: Bearer <your-api-key>")
 				return
 			}
 			
 			if parts[1] != cfg.APIKey {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprintf(w, `{"error":"Invalid API key"}`)
+				writeUnauthorized(w, "Invalid API key")
 				return
 			}
 			
@@ -155,6 +275,24 @@ func apiKeyMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 	}
 }
 
+// runsCommands reports whether a request would execute command nodes
+func runsCommands(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/commands/run")
+}
+
+// writeUnauthorized responds with the same problem+json shape the API uses
+// for every other error
+func writeUnauthorized(w http.ResponseWriter, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]any{
+		"title":  http.StatusText(http.StatusUnauthorized),
+		"status": http.StatusUnauthorized,
+		"detail": detail,
+		"code":   "unauthorized",
+	})
+}
+
 func printStartupBanner(port string, cfg *config.Config) {
 	fmt.Println("")
 	fmt.Println("╔═══════════════════════════════════════════════════════════════╗")
@@ -169,28 +307,99 @@ func printStartupBanner(port string, cfg *config.Config) {
 		fmt.Println("║    Frontend:    Whitelisted (no API key needed)              ║")
 		fmt.Println("║    Usage:       Authorization: Bearer <your-api-key>         ║")
 	}
+	if cfg.RunnerEnabled {
+		fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
+		fmt.Println("║  Command runner: ENABLED, command nodes run on this machine   ║")
+		fmt.Printf("║    Directory:   %s\n", cfg.RunnerWorkDir)
+	}
 	fmt.Println("╠═══════════════════════════════════════════════════════════════╣")
-	fmt.Println("║  Endpoints:                                                   ║")
+	fmt.Println("║  Endpoints (prefixed with /v1):                               ║")
 	fmt.Println("║    GET    /health              Health check                   ║")
+	fmt.Println("║    GET    /events              Change event stream (SSE)      ║")
 	fmt.Println("║    GET    /tree                Full prompt tree               ║")
-	fmt.Println("║    GET    /tree/export         Export tree as JSON            ║")
+	fmt.Println("║    GET    /tree/progress       Status rollups                 ║")
+	fmt.Println("║    GET    /tree/plan           Dependency order and critical path ║")
+	fmt.Println("║    GET    /tree/render-text    Tree with variables filled in  ║")
+	fmt.Println("║    POST   /tree/generate       Generate tree with the LLM     ║")
+	fmt.Println("║    GET    /tree/export         Export json/chat/doc/sh/make   ║")
+	fmt.Println("║    PATCH  /tree                JSON Patch the tree            ║")
 	fmt.Println("║    POST   /tree/import         Import tree from JSON         ║")
 	fmt.Println("║    POST   /tree/save           Save current tree              ║")
 	fmt.Println("║    GET    /tree/saves          List saved trees               ║")
 	fmt.Println("║    POST   /tree/load/{name}    Load saved tree                ║")
 	fmt.Println("║    DELETE /tree/saves/{name}   Delete saved tree              ║")
+	fmt.Println("║    GET    /prompts             List prompts                   ║")
+	fmt.Println("║    POST   /prompts             Create prompt                  ║")
 	fmt.Println("║    GET    /prompts/{id}        Single prompt                  ║")
-	fmt.Println("║    POST   /prompts/{id}        Create prompt                  ║")
+	fmt.Println("║    POST   /prompts/{id}        Create prompt (deprecated)     ║")
 	fmt.Println("║    PUT    /prompts/{id}        Update prompt                  ║")
+	fmt.Println("║    PATCH  /prompts/{id}        Merge-patch prompt             ║")
 	fmt.Println("║    DELETE /prompts/{id}        Delete prompt                  ║")
 	fmt.Println("║    GET    /prompts/{id}/nodes  Get nodes                      ║")
 	fmt.Println("║    POST   /prompts/{id}/nodes  Create node                    ║")
+	fmt.Println("║    POST   /prompts/{id}/suggest-nodes  Suggest nodes          ║")
 	fmt.Println("║    PUT    /prompts/{id}/nodes/{nodeId} Update node           ║")
+	fmt.Println("║    PATCH  /prompts/{id}/nodes/{nodeId} Merge-patch node      ║")
 	fmt.Println("║    DELETE /prompts/{id}/nodes/{nodeId} Delete node            ║")
 	fmt.Println("║    GET    /prompts/{id}/notes  Get notes                      ║")
 	fmt.Println("║    POST   /prompts/{id}/notes  Create note                    ║")
 	fmt.Println("║    PUT    /prompts/{id}/notes/{noteId} Update note           ║")
+	fmt.Println("║    PATCH  /prompts/{id}/notes/{noteId} Merge-patch note      ║")
 	fmt.Println("║    DELETE /prompts/{id}/notes/{noteId} Delete note            ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/notes  Node notes      ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/notes  Note a node     ║")
+	fmt.Println("║    PUT|PATCH|DELETE …/nodes/{nodeId}/notes/{noteId}  Edit     ║")
+	fmt.Println("║    GET    /tree/saves/{name}/notes  Saved tree notes          ║")
+	fmt.Println("║    POST   /tree/saves/{name}/notes  Note a saved tree         ║")
+	fmt.Println("║    PUT|PATCH|DELETE /tree/saves/{name}/notes/{noteId}  Edit   ║")
+	fmt.Println("║    POST   /prompts/{id}/notes/{noteId}/replies  Reply         ║")
+	fmt.Println("║    POST   /prompts/{id}/notes/{noteId}/resolve  Resolve       ║")
+	fmt.Println("║    POST   /prompts/{id}/notes/{noteId}/unresolve  Reopen      ║")
+	fmt.Println("║    POST   …/nodes/{nodeId}/notes/{noteId}/…  Node threads     ║")
+	fmt.Println("║    POST   /tree/saves/{name}/notes/{noteId}/…  Tree threads   ║")
+	fmt.Println("║    GET    /mentions/{name}     Notes mentioning a name        ║")
+	fmt.Println("║    GET    /tags                List tags                      ║")
+	fmt.Println("║    PUT    /prompts/{id}/tags/{tag}  Tag prompt                ║")
+	fmt.Println("║    DELETE /prompts/{id}/tags/{tag}  Untag prompt              ║")
+	fmt.Println("║    PUT    /prompts/{id}/nodes/{nodeId}/tags/{tag} Tag node   ║")
+	fmt.Println("║    DELETE /prompts/{id}/nodes/{nodeId}/tags/{tag} Untag node ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/dependencies  Deps      ║")
+	fmt.Println("║    PUT    /prompts/{id}/nodes/{nodeId}/dependencies/{dependsOnId} ║")
+	fmt.Println("║    DELETE /prompts/{id}/nodes/{nodeId}/dependencies/{dependsOnId} ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/tests  List test cases ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/tests  Create test case ║")
+	fmt.Println("║    PUT    /prompts/{id}/nodes/{nodeId}/tests/{testId}         ║")
+	fmt.Println("║    DELETE /prompts/{id}/nodes/{nodeId}/tests/{testId}         ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/tests/run  Run tests   ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/tests/runs  Run history ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/variants  List variants ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/variants  Add variant   ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/variants/compare       ║")
+	fmt.Println("║    PUT    /prompts/{id}/nodes/{nodeId}/variants/{variantId}   ║")
+	fmt.Println("║    DELETE /prompts/{id}/nodes/{nodeId}/variants/{variantId}   ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/variants/{variantId}/promote ║")
+	fmt.Println("║    POST   /prompts/{id}/commands/run  Run command nodes       ║")
+	fmt.Println("║    POST   /prompts/{id}/nodes/{nodeId}/commands/run  Run one  ║")
+	fmt.Println("║    GET    /prompts/{id}/nodes/{nodeId}/commands/runs  Logs    ║")
+	fmt.Println("║    POST   /runs                Start plan run                 ║")
+	fmt.Println("║    GET    /runs                List plan runs                 ║")
+	fmt.Println("║    GET    /runs/{id}           Plan run with steps            ║")
+	fmt.Println("║    PUT    /runs/{id}/steps/{nodeId}  Set step status/notes    ║")
+	fmt.Println("║    POST   /runs/{id}/advance   Finish step, start next        ║")
+	fmt.Println("║    POST   /runs/{id}/complete  Complete or abandon run        ║")
+	fmt.Println("║    GET    /runs/{id}/report    Durations and outcomes         ║")
+	fmt.Println("║    GET    /model-defaults      Default model settings         ║")
+	fmt.Println("║    PUT    /model-defaults      Set default model settings     ║")
+	fmt.Println("║    PUT    /prompts/{id}/model  Set prompt model settings      ║")
+	fmt.Println("║    GET    /variables           List variables                 ║")
+	fmt.Println("║    PUT    /variables/{name}    Create or update variable      ║")
+	fmt.Println("║    DELETE /variables/{name}    Delete variable                ║")
+	fmt.Println("║    GET    /templates           List templates                 ║")
+	fmt.Println("║    GET    /templates/{name}    Get template                   ║")
+	fmt.Println("║    PUT    /templates/{name}    Create or replace template     ║")
+	fmt.Println("║    DELETE /templates/{name}    Delete template                ║")
+	fmt.Println("║    POST   /templates/{name}/instantiate  New tree from template ║")
+	fmt.Println("║    POST   /batch               Atomic batch of operations     ║")
 	fmt.Println("╚═══════════════════════════════════════════════════════════════╝")
 	fmt.Println("")
 }
\ No newline at end of file
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/services"
+)
+
+// IfMatchHeader is embedded in inputs for writes that honor optimistic
+// concurrency. The header is optional; when absent the write is unconditional.
+type IfMatchHeader struct {
+	IfMatch string `header:"If-Match" doc:"ETag from a previous GET; the write fails with 412 if the resource changed since"`
+}
+
+// etag formats a row version as a strong entity tag
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// exportETag tags an export with the tree version and, when they shape the
+// output, the format, variable overrides and templates. A plain JSON export
+// keeps the tree's own tag, so it can be sent back in If-Match.
+func exportETag(version int, input *ExportTreeInput) string {
+	if input.Format == "json" || input.Format == "" {
+		return etag(version)
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q %q %q %q", input.Format, input.Vars, input.SystemTemplate, input.MessageTemplate)
+	return strconv.Quote(fmt.Sprintf("%d-%x", version, hash.Sum(nil)[:8]))
+}
+
+// expectedVersion parses the If-Match header into the version the client
+// expects. It returns nil when the header is absent or "*".
+func (h IfMatchHeader) expectedVersion() (*int, error) {
+	value := strings.TrimSpace(h.IfMatch)
+	if value == "" || value == "*" {
+		return nil, nil
+	}
+
+	if strings.Contains(value, ",") {
+		return nil, huma.Error400BadRequest("If-Match must contain a single entity tag")
+	}
+
+	value = strings.TrimPrefix(value, "W/")
+	unquoted, err := strconv.Unquote(value)
+	if err != nil {
+		unquoted = value
+	}
+
+	version, err := strconv.Atoi(unquoted)
+	if err != nil {
+		// Not one of our tags, so it cannot match the current representation.
+		return nil, problemFor(services.ErrVersionConflict.Withf("If-Match does not match the current version"), "")
+	}
+	return &version, nil
+}
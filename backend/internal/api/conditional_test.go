@@ -0,0 +1,37 @@
+package api
+
+import "testing"
+
+func TestExportETag(t *testing.T) {
+	if got := exportETag(3, &ExportTreeInput{Format: "json"}); got != `"3"` {
+		t.Errorf("json export tag = %s, want the tree's", got)
+	}
+
+	inputs := []ExportTreeInput{
+		{Format: "chat"},
+		{Format: "document"},
+		{Format: "sh"},
+		{Format: "make"},
+		{Format: "chat", Vars: []string{"laps=5"}},
+		{Format: "chat", Vars: []string{"laps=6"}},
+		{Format: "chat", Vars: []string{"a=1", "b=2"}},
+		{Format: "chat", Vars: []string{"a=1 b=2"}},
+		{Format: "chat", SystemTemplate: "{{project}}"},
+		{Format: "chat", MessageTemplate: "{{project}}"},
+	}
+	seen := map[string]int{}
+	for i, input := range inputs {
+		tag := exportETag(3, &input)
+		if j, ok := seen[tag]; ok {
+			t.Errorf("exports %d and %d share the tag %s", j, i, tag)
+		}
+		seen[tag] = i
+
+		if again := exportETag(3, &input); again != tag {
+			t.Errorf("export %d tagged %s, then %s", i, tag, again)
+		}
+		if exportETag(4, &input) == tag {
+			t.Errorf("export %d keeps its tag when the tree changes", i)
+		}
+	}
+}
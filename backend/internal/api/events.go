@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/danielgtaylor/huma/v2/sse"
+)
+
+// StreamEvents relays notifier events to the client until it disconnects
+func (h *Handler) StreamEvents(ctx context.Context, input *struct{}, send sse.Sender) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return
+	}
+
+	clientID := hex.EncodeToString(id)
+	client := h.notifier.RegisterClient(clientID)
+	defer h.notifier.UnregisterClient(clientID)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.Done:
+			// Too slow to keep up; the browser reconnects and refetches
+			return
+		case event, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			if err := send.Data(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -2,21 +2,23 @@ package api
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/pranavturlapati28/merget-takehome/internal/jsonpatch"
 	"github.com/pranavturlapati28/merget-takehome/internal/models"
 	"github.com/pranavturlapati28/merget-takehome/internal/services"
 )
 
 type Handler struct {
-	service *services.PromptService
+	service     *services.PromptService
+	notifier    *services.Notifier
+	idempotency *services.IdempotencyService
 }
 
-func NewHandler(service *services.PromptService) *Handler {
-	return &Handler{service: service}
+func NewHandler(service *services.PromptService, notifier *services.Notifier, idempotency *services.IdempotencyService) *Handler {
+	return &Handler{service: service, notifier: notifier, idempotency: idempotency}
 }
 
 type HealthOutput struct {
@@ -27,6 +29,7 @@ type HealthOutput struct {
 }
 
 type TreeOutput struct {
+	ETag string `header:"ETag" doc:"Current tree version, usable as If-Match on /tree/import"`
 	Body models.TreeResponse
 }
 
@@ -34,17 +37,52 @@ type PromptPathParams struct {
 	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
 }
 
+// TagFilter narrows list responses to items carrying every listed tag
+type TagFilter struct {
+	Tags []string `query:"tag" doc:"Comma-separated tags; only items carrying all of them are returned"`
+}
+
+type GetTreeInput struct {
+	TagFilter
+	NoteCounts bool `query:"note_counts" doc:"Include the number of notes on each node"`
+}
+
+type TreeProgressInput struct {
+	TagFilter
+}
+
+type ListPromptsInput struct {
+	TagFilter
+}
+
+type GetNodesInput struct {
+	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
+	TagFilter
+}
+
 type GetPromptOutput struct {
+	ETag string `header:"ETag"`
 	Body models.PromptDetail
 }
 
+type ListPromptsOutput struct {
+	Body []models.Prompt
+}
+
 type CreatePromptInput struct {
-	ID   int `path:"id" doc:"Ignored for creation"`
+	IdempotencyKeyHeader
 	Body models.CreatePromptRequest
 }
 
+type LegacyCreatePromptInput struct {
+	ID int `path:"id" doc:"Ignored for creation"`
+	CreatePromptInput
+}
+
 type CreatePromptOutput struct {
-	Body models.Prompt
+	Location string `header:"Location" doc:"URL of the created prompt"`
+	ETag     string `header:"ETag"`
+	Body     models.Prompt
 }
 
 type GetNodesOutput struct {
@@ -52,7 +90,8 @@ type GetNodesOutput struct {
 }
 
 type CreateNodeInput struct {
-	ID   int `path:"id" minimum:"1" doc:"Prompt ID to add node to"`
+	ID int `path:"id" minimum:"1" doc:"Prompt ID to add node to"`
+	IdempotencyKeyHeader
 	Body models.CreateNodeRequest
 }
 
@@ -65,7 +104,8 @@ type GetNotesOutput struct {
 }
 
 type CreateNoteInput struct {
-	ID   int `path:"id" minimum:"1" doc:"Prompt ID to add note to"`
+	ID int `path:"id" minimum:"1" doc:"Prompt ID to add note to"`
+	IdempotencyKeyHeader
 	Body models.CreateNoteRequest
 }
 
@@ -74,44 +114,77 @@ type CreateNoteOutput struct {
 }
 
 type UpdatePromptInput struct {
-	ID   int `path:"id" minimum:"1" doc:"Prompt ID"`
+	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
+	IfMatchHeader
 	Body models.UpdatePromptRequest
 }
 
 type UpdatePromptOutput struct {
+	ETag string `header:"ETag"`
 	Body models.Prompt
 }
 
+type PatchPromptInput struct {
+	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
+	IfMatchHeader
+	Body models.PatchPromptRequest
+}
+
+type DeletePromptInput struct {
+	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
+	IfMatchHeader
+}
+
 type NodePathParams struct {
 	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
 	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	IfMatchHeader
 }
 
 type UpdateNodeInput struct {
 	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
 	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
-	Body   models.UpdateNodeRequest
+	IfMatchHeader
+	Body models.UpdateNodeRequest
 }
 
 type UpdateNodeOutput struct {
+	ETag string `header:"ETag"`
 	Body models.Node
 }
 
+type PatchNodeInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	IfMatchHeader
+	Body models.PatchNodeRequest
+}
+
 type NotePathParams struct {
 	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
 	NoteID int `path:"noteId" minimum:"1" doc:"Note ID"`
+	IfMatchHeader
+}
+
+func (p NotePathParams) target() *services.NoteTarget {
+	return &services.NoteTarget{PromptID: p.ID}
 }
 
 type UpdateNoteInput struct {
-	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
-	NoteID int `path:"noteId" minimum:"1" doc:"Note ID"`
-	Body   models.UpdateNoteRequest
+	NotePathParams
+	Body models.UpdateNoteRequest
 }
 
 type UpdateNoteOutput struct {
+	ETag string `header:"ETag"`
 	Body models.Note
 }
 
+type PatchNoteInput struct {
+	NotePathParams
+	Body models.PatchNoteRequest
+}
+
 // =============================================================================
 // HANDLERS
 // Each handler is a thin wrapper that:
@@ -128,58 +201,92 @@ func (h *Handler) Health(ctx context.Context, input *struct{}) (*HealthOutput, e
 	return resp, nil
 }
 
-// GetTree returns the full prompt tree
-func (h *Handler) GetTree(ctx context.Context, input *struct{}) (*TreeOutput, error) {
-	tree, err := h.service.GetTree()
+// GetTree returns the full prompt tree, optionally narrowed to tagged items
+// and with note counts per node
+func (h *Handler) GetTree(ctx context.Context, input *GetTreeInput) (*TreeOutput, error) {
+	version, err := h.service.GetTreeVersion()
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch tree")
+	}
+
+	tree, err := h.service.GetTreeWithTags(input.Tags)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch tree")
+	}
+	if input.NoteCounts {
+		if err := h.service.CountNodeNotes(tree); err != nil {
+			return nil, problemFor(err, "Failed to fetch tree")
+		}
+	}
+	return &TreeOutput{ETag: etag(version), Body: *tree}, nil
+}
+
+type TreeProgressOutput struct {
+	Body models.TreeProgressResponse
+}
+
+// GetTreeProgress summarizes node statuses per prompt and for the project
+func (h *Handler) GetTreeProgress(ctx context.Context, input *TreeProgressInput) (*TreeProgressOutput, error) {
+	summary, err := h.service.GetTreeProgress(input.Tags)
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to fetch tree", err)
+		return nil, problemFor(err, "Failed to fetch progress")
 	}
-	return &TreeOutput{Body: *tree}, nil
+	return &TreeProgressOutput{Body: *summary}, nil
 }
 
 // GetPrompt returns a single prompt by ID
 func (h *Handler) GetPrompt(ctx context.Context, input *PromptPathParams) (*GetPromptOutput, error) {
 	prompt, err := h.service.GetPrompt(input.ID)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
-	}
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to fetch prompt", err)
+		return nil, problemFor(err, "Failed to fetch prompt")
 	}
 
-	return &GetPromptOutput{Body: *prompt}, nil
+	return &GetPromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
+}
+
+// ListPrompts returns all prompts without their nodes or notes
+func (h *Handler) ListPrompts(ctx context.Context, input *ListPromptsInput) (*ListPromptsOutput, error) {
+	prompts, err := h.service.ListPrompts(input.Tags)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch prompts")
+	}
+	return &ListPromptsOutput{Body: prompts}, nil
 }
 
 func (h *Handler) CreatePrompt(ctx context.Context, input *CreatePromptInput) (*CreatePromptOutput, error) {
 	prompt, err := h.service.CreatePrompt(input.Body.Title, input.Body.Description)
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to create prompt", err)
+		return nil, problemFor(err, "Failed to create prompt")
 	}
-	return &CreatePromptOutput{Body: *prompt}, nil
+	return &CreatePromptOutput{
+		Location: fmt.Sprintf("%s/prompts/%d", BasePath, prompt.ID),
+		ETag:     etag(prompt.Version),
+		Body:     *prompt,
+	}, nil
+}
+
+// CreatePromptLegacy serves the deprecated POST /prompts/{id}, which ignores
+// the ID in the path
+func (h *Handler) CreatePromptLegacy(ctx context.Context, input *LegacyCreatePromptInput) (*CreatePromptOutput, error) {
+	return h.CreatePrompt(ctx, &input.CreatePromptInput)
 }
 
-func (h *Handler) GetPromptNodes(ctx context.Context, input *PromptPathParams) (*GetNodesOutput, error) {
-	nodes, err := h.service.GetPromptNodes(input.ID)
+func (h *Handler) GetPromptNodes(ctx context.Context, input *GetNodesInput) (*GetNodesOutput, error) {
+	nodes, err := h.service.GetPromptNodes(input.ID, input.Tags)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
-	}
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to fetch nodes", err)
+		return nil, problemFor(err, "Failed to fetch nodes")
 	}
 
 	return &GetNodesOutput{Body: nodes}, nil
 }
 
 func (h *Handler) CreateNode(ctx context.Context, input *CreateNodeInput) (*CreateNodeOutput, error) {
-	node, err := h.service.CreateNode(input.ID, input.Body.Name, input.Body.Action)
+	node, err := h.service.CreateNode(input.ID, input.Body.Name, input.Body.Action, input.Body.Kind)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
-	}
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to create node", err)
+		return nil, problemFor(err, "Failed to create node")
 	}
 
 	return &CreateNodeOutput{Body: *node}, nil
@@ -188,102 +295,167 @@ func (h *Handler) CreateNode(ctx context.Context, input *CreateNodeInput) (*Crea
 func (h *Handler) GetNotes(ctx context.Context, input *PromptPathParams) (*GetNotesOutput, error) {
 	notes, err := h.service.GetNotes(input.ID)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
-	}
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to fetch notes", err)
+		return nil, problemFor(err, "Failed to fetch notes")
 	}
 
 	return &GetNotesOutput{Body: notes}, nil
 }
 
 func (h *Handler) CreateNote(ctx context.Context, input *CreateNoteInput) (*CreateNoteOutput, error) {
-	note, err := h.service.CreateNote(input.ID, input.Body.Content)
+	note, err := h.service.CreateNote(input.ID, input.Body)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
-	}
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to create note", err)
+		return nil, problemFor(err, "Failed to create note")
 	}
 
 	return &CreateNoteOutput{Body: *note}, nil
 }
 
 func (h *Handler) UpdatePrompt(ctx context.Context, input *UpdatePromptInput) (*UpdatePromptOutput, error) {
-	prompt, err := h.service.UpdatePrompt(input.ID, input.Body.Title, input.Body.Description)
-
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	prompt, err := h.service.UpdatePrompt(input.ID, input.Body.Title, input.Body.Description, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to update prompt", err)
+		return nil, problemFor(err, "Failed to update prompt")
 	}
 
-	return &UpdatePromptOutput{Body: *prompt}, nil
+	return &UpdatePromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
 }
 
-func (h *Handler) DeletePrompt(ctx context.Context, input *PromptPathParams) (*struct{}, error) {
-	err := h.service.DeletePrompt(input.ID)
+func (h *Handler) PatchPrompt(ctx context.Context, input *PatchPromptInput) (*UpdatePromptOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := h.service.PatchPrompt(input.ID, input.Body, ifVersion)
 
-	if errors.Is(err, services.ErrPromptNotFound) {
-		return nil, huma.Error404NotFound("Prompt not found")
+	if err != nil {
+		return nil, problemFor(err, "Failed to patch prompt")
+	}
+
+	return &UpdatePromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
+}
+
+func (h *Handler) DeletePrompt(ctx context.Context, input *DeletePromptInput) (*struct{}, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	err = h.service.DeletePrompt(input.ID, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to delete prompt", err)
+		return nil, problemFor(err, "Failed to delete prompt")
 	}
 
 	return &struct{}{}, nil
 }
 
 func (h *Handler) UpdateNode(ctx context.Context, input *UpdateNodeInput) (*UpdateNodeOutput, error) {
-	node, err := h.service.UpdateNode(input.NodeID, input.Body.Name, input.Body.Action)
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := h.service.UpdateNode(input.NodeID, input.Body.Name, input.Body.Action, input.Body.Status, input.Body.Kind, ifVersion)
+
+	if err != nil {
+		return nil, problemFor(err, "Failed to update node")
+	}
+
+	return &UpdateNodeOutput{ETag: etag(node.Version), Body: *node}, nil
+}
 
-	if errors.Is(err, services.ErrNodeNotFound) {
-		return nil, huma.Error404NotFound("Node not found")
+func (h *Handler) PatchNode(ctx context.Context, input *PatchNodeInput) (*UpdateNodeOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	node, err := h.service.PatchNode(input.NodeID, input.Body, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to update node", err)
+		return nil, problemFor(err, "Failed to patch node")
 	}
 
-	return &UpdateNodeOutput{Body: *node}, nil
+	return &UpdateNodeOutput{ETag: etag(node.Version), Body: *node}, nil
 }
 
 func (h *Handler) DeleteNode(ctx context.Context, input *NodePathParams) (*struct{}, error) {
-	err := h.service.DeleteNode(input.NodeID)
-
-	if errors.Is(err, services.ErrNodeNotFound) {
-		return nil, huma.Error404NotFound("Node not found")
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	err = h.service.DeleteNode(input.NodeID, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to delete node", err)
+		return nil, problemFor(err, "Failed to delete node")
 	}
 
 	return &struct{}{}, nil
 }
 
 func (h *Handler) UpdateNote(ctx context.Context, input *UpdateNoteInput) (*UpdateNoteOutput, error) {
-	note, err := h.service.UpdateNote(input.NoteID, input.Body.Content)
+	return h.updateNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
 
-	if errors.Is(err, services.ErrNoteNotFound) {
-		return nil, huma.Error404NotFound("Note not found")
+func (h *Handler) PatchNote(ctx context.Context, input *PatchNoteInput) (*UpdateNoteOutput, error) {
+	return h.patchNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
+
+func (h *Handler) DeleteNote(ctx context.Context, input *NotePathParams) (*struct{}, error) {
+	return h.deleteNote(input.target(), input.NoteID, input.IfMatchHeader)
+}
+
+// updateNote, patchNote and deleteNote serve the note routes of prompts,
+// nodes and saved trees alike
+func (h *Handler) updateNote(target *services.NoteTarget, noteID int, ifMatch IfMatchHeader, body models.UpdateNoteRequest) (*UpdateNoteOutput, error) {
+	ifVersion, err := ifMatch.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	note, err := h.service.UpdateNote(target, noteID, body.Content, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to update note", err)
+		return nil, problemFor(err, "Failed to update note")
 	}
 
-	return &UpdateNoteOutput{Body: *note}, nil
+	return &UpdateNoteOutput{ETag: etag(note.Version), Body: *note}, nil
 }
 
-func (h *Handler) DeleteNote(ctx context.Context, input *NotePathParams) (*struct{}, error) {
-	err := h.service.DeleteNote(input.NoteID)
+func (h *Handler) patchNote(target *services.NoteTarget, noteID int, ifMatch IfMatchHeader, patch models.PatchNoteRequest) (*UpdateNoteOutput, error) {
+	ifVersion, err := ifMatch.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	note, err := h.service.PatchNote(target, noteID, patch, ifVersion)
+
+	if err != nil {
+		return nil, problemFor(err, "Failed to patch note")
+	}
+
+	return &UpdateNoteOutput{ETag: etag(note.Version), Body: *note}, nil
+}
 
-	if errors.Is(err, services.ErrNoteNotFound) {
-		return nil, huma.Error404NotFound("Note not found")
+func (h *Handler) deleteNote(target *services.NoteTarget, noteID int, ifMatch IfMatchHeader) (*struct{}, error) {
+	ifVersion, err := ifMatch.expectedVersion()
+	if err != nil {
+		return nil, err
 	}
+
+	err = h.service.DeleteNote(target, noteID, ifVersion)
+
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to delete note", err)
+		return nil, problemFor(err, "Failed to delete note")
 	}
 
 	return &struct{}{}, nil
@@ -295,6 +467,8 @@ func (h *Handler) DeleteNote(ctx context.Context, input *NotePathParams) (*struc
 
 // ImportTreeInput is the input for POST /tree/import
 type ImportTreeInput struct {
+	IfMatchHeader
+	IdempotencyKeyHeader
 	Body models.ImportTreeRequest
 }
 
@@ -305,9 +479,23 @@ type ImportTreeOutput struct {
 	}
 }
 
-// ExportTreeOutput returns the current tree as JSON
+// ExportTreeInput picks the export format and, for chat and document, the
+// variable overrides and templates. The sh and make formats take overrides
+// too.
+type ExportTreeInput struct {
+	Format          string   `query:"format" enum:"json,chat,document,sh,make" default:"json" doc:"json for the raw tree, chat for a list of chat messages, document for a single plain-text prompt, sh for a shell script and make for a Makefile built from command and file nodes"`
+	Vars            []string `query:"vars,explode" doc:"chat, document, sh and make only: variable overrides as name=value"`
+	SystemTemplate  string   `query:"system_template" maxLength:"10000" doc:"chat and document only: template for the system message, using {{project}} and {{main_request}}"`
+	MessageTemplate string   `query:"message_template" maxLength:"10000" doc:"chat and document only: template for each node's message, also using {{prompt_title}}, {{prompt_description}}, {{node_name}}, {{node_action}}, {{node_status}}, {{step}} and {{total}}"`
+}
+
+// ExportTreeOutput returns the current tree as JSON (models.TreeResponse),
+// as chat messages (models.ChatExport), as a plain-text document, or as a
+// shell script or Makefile. The schema of each is set on the route.
 type ExportTreeOutput struct {
-	Body models.TreeResponse
+	ETag        string `header:"ETag"`
+	ContentType string `header:"Content-Type"`
+	Body        any
 }
 
 // SaveTreeInput is the input for POST /tree/save
@@ -338,6 +526,12 @@ type LoadTreeOutput struct {
 	}
 }
 
+// PatchTreeInput is an RFC 6902 patch addressed against the GetTree shape
+type PatchTreeInput struct {
+	IfMatchHeader
+	Body []jsonpatch.Operation
+}
+
 type DeleteSavedTreePathParams struct {
 	Name string `path:"name" doc:"Name of the saved tree"`
 }
@@ -345,10 +539,15 @@ type DeleteSavedTreePathParams struct {
 // ImportTree imports a tree from JSON and replaces the current tree
 func (h *Handler) ImportTree(ctx context.Context, input *ImportTreeInput) (*ImportTreeOutput, error) {
 	fmt.Printf("ImportTree handler called with project: %s\n", input.Body.Tree.Project)
-	err := h.service.ImportTree(&input.Body.Tree)
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.service.ImportTree(&input.Body.Tree, ifVersion)
 	if err != nil {
 		fmt.Printf("ImportTree error: %v\n", err)
-		return nil, huma.Error400BadRequest("Failed to import tree", err)
+		return nil, problemFor(err, "Failed to import tree")
 	}
 
 	fmt.Printf("ImportTree completed successfully\n")
@@ -357,18 +556,69 @@ func (h *Handler) ImportTree(ctx context.Context, input *ImportTreeInput) (*Impo
 	return resp, nil
 }
 
-func (h *Handler) ExportTree(ctx context.Context, input *struct{}) (*ExportTreeOutput, error) {
-	tree, err := h.service.GetTree()
+func (h *Handler) ExportTree(ctx context.Context, input *ExportTreeInput) (*ExportTreeOutput, error) {
+	version, err := h.service.GetTreeVersion()
+	if err != nil {
+		return nil, problemFor(err, "Failed to export tree")
+	}
+
+	out := &ExportTreeOutput{ETag: exportETag(version, input)}
+	switch input.Format {
+	case "chat":
+		export, err := h.service.ExportChat(input.Vars, input.SystemTemplate, input.MessageTemplate)
+		if err != nil {
+			return nil, problemFor(err, "Failed to export tree")
+		}
+		out.Body = export
+	case "document":
+		document, err := h.service.ExportDocument(input.Vars, input.SystemTemplate, input.MessageTemplate)
+		if err != nil {
+			return nil, problemFor(err, "Failed to export tree")
+		}
+		out.ContentType = "text/plain; charset=utf-8"
+		out.Body = []byte(document)
+	case "sh":
+		script, err := h.service.ExportShell(input.Vars)
+		if err != nil {
+			return nil, problemFor(err, "Failed to export tree")
+		}
+		out.ContentType = "text/x-shellscript; charset=utf-8"
+		out.Body = []byte(script)
+	case "make":
+		makefile, err := h.service.ExportMakefile(input.Vars)
+		if err != nil {
+			return nil, problemFor(err, "Failed to export tree")
+		}
+		out.ContentType = "text/x-makefile; charset=utf-8"
+		out.Body = []byte(makefile)
+	default:
+		tree, err := h.service.GetTree()
+		if err != nil {
+			return nil, problemFor(err, "Failed to export tree")
+		}
+		out.Body = tree
+	}
+	return out, nil
+}
+
+// PatchTree applies a JSON Patch to the whole tree and returns the result
+func (h *Handler) PatchTree(ctx context.Context, input *PatchTreeInput) (*TreeOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	tree, version, err := h.service.PatchTree(input.Body, ifVersion)
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to export tree", err)
+		return nil, problemFor(err, "Failed to patch tree")
 	}
-	return &ExportTreeOutput{Body: *tree}, nil
+	return &TreeOutput{ETag: etag(version), Body: *tree}, nil
 }
 
 func (h *Handler) SaveTree(ctx context.Context, input *SaveTreeInput) (*SaveTreeOutput, error) {
 	err := h.service.SaveTree(input.Body.Name)
 	if err != nil {
-		return nil, huma.Error400BadRequest("Failed to save tree", err)
+		return nil, problemFor(err, "Failed to save tree")
 	}
 
 	resp := &SaveTreeOutput{}
@@ -379,7 +629,7 @@ func (h *Handler) SaveTree(ctx context.Context, input *SaveTreeInput) (*SaveTree
 func (h *Handler) ListSavedTrees(ctx context.Context, input *struct{}) (*SavedTreeListOutput, error) {
 	trees, err := h.service.ListSavedTrees()
 	if err != nil {
-		return nil, huma.Error500InternalServerError("Failed to list saved trees", err)
+		return nil, problemFor(err, "Failed to list saved trees")
 	}
 
 	return &SavedTreeListOutput{
@@ -390,10 +640,7 @@ func (h *Handler) ListSavedTrees(ctx context.Context, input *struct{}) (*SavedTr
 func (h *Handler) LoadTree(ctx context.Context, input *LoadTreePathParams) (*LoadTreeOutput, error) {
 	err := h.service.LoadTree(input.Name)
 	if err != nil {
-		if err.Error() == "saved tree not found" {
-			return nil, huma.Error404NotFound("Saved tree not found")
-		}
-		return nil, huma.Error400BadRequest("Failed to load tree", err)
+		return nil, problemFor(err, "Failed to load tree")
 	}
 
 	resp := &LoadTreeOutput{}
@@ -404,11 +651,992 @@ func (h *Handler) LoadTree(ctx context.Context, input *LoadTreePathParams) (*Loa
 func (h *Handler) DeleteSavedTree(ctx context.Context, input *DeleteSavedTreePathParams) (*struct{}, error) {
 	err := h.service.DeleteSavedTree(input.Name)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, huma.Error404NotFound("Saved tree not found")
-		}
-		return nil, huma.Error500InternalServerError("Failed to delete saved tree", err)
+		return nil, problemFor(err, "Failed to delete saved tree")
+	}
+
+	return &struct{}{}, nil
+}
+
+// =============================================================================
+// BATCH HANDLERS
+// =============================================================================
+
+type BatchInput struct {
+	Body models.BatchRequest
+}
+
+type BatchOutput struct {
+	Body models.BatchResponse
+}
+
+// Batch runs a list of operations atomically, reporting the failing index
+// with the status the equivalent single request would have returned
+func (h *Handler) Batch(ctx context.Context, input *BatchInput) (*BatchOutput, error) {
+	results, err := h.service.ExecuteBatch(input.Body.Operations)
+
+	var batchErr *services.BatchError
+	if errors.As(err, &batchErr) {
+		problem := problemFor(batchErr.Err, "Failed to run batch")
+		problem.Errors = append(problem.Errors, &huma.ErrorDetail{
+			Message:  fmt.Sprintf("operation %d (%s): %s", batchErr.Index, batchErr.Op, problem.Detail),
+			Location: fmt.Sprintf("body.operations[%d]", batchErr.Index),
+		})
+		problem.Detail = "Batch failed and was rolled back"
+		return nil, problem
+	}
+	if err != nil {
+		return nil, problemFor(err, "Failed to run batch")
+	}
+
+	return &BatchOutput{Body: models.BatchResponse{Results: results}}, nil
+}
+
+// =============================================================================
+// TAG HANDLERS
+// =============================================================================
+
+type ListTagsOutput struct {
+	Body models.TagListResponse
+}
+
+type PromptTagParams struct {
+	ID  int    `path:"id" minimum:"1" doc:"Prompt ID"`
+	Tag string `path:"tag" maxLength:"64" doc:"Tag name; case-insensitive"`
+}
+
+type NodeTagParams struct {
+	ID     int    `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int    `path:"nodeId" minimum:"1" doc:"Node ID"`
+	Tag    string `path:"tag" maxLength:"64" doc:"Tag name; case-insensitive"`
+}
+
+func (h *Handler) ListTags(ctx context.Context, input *struct{}) (*ListTagsOutput, error) {
+	tags, err := h.service.ListTags()
+	if err != nil {
+		return nil, problemFor(err, "Failed to list tags")
+	}
+	return &ListTagsOutput{Body: models.TagListResponse{Tags: tags}}, nil
+}
+
+func (h *Handler) AddPromptTag(ctx context.Context, input *PromptTagParams) (*UpdatePromptOutput, error) {
+	prompt, err := h.service.AddPromptTag(input.ID, input.Tag)
+	if err != nil {
+		return nil, problemFor(err, "Failed to tag prompt")
+	}
+	return &UpdatePromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
+}
+
+func (h *Handler) RemovePromptTag(ctx context.Context, input *PromptTagParams) (*UpdatePromptOutput, error) {
+	prompt, err := h.service.RemovePromptTag(input.ID, input.Tag)
+	if err != nil {
+		return nil, problemFor(err, "Failed to untag prompt")
+	}
+	return &UpdatePromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
+}
+
+func (h *Handler) AddNodeTag(ctx context.Context, input *NodeTagParams) (*UpdateNodeOutput, error) {
+	node, err := h.service.AddNodeTag(input.NodeID, input.Tag)
+	if err != nil {
+		return nil, problemFor(err, "Failed to tag node")
+	}
+	return &UpdateNodeOutput{ETag: etag(node.Version), Body: *node}, nil
+}
+
+func (h *Handler) RemoveNodeTag(ctx context.Context, input *NodeTagParams) (*UpdateNodeOutput, error) {
+	node, err := h.service.RemoveNodeTag(input.NodeID, input.Tag)
+	if err != nil {
+		return nil, problemFor(err, "Failed to untag node")
+	}
+	return &UpdateNodeOutput{ETag: etag(node.Version), Body: *node}, nil
+}
+
+// =============================================================================
+// DEPENDENCY HANDLERS
+// =============================================================================
+
+type NodeDependenciesInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+}
+
+type NodeDependencyParams struct {
+	ID          int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID      int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	DependsOnID int `path:"dependsOnId" minimum:"1" doc:"ID of the node that must finish first"`
+}
+
+type NodeDependenciesOutput struct {
+	Body models.NodeDependencies
+}
+
+type PlanOutput struct {
+	Body models.PlanResponse
+}
+
+func (h *Handler) GetNodeDependencies(ctx context.Context, input *NodeDependenciesInput) (*NodeDependenciesOutput, error) {
+	deps, err := h.service.GetNodeDependencies(input.NodeID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch dependencies")
+	}
+	return &NodeDependenciesOutput{Body: *deps}, nil
+}
+
+func (h *Handler) AddNodeDependency(ctx context.Context, input *NodeDependencyParams) (*NodeDependenciesOutput, error) {
+	deps, err := h.service.AddNodeDependency(input.NodeID, input.DependsOnID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to add dependency")
+	}
+	return &NodeDependenciesOutput{Body: *deps}, nil
+}
+
+func (h *Handler) RemoveNodeDependency(ctx context.Context, input *NodeDependencyParams) (*NodeDependenciesOutput, error) {
+	deps, err := h.service.RemoveNodeDependency(input.NodeID, input.DependsOnID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to remove dependency")
+	}
+	return &NodeDependenciesOutput{Body: *deps}, nil
+}
+
+// GetPlan returns the nodes in dependency order with the critical path
+func (h *Handler) GetPlan(ctx context.Context, input *struct{}) (*PlanOutput, error) {
+	plan, err := h.service.GetPlan()
+	if err != nil {
+		return nil, problemFor(err, "Failed to build plan")
+	}
+	return &PlanOutput{Body: *plan}, nil
+}
+
+// =============================================================================
+// VARIABLE HANDLERS
+// =============================================================================
+
+type VariableParams struct {
+	Name string `path:"name" maxLength:"64" doc:"Variable name"`
+}
+
+type PutVariableInput struct {
+	Name string `path:"name" maxLength:"64" doc:"Variable name"`
+	Body models.PutVariableRequest
+}
+
+type VariableOutput struct {
+	Body models.Variable
+}
+
+type ListVariablesOutput struct {
+	Body models.VariableListResponse
+}
+
+type RenderTreeInput struct {
+	Vars []string `query:"vars,explode" doc:"Overrides as name=value; repeat the parameter for several variables"`
+}
+
+type RenderTreeOutput struct {
+	Body models.TreeResponse
+}
+
+func (h *Handler) ListVariables(ctx context.Context, input *struct{}) (*ListVariablesOutput, error) {
+	variables, err := h.service.ListVariables()
+	if err != nil {
+		return nil, problemFor(err, "Failed to list variables")
+	}
+	return &ListVariablesOutput{Body: models.VariableListResponse{Variables: variables}}, nil
+}
+
+func (h *Handler) PutVariable(ctx context.Context, input *PutVariableInput) (*VariableOutput, error) {
+	variable, err := h.service.PutVariable(input.Name, input.Body.Default, input.Body.Description)
+	if err != nil {
+		return nil, problemFor(err, "Failed to save variable")
 	}
+	return &VariableOutput{Body: *variable}, nil
+}
 
+func (h *Handler) DeleteVariable(ctx context.Context, input *VariableParams) (*struct{}, error) {
+	if err := h.service.DeleteVariable(input.Name); err != nil {
+		return nil, problemFor(err, "Failed to delete variable")
+	}
 	return &struct{}{}, nil
+}
+
+// RenderTree returns the tree with every {{variable}} substituted
+func (h *Handler) RenderTree(ctx context.Context, input *RenderTreeInput) (*RenderTreeOutput, error) {
+	tree, err := h.service.RenderTree(input.Vars)
+	if err != nil {
+		return nil, problemFor(err, "Failed to render tree")
+	}
+	return &RenderTreeOutput{Body: *tree}, nil
+}
+
+// =============================================================================
+// TEMPLATE HANDLERS
+// =============================================================================
+
+type TemplateParams struct {
+	Name string `path:"name" maxLength:"255" doc:"Template name"`
+}
+
+type PutTemplateInput struct {
+	Name string `path:"name" maxLength:"255" doc:"Template name"`
+	Body models.PutTemplateRequest
+}
+
+type InstantiateTemplateInput struct {
+	Name string `path:"name" maxLength:"255" doc:"Template name"`
+	IfMatchHeader
+	IdempotencyKeyHeader
+	Body models.InstantiateTemplateRequest
+}
+
+type TemplateOutput struct {
+	Body models.Template
+}
+
+type ListTemplatesOutput struct {
+	Body models.TemplateListResponse
+}
+
+func (h *Handler) ListTemplates(ctx context.Context, input *struct{}) (*ListTemplatesOutput, error) {
+	templates, err := h.service.ListTemplates()
+	if err != nil {
+		return nil, problemFor(err, "Failed to list templates")
+	}
+	return &ListTemplatesOutput{Body: models.TemplateListResponse{Templates: templates}}, nil
+}
+
+func (h *Handler) GetTemplate(ctx context.Context, input *TemplateParams) (*TemplateOutput, error) {
+	template, err := h.service.GetTemplate(input.Name)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch template")
+	}
+	return &TemplateOutput{Body: *template}, nil
+}
+
+func (h *Handler) PutTemplate(ctx context.Context, input *PutTemplateInput) (*TemplateOutput, error) {
+	template, err := h.service.PutTemplate(input.Name, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to save template")
+	}
+	return &TemplateOutput{Body: *template}, nil
+}
+
+func (h *Handler) DeleteTemplate(ctx context.Context, input *TemplateParams) (*struct{}, error) {
+	if err := h.service.DeleteTemplate(input.Name); err != nil {
+		return nil, problemFor(err, "Failed to delete template")
+	}
+	return &struct{}{}, nil
+}
+
+// InstantiateTemplate replaces the tree with a new one built from a template
+// and returns it
+func (h *Handler) InstantiateTemplate(ctx context.Context, input *InstantiateTemplateInput) (*TreeOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.service.InstantiateTemplate(input.Name, input.Body.Values, ifVersion); err != nil {
+		return nil, problemFor(err, "Failed to instantiate template")
+	}
+	return h.GetTree(ctx, &GetTreeInput{})
+}
+
+// =============================================================================
+// GENERATION HANDLERS
+// =============================================================================
+
+type GenerateTreeInput struct {
+	IfMatchHeader
+	IdempotencyKeyHeader
+	Body models.GenerateTreeRequest
+}
+
+type GenerateTreeOutput struct {
+	Body models.GenerateTreeResponse
+}
+
+// GenerateTree drafts a tree from the main request with the configured LLM,
+// applying it when asked
+func (h *Handler) GenerateTree(ctx context.Context, input *GenerateTreeInput) (*GenerateTreeOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := h.service.GenerateTree(ctx, input.Body.MainRequest, input.Body.Apply, ifVersion)
+	if err != nil {
+		return nil, problemFor(err, "Failed to generate tree")
+	}
+	return &GenerateTreeOutput{Body: *result}, nil
+}
+
+type SuggestNodesInput struct {
+	ID   int `path:"id" minimum:"1" doc:"Prompt ID"`
+	Body models.SuggestNodesRequest
+}
+
+type SuggestNodesOutput struct {
+	Body models.SuggestNodesResponse
+}
+
+// SuggestNodes proposes new nodes for a prompt without storing them
+func (h *Handler) SuggestNodes(ctx context.Context, input *SuggestNodesInput) (*SuggestNodesOutput, error) {
+	suggestions, err := h.service.SuggestNodes(ctx, input.ID, input.Body.Guidance, input.Body.Max)
+	if err != nil {
+		return nil, problemFor(err, "Failed to suggest nodes")
+	}
+	return &SuggestNodesOutput{Body: models.SuggestNodesResponse{PromptID: input.ID, Suggestions: suggestions}}, nil
+}
+
+// =============================================================================
+// TEST CASE HANDLERS
+// =============================================================================
+
+type NodeTestsInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+}
+
+type RunTestsInput struct {
+	NodeTestsInput
+	VariantID int `query:"variant" minimum:"0" doc:"Run this variant's text instead of the node's action"`
+}
+
+type TestCaseParams struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	TestID int `path:"testId" minimum:"1" doc:"Test case ID"`
+}
+
+type CreateTestCaseInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	IdempotencyKeyHeader
+	Body models.TestCaseRequest
+}
+
+type UpdateTestCaseInput struct {
+	TestCaseParams
+	Body models.TestCaseRequest
+}
+
+type ListTestRunsInput struct {
+	ID         int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID     int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	TestCaseID int `query:"test_case" minimum:"0" doc:"Only this test case's runs"`
+	Limit      int `query:"limit" minimum:"1" maximum:"500" default:"50" doc:"Most runs to return"`
+}
+
+type TestCaseOutput struct {
+	Body models.TestCase
+}
+
+type ListTestCasesOutput struct {
+	Body models.TestCaseListResponse
+}
+
+type RunTestsOutput struct {
+	Body models.RunTestsResponse
+}
+
+type ListTestRunsOutput struct {
+	Body models.TestRunListResponse
+}
+
+func (h *Handler) ListTestCases(ctx context.Context, input *NodeTestsInput) (*ListTestCasesOutput, error) {
+	cases, err := h.service.ListTestCases(input.NodeID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to list test cases")
+	}
+	return &ListTestCasesOutput{Body: models.TestCaseListResponse{TestCases: cases}}, nil
+}
+
+func (h *Handler) CreateTestCase(ctx context.Context, input *CreateTestCaseInput) (*TestCaseOutput, error) {
+	t, err := h.service.CreateTestCase(input.NodeID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to create test case")
+	}
+	return &TestCaseOutput{Body: *t}, nil
+}
+
+func (h *Handler) UpdateTestCase(ctx context.Context, input *UpdateTestCaseInput) (*TestCaseOutput, error) {
+	t, err := h.service.UpdateTestCase(input.NodeID, input.TestID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to update test case")
+	}
+	return &TestCaseOutput{Body: *t}, nil
+}
+
+func (h *Handler) DeleteTestCase(ctx context.Context, input *TestCaseParams) (*struct{}, error) {
+	if err := h.service.DeleteTestCase(input.NodeID, input.TestID); err != nil {
+		return nil, problemFor(err, "Failed to delete test case")
+	}
+	return &struct{}{}, nil
+}
+
+// RunTests runs every test case of a node against the LLM and stores the
+// results
+func (h *Handler) RunTests(ctx context.Context, input *RunTestsInput) (*RunTestsOutput, error) {
+	result, err := h.service.RunTests(ctx, input.NodeID, input.VariantID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to run tests")
+	}
+	return &RunTestsOutput{Body: *result}, nil
+}
+
+func (h *Handler) ListTestRuns(ctx context.Context, input *ListTestRunsInput) (*ListTestRunsOutput, error) {
+	runs, err := h.service.ListTestRuns(input.NodeID, input.TestCaseID, input.Limit)
+	if err != nil {
+		return nil, problemFor(err, "Failed to list test runs")
+	}
+	return &ListTestRunsOutput{Body: models.TestRunListResponse{Runs: runs}}, nil
+}
+
+// =============================================================================
+// MODEL SETTINGS HANDLERS
+// =============================================================================
+
+type ModelConfigOutput struct {
+	Body models.ModelConfig
+}
+
+type PutModelDefaultsInput struct {
+	Body models.ModelConfig
+}
+
+type PutPromptModelInput struct {
+	ID int `path:"id" minimum:"1" doc:"Prompt ID"`
+	IfMatchHeader
+	Body models.ModelConfig
+}
+
+// GetModelDefaults returns the project's default model settings
+func (h *Handler) GetModelDefaults(ctx context.Context, input *struct{}) (*ModelConfigOutput, error) {
+	cfg, err := h.service.GetModelDefaults()
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch model defaults")
+	}
+	return &ModelConfigOutput{Body: *cfg}, nil
+}
+
+func (h *Handler) PutModelDefaults(ctx context.Context, input *PutModelDefaultsInput) (*ModelConfigOutput, error) {
+	cfg, err := h.service.SetModelDefaults(input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to save model defaults")
+	}
+	return &ModelConfigOutput{Body: *cfg}, nil
+}
+
+// PutPromptModel replaces a prompt's own model settings and returns the
+// prompt with its effective settings
+func (h *Handler) PutPromptModel(ctx context.Context, input *PutPromptModelInput) (*GetPromptOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	prompt, err := h.service.SetPromptModel(input.ID, input.Body, ifVersion)
+	if err != nil {
+		return nil, problemFor(err, "Failed to save prompt model settings")
+	}
+	return &GetPromptOutput{ETag: etag(prompt.Version), Body: *prompt}, nil
+}
+
+// =============================================================================
+// VARIANT HANDLERS
+// =============================================================================
+
+type VariantParams struct {
+	ID        int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID    int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	VariantID int `path:"variantId" minimum:"1" doc:"Variant ID"`
+}
+
+type CreateVariantInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	IdempotencyKeyHeader
+	Body models.CreateVariantRequest
+}
+
+type UpdateVariantInput struct {
+	VariantParams
+	Body models.UpdateVariantRequest
+}
+
+type PromoteVariantInput struct {
+	VariantParams
+	IfMatchHeader
+}
+
+type CompareVariantsInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	A      int `query:"a" required:"true" minimum:"1" doc:"First variant ID"`
+	B      int `query:"b" required:"true" minimum:"1" doc:"Second variant ID"`
+}
+
+type VariantOutput struct {
+	Body models.NodeVariant
+}
+
+type ListVariantsOutput struct {
+	Body models.VariantListResponse
+}
+
+type CompareVariantsOutput struct {
+	Body models.VariantComparison
+}
+
+func (h *Handler) ListVariants(ctx context.Context, input *NodeTestsInput) (*ListVariantsOutput, error) {
+	variants, err := h.service.ListVariants(input.NodeID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to list variants")
+	}
+	return &ListVariantsOutput{Body: models.VariantListResponse{NodeID: input.NodeID, Variants: variants}}, nil
+}
+
+func (h *Handler) CreateVariant(ctx context.Context, input *CreateVariantInput) (*VariantOutput, error) {
+	v, err := h.service.CreateVariant(input.NodeID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to create variant")
+	}
+	return &VariantOutput{Body: *v}, nil
+}
+
+func (h *Handler) UpdateVariant(ctx context.Context, input *UpdateVariantInput) (*VariantOutput, error) {
+	v, err := h.service.UpdateVariant(input.NodeID, input.VariantID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to update variant")
+	}
+	return &VariantOutput{Body: *v}, nil
+}
+
+func (h *Handler) DeleteVariant(ctx context.Context, input *VariantParams) (*struct{}, error) {
+	if err := h.service.DeleteVariant(input.NodeID, input.VariantID); err != nil {
+		return nil, problemFor(err, "Failed to delete variant")
+	}
+	return &struct{}{}, nil
+}
+
+// PromoteVariant makes a variant the node's action and returns the node
+func (h *Handler) PromoteVariant(ctx context.Context, input *PromoteVariantInput) (*UpdateNodeOutput, error) {
+	ifVersion, err := input.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := h.service.PromoteVariant(input.NodeID, input.VariantID, ifVersion)
+	if err != nil {
+		return nil, problemFor(err, "Failed to promote variant")
+	}
+	return &UpdateNodeOutput{ETag: etag(node.Version), Body: *node}, nil
+}
+
+func (h *Handler) CompareVariants(ctx context.Context, input *CompareVariantsInput) (*CompareVariantsOutput, error) {
+	comparison, err := h.service.CompareVariants(input.NodeID, input.A, input.B)
+	if err != nil {
+		return nil, problemFor(err, "Failed to compare variants")
+	}
+	return &CompareVariantsOutput{Body: *comparison}, nil
+}
+
+// =============================================================================
+// COMMAND RUN HANDLERS
+// =============================================================================
+
+type RunCommandsInput struct {
+	ID              int      `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeIDs         []int    `query:"node,explode" doc:"Command nodes to run, in plan order whatever order they are given in; every command node that is not skipped when empty"`
+	Vars            []string `query:"vars,explode" doc:"Variable overrides as name=value"`
+	ContinueOnError bool     `query:"continue_on_error" doc:"Keep running later nodes after one fails instead of stopping"`
+}
+
+type RunNodeCommandInput struct {
+	ID     int      `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int      `path:"nodeId" minimum:"1" doc:"Node ID"`
+	Vars   []string `query:"vars,explode" doc:"Variable overrides as name=value"`
+}
+
+type ListCommandRunsInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	Limit  int `query:"limit" minimum:"1" maximum:"500" default:"50" doc:"Most runs to return"`
+}
+
+type RunCommandsOutput struct {
+	Body models.RunCommandsResponse
+}
+
+type CommandRunOutput struct {
+	Body models.CommandRun
+}
+
+type ListCommandRunsOutput struct {
+	Body models.CommandRunListResponse
+}
+
+func (h *Handler) RunCommands(ctx context.Context, input *RunCommandsInput) (*RunCommandsOutput, error) {
+	result, err := h.service.RunCommands(ctx, input.ID, input.NodeIDs, input.Vars, input.ContinueOnError)
+	if err != nil {
+		return nil, problemFor(err, "Failed to run commands")
+	}
+	return &RunCommandsOutput{Body: *result}, nil
+}
+
+func (h *Handler) RunNodeCommand(ctx context.Context, input *RunNodeCommandInput) (*CommandRunOutput, error) {
+	run, err := h.service.RunNodeCommand(ctx, input.ID, input.NodeID, input.Vars)
+	if err != nil {
+		return nil, problemFor(err, "Failed to run command")
+	}
+	return &CommandRunOutput{Body: *run}, nil
+}
+
+func (h *Handler) ListCommandRuns(ctx context.Context, input *ListCommandRunsInput) (*ListCommandRunsOutput, error) {
+	runs, err := h.service.ListCommandRuns(input.ID, input.NodeID, input.Limit)
+	if err != nil {
+		return nil, problemFor(err, "Failed to list command runs")
+	}
+	return &ListCommandRunsOutput{Body: models.CommandRunListResponse{Runs: runs}}, nil
+}
+
+// =============================================================================
+// PLAN RUN HANDLERS
+// =============================================================================
+
+type StartPlanRunInput struct {
+	Body models.StartPlanRunRequest
+}
+
+type ListPlanRunsInput struct {
+	SavedTree string `query:"saved_tree" maxLength:"255" doc:"Only runs of this saved tree"`
+	Status    string `query:"status" enum:"active,completed,abandoned" doc:"Only runs with this status"`
+}
+
+type PlanRunParams struct {
+	ID int `path:"id" minimum:"1" doc:"Plan run ID"`
+}
+
+type UpdatePlanRunStepInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Plan run ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID of the step in the saved tree"`
+	Body   models.UpdatePlanRunStepRequest
+}
+
+type AdvancePlanRunInput struct {
+	PlanRunParams
+	Body models.AdvancePlanRunRequest
+}
+
+type CompletePlanRunInput struct {
+	PlanRunParams
+	Body models.CompletePlanRunRequest
+}
+
+type StartPlanRunOutput struct {
+	Location string `header:"Location" doc:"URL of the new run"`
+	Body     models.PlanRun
+}
+
+type PlanRunOutput struct {
+	Body models.PlanRun
+}
+
+type ListPlanRunsOutput struct {
+	Body models.PlanRunListResponse
+}
+
+type PlanRunReportOutput struct {
+	Body models.PlanRunReport
+}
+
+func (h *Handler) StartPlanRun(ctx context.Context, input *StartPlanRunInput) (*StartPlanRunOutput, error) {
+	run, err := h.service.StartPlanRun(input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to start plan run")
+	}
+	return &StartPlanRunOutput{
+		Location: fmt.Sprintf("%s/runs/%d", BasePath, run.ID),
+		Body:     *run,
+	}, nil
+}
+
+func (h *Handler) ListPlanRuns(ctx context.Context, input *ListPlanRunsInput) (*ListPlanRunsOutput, error) {
+	runs, err := h.service.ListPlanRuns(input.SavedTree, input.Status)
+	if err != nil {
+		return nil, problemFor(err, "Failed to list plan runs")
+	}
+	return &ListPlanRunsOutput{Body: models.PlanRunListResponse{Runs: runs}}, nil
+}
+
+func (h *Handler) GetPlanRun(ctx context.Context, input *PlanRunParams) (*PlanRunOutput, error) {
+	run, err := h.service.GetPlanRun(input.ID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to get plan run")
+	}
+	return &PlanRunOutput{Body: *run}, nil
+}
+
+func (h *Handler) UpdatePlanRunStep(ctx context.Context, input *UpdatePlanRunStepInput) (*PlanRunOutput, error) {
+	run, err := h.service.UpdatePlanRunStep(input.ID, input.NodeID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to update plan run step")
+	}
+	return &PlanRunOutput{Body: *run}, nil
+}
+
+func (h *Handler) AdvancePlanRun(ctx context.Context, input *AdvancePlanRunInput) (*PlanRunOutput, error) {
+	run, err := h.service.AdvancePlanRun(input.ID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to advance plan run")
+	}
+	return &PlanRunOutput{Body: *run}, nil
+}
+
+func (h *Handler) CompletePlanRun(ctx context.Context, input *CompletePlanRunInput) (*PlanRunOutput, error) {
+	run, err := h.service.CompletePlanRun(input.ID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to complete plan run")
+	}
+	return &PlanRunOutput{Body: *run}, nil
+}
+
+func (h *Handler) PlanRunReport(ctx context.Context, input *PlanRunParams) (*PlanRunReportOutput, error) {
+	report, err := h.service.PlanRunReport(input.ID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to build plan run report")
+	}
+	return &PlanRunReportOutput{Body: *report}, nil
+}
+
+// =============================================================================
+// NODE AND SAVED TREE NOTE HANDLERS
+// A note is edited through the prompt, node or saved tree it is on
+// =============================================================================
+
+type NodeNotesParams struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+}
+
+type CreateNodeNoteInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID to add note to"`
+	IdempotencyKeyHeader
+	Body models.CreateNoteRequest
+}
+
+type NodeNotePathParams struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	NoteID int `path:"noteId" minimum:"1" doc:"Note ID"`
+	IfMatchHeader
+}
+
+func (p NodeNotePathParams) target() *services.NoteTarget {
+	return &services.NoteTarget{PromptID: p.ID, NodeID: p.NodeID}
+}
+
+type UpdateNodeNoteInput struct {
+	NodeNotePathParams
+	Body models.UpdateNoteRequest
+}
+
+type PatchNodeNoteInput struct {
+	NodeNotePathParams
+	Body models.PatchNoteRequest
+}
+
+type SavedTreeNotesParams struct {
+	Name string `path:"name" doc:"Name of the saved tree"`
+}
+
+type CreateSavedTreeNoteInput struct {
+	Name string `path:"name" doc:"Name of the saved tree to add note to"`
+	IdempotencyKeyHeader
+	Body models.CreateNoteRequest
+}
+
+type SavedTreeNotePathParams struct {
+	Name   string `path:"name" doc:"Name of the saved tree"`
+	NoteID int    `path:"noteId" minimum:"1" doc:"Note ID"`
+	IfMatchHeader
+}
+
+func (p SavedTreeNotePathParams) target() *services.NoteTarget {
+	return &services.NoteTarget{SavedTree: p.Name}
+}
+
+type UpdateSavedTreeNoteInput struct {
+	SavedTreeNotePathParams
+	Body models.UpdateNoteRequest
+}
+
+type PatchSavedTreeNoteInput struct {
+	SavedTreeNotePathParams
+	Body models.PatchNoteRequest
+}
+
+func (h *Handler) GetNodeNotes(ctx context.Context, input *NodeNotesParams) (*GetNotesOutput, error) {
+	notes, err := h.service.GetNodeNotes(input.ID, input.NodeID)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch notes")
+	}
+	return &GetNotesOutput{Body: notes}, nil
+}
+
+func (h *Handler) CreateNodeNote(ctx context.Context, input *CreateNodeNoteInput) (*CreateNoteOutput, error) {
+	note, err := h.service.CreateNodeNote(input.ID, input.NodeID, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to create note")
+	}
+	return &CreateNoteOutput{Body: *note}, nil
+}
+
+func (h *Handler) GetSavedTreeNotes(ctx context.Context, input *SavedTreeNotesParams) (*GetNotesOutput, error) {
+	notes, err := h.service.GetSavedTreeNotes(input.Name)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch notes")
+	}
+	return &GetNotesOutput{Body: notes}, nil
+}
+
+func (h *Handler) CreateSavedTreeNote(ctx context.Context, input *CreateSavedTreeNoteInput) (*CreateNoteOutput, error) {
+	note, err := h.service.CreateSavedTreeNote(input.Name, input.Body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to create note")
+	}
+	return &CreateNoteOutput{Body: *note}, nil
+}
+
+func (h *Handler) UpdateNodeNote(ctx context.Context, input *UpdateNodeNoteInput) (*UpdateNoteOutput, error) {
+	return h.updateNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
+
+func (h *Handler) PatchNodeNote(ctx context.Context, input *PatchNodeNoteInput) (*UpdateNoteOutput, error) {
+	return h.patchNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
+
+func (h *Handler) DeleteNodeNote(ctx context.Context, input *NodeNotePathParams) (*struct{}, error) {
+	return h.deleteNote(input.target(), input.NoteID, input.IfMatchHeader)
+}
+
+func (h *Handler) UpdateSavedTreeNote(ctx context.Context, input *UpdateSavedTreeNoteInput) (*UpdateNoteOutput, error) {
+	return h.updateNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
+
+func (h *Handler) PatchSavedTreeNote(ctx context.Context, input *PatchSavedTreeNoteInput) (*UpdateNoteOutput, error) {
+	return h.patchNote(input.target(), input.NoteID, input.IfMatchHeader, input.Body)
+}
+
+func (h *Handler) DeleteSavedTreeNote(ctx context.Context, input *SavedTreeNotePathParams) (*struct{}, error) {
+	return h.deleteNote(input.target(), input.NoteID, input.IfMatchHeader)
+}
+
+// =============================================================================
+// NOTE THREAD HANDLERS
+// =============================================================================
+
+type ReplyToNoteInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NoteID int `path:"noteId" minimum:"1" doc:"Note ID to reply to"`
+	IdempotencyKeyHeader
+	Body models.CreateNoteRequest
+}
+
+func (p ReplyToNoteInput) target() *services.NoteTarget {
+	return &services.NoteTarget{PromptID: p.ID}
+}
+
+type ReplyToNodeNoteInput struct {
+	ID     int `path:"id" minimum:"1" doc:"Prompt ID"`
+	NodeID int `path:"nodeId" minimum:"1" doc:"Node ID"`
+	NoteID int `path:"noteId" minimum:"1" doc:"Note ID to reply to"`
+	IdempotencyKeyHeader
+	Body models.CreateNoteRequest
+}
+
+func (p ReplyToNodeNoteInput) target() *services.NoteTarget {
+	return &services.NoteTarget{PromptID: p.ID, NodeID: p.NodeID}
+}
+
+type ReplyToSavedTreeNoteInput struct {
+	Name   string `path:"name" doc:"Name of the saved tree"`
+	NoteID int    `path:"noteId" minimum:"1" doc:"Note ID to reply to"`
+	IdempotencyKeyHeader
+	Body models.CreateNoteRequest
+}
+
+func (p ReplyToSavedTreeNoteInput) target() *services.NoteTarget {
+	return &services.NoteTarget{SavedTree: p.Name}
+}
+
+type GetMentionsInput struct {
+	Name       string `path:"name" maxLength:"65" doc:"Mentioned name, with or without the @"`
+	Unresolved bool   `query:"unresolved" doc:"Leave out notes in resolved threads"`
+}
+
+func (h *Handler) ReplyToNote(ctx context.Context, input *ReplyToNoteInput) (*CreateNoteOutput, error) {
+	return h.replyToNote(input.target(), input.NoteID, input.Body)
+}
+
+func (h *Handler) ReplyToNodeNote(ctx context.Context, input *ReplyToNodeNoteInput) (*CreateNoteOutput, error) {
+	return h.replyToNote(input.target(), input.NoteID, input.Body)
+}
+
+func (h *Handler) ReplyToSavedTreeNote(ctx context.Context, input *ReplyToSavedTreeNoteInput) (*CreateNoteOutput, error) {
+	return h.replyToNote(input.target(), input.NoteID, input.Body)
+}
+
+func (h *Handler) replyToNote(target *services.NoteTarget, noteID int, body models.CreateNoteRequest) (*CreateNoteOutput, error) {
+	note, err := h.service.ReplyToNote(target, noteID, body)
+	if err != nil {
+		return nil, problemFor(err, "Failed to reply to note")
+	}
+	return &CreateNoteOutput{Body: *note}, nil
+}
+
+func (h *Handler) ResolveNote(ctx context.Context, input *NotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, true)
+}
+
+func (h *Handler) UnresolveNote(ctx context.Context, input *NotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, false)
+}
+
+func (h *Handler) ResolveNodeNote(ctx context.Context, input *NodeNotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, true)
+}
+
+func (h *Handler) UnresolveNodeNote(ctx context.Context, input *NodeNotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, false)
+}
+
+func (h *Handler) ResolveSavedTreeNote(ctx context.Context, input *SavedTreeNotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, true)
+}
+
+func (h *Handler) UnresolveSavedTreeNote(ctx context.Context, input *SavedTreeNotePathParams) (*UpdateNoteOutput, error) {
+	return h.setNoteResolved(input.target(), input.NoteID, input.IfMatchHeader, false)
+}
+
+func (h *Handler) setNoteResolved(target *services.NoteTarget, noteID int, ifMatch IfMatchHeader, resolved bool) (*UpdateNoteOutput, error) {
+	ifVersion, err := ifMatch.expectedVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	note, err := h.service.ResolveNote(target, noteID, resolved, ifVersion)
+	if err != nil {
+		return nil, problemFor(err, "Failed to update note")
+	}
+	return &UpdateNoteOutput{ETag: etag(note.Version), Body: *note}, nil
+}
+
+// GetMentions lists the notes that @mention someone
+func (h *Handler) GetMentions(ctx context.Context, input *GetMentionsInput) (*GetNotesOutput, error) {
+	notes, err := h.service.GetMentions(input.Name, input.Unresolved)
+	if err != nil {
+		return nil, problemFor(err, "Failed to fetch mentions")
+	}
+	return &GetNotesOutput{Body: notes}, nil
 }
\ No newline at end of file
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/services"
+)
+
+// IdempotencyKeyHeader documents the optional Idempotency-Key header on
+// operations wrapped by the idempotency middleware.
+type IdempotencyKeyHeader struct {
+	IdempotencyKey string `header:"Idempotency-Key" maxLength:"255" doc:"Client-chosen key; retries with the same key and body replay the first response instead of running again"`
+}
+
+// humaContext lets recordingContext embed huma.Context without the field
+// name shadowing the interface's own Context method.
+type humaContext = huma.Context
+
+// recordingContext tees everything the operation writes so the response can
+// be stored, and serves the already-read request body back to huma.
+type recordingContext struct {
+	humaContext
+	body    io.Reader
+	status  int
+	headers http.Header
+	out     bytes.Buffer
+}
+
+func (c *recordingContext) BodyReader() io.Reader {
+	return c.body
+}
+
+func (c *recordingContext) SetStatus(code int) {
+	c.status = code
+	c.humaContext.SetStatus(code)
+}
+
+func (c *recordingContext) SetHeader(name, value string) {
+	c.headers.Set(name, value)
+	c.humaContext.SetHeader(name, value)
+}
+
+func (c *recordingContext) AppendHeader(name, value string) {
+	c.headers.Add(name, value)
+	c.humaContext.AppendHeader(name, value)
+}
+
+func (c *recordingContext) BodyWriter() io.Writer {
+	return io.MultiWriter(c.humaContext.BodyWriter(), &c.out)
+}
+
+// idempotencyMiddleware replays the stored response when a request repeats
+// an Idempotency-Key within the configured window. Requests without the
+// header pass straight through.
+func idempotencyMiddleware(api huma.API, idempotency *services.IdempotencyService) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		key := ctx.Header("Idempotency-Key")
+		if key == "" || idempotency == nil {
+			next(ctx)
+			return
+		}
+
+		limit := ctx.Operation().MaxBodyBytes
+		if limit <= 0 {
+			limit = 1024 * 1024
+		}
+		requestBody, err := io.ReadAll(io.LimitReader(ctx.BodyReader(), limit+1))
+		if err != nil {
+			huma.WriteErr(api, ctx, http.StatusBadRequest, "Failed to read request body", err)
+			return
+		}
+
+		scope := ctx.Method() + " " + ctx.URL().Path
+		record, err := idempotency.Begin(key, scope, requestBody)
+		if err != nil {
+			writeProblem(api, ctx, err, "Failed to check idempotency key")
+			return
+		}
+
+		if record != nil {
+			for name, values := range record.Headers {
+				for _, value := range values {
+					ctx.AppendHeader(name, value)
+				}
+			}
+			ctx.SetHeader("Idempotent-Replayed", "true")
+			ctx.SetStatus(*record.StatusCode)
+			ctx.BodyWriter().Write(record.Body)
+			return
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				idempotency.Finish(key, scope, http.StatusInternalServerError, nil, nil)
+				panic(p)
+			}
+		}()
+
+		rec := &recordingContext{
+			humaContext: ctx,
+			body:        bytes.NewReader(requestBody),
+			headers:     http.Header{},
+		}
+		next(rec)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		idempotency.Finish(key, scope, status, rec.headers, rec.out.Bytes())
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+// Problem is the RFC 9457 application/problem+json body of every error
+// response. Code is stable and machine-readable; clients should branch on it
+// rather than on Title or Detail.
+type Problem struct {
+	huma.ErrorModel
+	Code string `json:"code,omitempty" example:"prompt_not_found" doc:"Stable machine-readable error code"`
+}
+
+// Set before any route is registered so the documented error schema is
+// Problem
+func init() {
+	huma.NewError = newProblem
+}
+
+// newProblem replaces huma.NewError so errors raised by huma itself, such as
+// request validation failures, carry a code as well. The code is derived from
+// the status, e.g. 422 becomes "unprocessable_entity".
+func newProblem(status int, msg string, errors ...error) huma.StatusError {
+	model := huma.ErrorModel{
+		Status: status,
+		Title:  http.StatusText(status),
+		Detail: msg,
+	}
+	for _, err := range errors {
+		if err == nil {
+			continue
+		}
+		if detailer, ok := err.(huma.ErrorDetailer); ok {
+			model.Errors = append(model.Errors, detailer.ErrorDetail())
+		} else {
+			model.Errors = append(model.Errors, &huma.ErrorDetail{Message: err.Error()})
+		}
+	}
+
+	code := strings.ReplaceAll(strings.ToLower(model.Title), " ", "_")
+	return &Problem{ErrorModel: model, Code: code}
+}
+
+// statusForKind is the HTTP status each kind of service error is reported as
+var statusForKind = map[errs.Kind]int{
+	errs.NotFound:     http.StatusNotFound,
+	errs.Conflict:     http.StatusConflict,
+	errs.Validation:   http.StatusUnprocessableEntity,
+	errs.Invalid:      http.StatusBadRequest,
+	errs.TooLarge:     http.StatusRequestEntityTooLarge,
+	errs.Precondition: http.StatusPreconditionFailed,
+	errs.Unavailable:  http.StatusServiceUnavailable,
+	errs.Upstream:     http.StatusBadGateway,
+}
+
+// problemFor maps a service error onto a problem response. Typed errors keep
+// their own message and code; anything else is reported as a 500 described
+// by fallback.
+func problemFor(err error, fallback string) *Problem {
+	var status int
+	e, ok := errs.As(err)
+	if ok {
+		status, ok = statusForKind[e.Kind]
+	}
+	if !ok {
+		return newProblem(http.StatusInternalServerError, fallback, err).(*Problem)
+	}
+
+	return &Problem{
+		ErrorModel: huma.ErrorModel{
+			Status: status,
+			Title:  http.StatusText(status),
+			Detail: e.Message,
+		},
+		Code: e.Code,
+	}
+}
+
+// writeProblem writes err the way huma writes errors returned by handlers,
+// for middleware that has to respond before the handler runs.
+func writeProblem(api huma.API, ctx huma.Context, err error, fallback string) {
+	problem := problemFor(err, fallback)
+	ctx.SetHeader("Content-Type", "application/problem+json")
+	ctx.SetStatus(problem.Status)
+	api.Marshal(ctx.BodyWriter(), "application/json", problem)
+}
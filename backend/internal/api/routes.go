@@ -1,12 +1,27 @@
 package api
 
 import (
+	"reflect"
+
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/sse"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services"
 )
 
+// BasePath prefixes every route. A breaking change ships as a new group
+// (e.g. /v2) registered alongside this one rather than by editing it.
+const BasePath = "/v1"
+
 // RegisterRoutes sets up all API routes with Huma
 // Huma automatically generates OpenAPI documentation from these definitions
 func RegisterRoutes(api huma.API, handler *Handler) {
+	api = huma.NewGroup(api, BasePath)
+
+	// Creates and imports replay their first response for a repeated
+	// Idempotency-Key instead of running again
+	idempotent := huma.Middlewares{idempotencyMiddleware(api, handler.idempotency)}
 
 	// Health check endpoint
 	huma.Register(api, huma.Operation{
@@ -24,18 +39,82 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Method:      "GET",
 		Path:        "/tree",
 		Summary:     "Get Prompt Tree",
-		Description: "Returns the complete prompt tree with all prompts and their nodes for visualization",
+		Description: "Returns the complete prompt tree with all prompts and their nodes for visualization. With ?tag=a,b only nodes carrying all the tags (directly or through their prompt) and prompts that match or contain matches are returned. With ?note_counts=true each node carries the number of notes on it.",
 		Tags:        []string{"Tree"},
 	}, handler.GetTree)
 
+	// Get progress summary
+	huma.Register(api, huma.Operation{
+		OperationID: "getTreeProgress",
+		Method:      "GET",
+		Path:        "/tree/progress",
+		Summary:     "Get Tree Progress",
+		Description: "Returns node counts by status and the percentage done or skipped, per prompt and for the whole project. Accepts the same ?tag filter as GET /tree.",
+		Tags:        []string{"Tree"},
+	}, handler.GetTreeProgress)
+
+	// Get execution plan
+	huma.Register(api, huma.Operation{
+		OperationID: "getTreePlan",
+		Method:      "GET",
+		Path:        "/tree/plan",
+		Summary:     "Get Execution Plan",
+		Description: "Returns every node ordered so that each comes after the nodes it depends on, with its level and whether it is ready to start, plus the critical path: the longest chain of unfinished nodes that each wait on the previous one.",
+		Tags:        []string{"Tree"},
+	}, handler.GetPlan)
+
+	// Render the tree with variables substituted
+	huma.Register(api, huma.Operation{
+		OperationID: "renderTree",
+		Method:      "GET",
+		Path:        "/tree/render-text",
+		Summary:     "Render Tree",
+		Description: "Returns the tree with every {{variable}} in prompt descriptions and node actions replaced. Pass overrides as ?vars=name=value, repeated per variable; others use their default. A variable with no default must be passed.",
+		Tags:        []string{"Tree"},
+	}, handler.RenderTree)
+
+	// Generate a tree with the LLM
+	huma.Register(api, huma.Operation{
+		OperationID: "generateTree",
+		Method:      "POST",
+		Path:        "/tree/generate",
+		Summary:     "Generate Tree",
+		Description: "Asks the configured LLM to break the main request (the project's own by default) into prompts and nodes. The result is checked against the import rules and returned as a draft, or replaces the current tree when apply is true. Returns 503 when no LLM provider is configured and 502 when the provider fails or answers with an unusable tree.",
+		Tags:        []string{"Tree"},
+		Middlewares: idempotent,
+	}, handler.GenerateTree)
+
+	// Patch tree with JSON Patch
+	huma.Register(api, huma.Operation{
+		OperationID: "patchTree",
+		Method:      "PATCH",
+		Path:        "/tree",
+		Summary:     "Patch Tree",
+		Description: "Applies an RFC 6902 JSON Patch (application/json-patch+json) addressed against the GET /tree shape, e.g. /prompts/0/nodes/-. The patch is applied in one transaction: prompts and nodes keep their IDs and notes, and a single tree_changed event is emitted.",
+		Tags:        []string{"Tree"},
+	}, handler.PatchTree)
+
+	// Stream change events
+	sse.Register(api, huma.Operation{
+		OperationID: "streamEvents",
+		Method:      "GET",
+		Path:        "/events",
+		Summary:     "Stream Events",
+		Description: "Server-Sent Events stream of tree, prompt, node and note changes",
+		Tags:        []string{"Events"},
+	}, map[string]any{
+		"message": services.Event{},
+	}, handler.StreamEvents)
+
 	// Export tree as JSON
 	huma.Register(api, huma.Operation{
 		OperationID: "exportTree",
 		Method:      "GET",
 		Path:        "/tree/export",
 		Summary:     "Export Tree",
-		Description: "Returns the current prompt tree as JSON for copying/exporting",
+		Description: "Returns the current prompt tree as JSON for copying/exporting. format=chat returns it as chat messages ready for an LLM: a system message from the project and main request, then one user message per node in dependency order with its prompt's title and description. format=document flattens those messages into a single text/plain prompt. Both render {{variables}} first and accept custom system_template and message_template. format=sh returns a POSIX shell script and format=make a GNU Makefile: command nodes run their action, file nodes write their action to the path in their name, and instruction and skipped nodes become comments, all in dependency order with {{variables}} rendered.",
 		Tags:        []string{"Tree"},
+		Responses:   exportResponses(api),
 	}, handler.ExportTree)
 
 	// Import tree from JSON
@@ -47,6 +126,7 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Description:   "Imports a prompt tree from JSON and replaces the current tree",
 		Tags:          []string{"Tree"},
 		DefaultStatus: 201,
+		Middlewares:   idempotent,
 	}, handler.ImportTree)
 
 	// Save current tree
@@ -91,6 +171,58 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Tags:        []string{"Tree"},
 	}, handler.DeleteSavedTree)
 
+	// Get notes for a saved tree
+	huma.Register(api, huma.Operation{
+		OperationID: "getSavedTreeNotes",
+		Method:      "GET",
+		Path:        "/tree/saves/{name}/notes",
+		Summary:     "Get Saved Tree Notes",
+		Description: "Returns the user annotations on a saved tree",
+		Tags:        []string{"Notes"},
+	}, handler.GetSavedTreeNotes)
+
+	// Create note for a saved tree
+	huma.Register(api, huma.Operation{
+		OperationID:   "createSavedTreeNote",
+		Method:        "POST",
+		Path:          "/tree/saves/{name}/notes",
+		Summary:       "Create Saved Tree Note",
+		Description:   "Creates a new annotation for a saved tree. It is kept when the tree is saved over under the same name and goes when the saved tree is deleted.",
+		Tags:          []string{"Notes"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.CreateSavedTreeNote)
+
+	// Update saved tree note
+	huma.Register(api, huma.Operation{
+		OperationID: "updateSavedTreeNote",
+		Method:      "PUT",
+		Path:        "/tree/saves/{name}/notes/{noteId}",
+		Summary:     "Update Saved Tree Note",
+		Description: "Updates a note on the saved tree. A note that is not on it is not found.",
+		Tags:        []string{"Notes"},
+	}, handler.UpdateSavedTreeNote)
+
+	// Patch saved tree note
+	huma.Register(api, huma.Operation{
+		OperationID: "patchSavedTreeNote",
+		Method:      "PATCH",
+		Path:        "/tree/saves/{name}/notes/{noteId}",
+		Summary:     "Patch Saved Tree Note",
+		Description: "Partially updates a note on the saved tree using JSON Merge Patch (RFC 7396)",
+		Tags:        []string{"Notes"},
+	}, handler.PatchSavedTreeNote)
+
+	// Delete saved tree note
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteSavedTreeNote",
+		Method:      "DELETE",
+		Path:        "/tree/saves/{name}/notes/{noteId}",
+		Summary:     "Delete Saved Tree Note",
+		Description: "Deletes a note on the saved tree",
+		Tags:        []string{"Notes"},
+	}, handler.DeleteSavedTreeNote)
+
 	// Get single prompt
 	huma.Register(api, huma.Operation{
 		OperationID: "getPrompt",
@@ -101,24 +233,48 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Tags:        []string{"Prompts"},
 	}, handler.GetPrompt)
 
+	// List prompts
+	huma.Register(api, huma.Operation{
+		OperationID: "listPrompts",
+		Method:      "GET",
+		Path:        "/prompts",
+		Summary:     "List Prompts",
+		Description: "Returns all prompts without their nodes or notes. With ?tag=a,b only prompts carrying all the tags are returned.",
+		Tags:        []string{"Prompts"},
+	}, handler.ListPrompts)
+
 	// Create prompt
 	huma.Register(api, huma.Operation{
 		OperationID:   "createPrompt",
 		Method:        "POST",
-		Path:          "/prompts/{id}",
+		Path:          "/prompts",
 		Summary:       "Create Prompt",
-		Description:   "Creates a new prompt. The ID in the path is ignored.",
+		Description:   "Creates a new prompt. The Location header points at the created prompt.",
 		Tags:          []string{"Prompts"},
 		DefaultStatus: 201,
+		Middlewares:   idempotent,
 	}, handler.CreatePrompt)
 
+	// Create prompt (legacy path)
+	huma.Register(api, huma.Operation{
+		OperationID:   "createPromptLegacy",
+		Method:        "POST",
+		Path:          "/prompts/{id}",
+		Summary:       "Create Prompt (Deprecated)",
+		Description:   "Deprecated alias of POST /prompts kept for older clients. The ID in the path is ignored.",
+		Tags:          []string{"Prompts"},
+		DefaultStatus: 201,
+		Deprecated:    true,
+		Middlewares:   idempotent,
+	}, handler.CreatePromptLegacy)
+
 	// Get nodes for a prompt
 	huma.Register(api, huma.Operation{
 		OperationID: "getPromptNodes",
 		Method:      "GET",
 		Path:        "/prompts/{id}/nodes",
 		Summary:     "Get Prompt Nodes",
-		Description: "Returns all nodes (subprompts) for a specific prompt",
+		Description: "Returns all nodes (subprompts) for a specific prompt. With ?tag=a,b only nodes carrying all the tags, directly or through the prompt, are returned.",
 		Tags:        []string{"Nodes"},
 	}, handler.GetPromptNodes)
 
@@ -131,15 +287,26 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Description:   "Creates a new node (subprompt) for a specific prompt",
 		Tags:          []string{"Nodes"},
 		DefaultStatus: 201,
+		Middlewares:   idempotent,
 	}, handler.CreateNode)
 
+	// Suggest nodes for a prompt
+	huma.Register(api, huma.Operation{
+		OperationID: "suggestNodes",
+		Method:      "POST",
+		Path:        "/prompts/{id}/suggest-nodes",
+		Summary:     "Suggest Nodes",
+		Description: "Asks the configured LLM for new nodes for a prompt, given the project, the prompt's title and description and its existing nodes. Nothing is stored: accept a suggestion by posting it to /prompts/{id}/nodes. Returns 503 when no LLM provider is configured.",
+		Tags:        []string{"Nodes"},
+	}, handler.SuggestNodes)
+
 	// Get notes for a prompt
 	huma.Register(api, huma.Operation{
 		OperationID: "getNotes",
 		Method:      "GET",
 		Path:        "/prompts/{id}/notes",
 		Summary:     "Get Notes",
-		Description: "Returns all user annotations for a specific prompt",
+		Description: "Returns the user annotations on a specific prompt as threads, newest first, each with its replies. Notes on its nodes are listed per node.",
 		Tags:        []string{"Notes"},
 	}, handler.GetNotes)
 
@@ -152,8 +319,61 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Description:   "Creates a new annotation for a specific prompt",
 		Tags:          []string{"Notes"},
 		DefaultStatus: 201,
+		Middlewares:   idempotent,
 	}, handler.CreateNote)
 
+	// Get notes for a node
+	huma.Register(api, huma.Operation{
+		OperationID: "getNodeNotes",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes",
+		Summary:     "Get Node Notes",
+		Description: "Returns the user annotations on one node of the prompt",
+		Tags:        []string{"Notes"},
+	}, handler.GetNodeNotes)
+
+	// Create note for a node
+	huma.Register(api, huma.Operation{
+		OperationID:   "createNodeNote",
+		Method:        "POST",
+		Path:          "/prompts/{id}/nodes/{nodeId}/notes",
+		Summary:       "Create Node Note",
+		Description:   "Creates a new annotation for one node rather than its whole prompt. It goes when the node is deleted.",
+		Tags:          []string{"Notes"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.CreateNodeNote)
+
+	// Update node note
+	huma.Register(api, huma.Operation{
+		OperationID: "updateNodeNote",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes/{noteId}",
+		Summary:     "Update Node Note",
+		Description: "Updates a note on the node. A note that is not on it is not found.",
+		Tags:        []string{"Notes"},
+	}, handler.UpdateNodeNote)
+
+	// Patch node note
+	huma.Register(api, huma.Operation{
+		OperationID: "patchNodeNote",
+		Method:      "PATCH",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes/{noteId}",
+		Summary:     "Patch Node Note",
+		Description: "Partially updates a note on the node using JSON Merge Patch (RFC 7396)",
+		Tags:        []string{"Notes"},
+	}, handler.PatchNodeNote)
+
+	// Delete node note
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteNodeNote",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes/{noteId}",
+		Summary:     "Delete Node Note",
+		Description: "Deletes a note on the node",
+		Tags:        []string{"Notes"},
+	}, handler.DeleteNodeNote)
+
 	// Update prompt
 	huma.Register(api, huma.Operation{
 		OperationID: "updatePrompt",
@@ -164,6 +384,16 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Tags:        []string{"Prompts"},
 	}, handler.UpdatePrompt)
 
+	// Patch prompt
+	huma.Register(api, huma.Operation{
+		OperationID: "patchPrompt",
+		Method:      "PATCH",
+		Path:        "/prompts/{id}",
+		Summary:     "Patch Prompt",
+		Description: "Partially updates a prompt using JSON Merge Patch (RFC 7396). Send as application/merge-patch+json. Omitted fields are unchanged; null clears the description.",
+		Tags:        []string{"Prompts"},
+	}, handler.PatchPrompt)
+
 	// Delete prompt
 	huma.Register(api, huma.Operation{
 		OperationID: "deletePrompt",
@@ -174,6 +404,36 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Tags:        []string{"Prompts"},
 	}, handler.DeletePrompt)
 
+	// Set a prompt's model settings
+	huma.Register(api, huma.Operation{
+		OperationID: "putPromptModel",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/model",
+		Summary:     "Set Prompt Model Settings",
+		Description: "Replaces a prompt's own model settings (provider, model, temperature, max_tokens, system_prompt). Fields left out inherit the project defaults; {} clears every override. Returns the prompt with its effective settings.",
+		Tags:        []string{"Models"},
+	}, handler.PutPromptModel)
+
+	// Get project default model settings
+	huma.Register(api, huma.Operation{
+		OperationID: "getModelDefaults",
+		Method:      "GET",
+		Path:        "/model-defaults",
+		Summary:     "Get Model Defaults",
+		Description: "Returns the project's default model settings, inherited by every prompt",
+		Tags:        []string{"Models"},
+	}, handler.GetModelDefaults)
+
+	// Set project default model settings
+	huma.Register(api, huma.Operation{
+		OperationID: "putModelDefaults",
+		Method:      "PUT",
+		Path:        "/model-defaults",
+		Summary:     "Set Model Defaults",
+		Description: "Replaces the project's default model settings; {} clears them",
+		Tags:        []string{"Models"},
+	}, handler.PutModelDefaults)
+
 	// Update node
 	huma.Register(api, huma.Operation{
 		OperationID: "updateNode",
@@ -184,6 +444,16 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Tags:        []string{"Nodes"},
 	}, handler.UpdateNode)
 
+	// Patch node
+	huma.Register(api, huma.Operation{
+		OperationID: "patchNode",
+		Method:      "PATCH",
+		Path:        "/prompts/{id}/nodes/{nodeId}",
+		Summary:     "Patch Node",
+		Description: "Partially updates a node using JSON Merge Patch (RFC 7396). Send as application/merge-patch+json. Omitted fields are unchanged; null clears the action.",
+		Tags:        []string{"Nodes"},
+	}, handler.PatchNode)
+
 	// Delete node
 	huma.Register(api, huma.Operation{
 		OperationID: "deleteNode",
@@ -200,10 +470,20 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Method:      "PUT",
 		Path:        "/prompts/{id}/notes/{noteId}",
 		Summary:     "Update Note",
-		Description: "Updates an existing note by its ID",
+		Description: "Updates a note on the prompt itself. Notes on its nodes are edited through the node, and a note that is not on the prompt is not found.",
 		Tags:        []string{"Notes"},
 	}, handler.UpdateNote)
 
+	// Patch note
+	huma.Register(api, huma.Operation{
+		OperationID: "patchNote",
+		Method:      "PATCH",
+		Path:        "/prompts/{id}/notes/{noteId}",
+		Summary:     "Patch Note",
+		Description: "Partially updates a note using JSON Merge Patch (RFC 7396)",
+		Tags:        []string{"Notes"},
+	}, handler.PatchNote)
+
 	// Delete note
 	huma.Register(api, huma.Operation{
 		OperationID: "deleteNote",
@@ -213,4 +493,528 @@ func RegisterRoutes(api huma.API, handler *Handler) {
 		Description: "Deletes a note by its ID",
 		Tags:        []string{"Notes"},
 	}, handler.DeleteNote)
-}
\ No newline at end of file
+
+	// Reply to a note
+	huma.Register(api, huma.Operation{
+		OperationID:   "replyToNote",
+		Method:        "POST",
+		Path:          "/prompts/{id}/notes/{noteId}/replies",
+		Summary:       "Reply to Note",
+		Description:   "Adds a reply to the thread of a note on the prompt itself; a note that is not on the prompt is not found. Threads are one level deep: replying to a reply answers the note it replies to.",
+		Tags:          []string{"Notes"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.ReplyToNote)
+
+	// Resolve a note thread
+	huma.Register(api, huma.Operation{
+		OperationID: "resolveNote",
+		Method:      "POST",
+		Path:        "/prompts/{id}/notes/{noteId}/resolve",
+		Summary:     "Resolve Note",
+		Description: "Marks the thread of a note on the prompt itself resolved. Replies cannot be resolved on their own (409).",
+		Tags:        []string{"Notes"},
+	}, handler.ResolveNote)
+
+	// Reopen a note thread
+	huma.Register(api, huma.Operation{
+		OperationID: "unresolveNote",
+		Method:      "POST",
+		Path:        "/prompts/{id}/notes/{noteId}/unresolve",
+		Summary:     "Unresolve Note",
+		Description: "Reopens the resolved thread of a note on the prompt itself",
+		Tags:        []string{"Notes"},
+	}, handler.UnresolveNote)
+
+	// Reply to a note on a node
+	huma.Register(api, huma.Operation{
+		OperationID:   "replyToNodeNote",
+		Method:        "POST",
+		Path:          "/prompts/{id}/nodes/{nodeId}/notes/{noteId}/replies",
+		Summary:       "Reply to Node Note",
+		Description:   "Adds a reply to the thread of a note on the node. A note that is not on the node is not found.",
+		Tags:          []string{"Notes"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.ReplyToNodeNote)
+
+	// Resolve a note thread on a node
+	huma.Register(api, huma.Operation{
+		OperationID: "resolveNodeNote",
+		Method:      "POST",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes/{noteId}/resolve",
+		Summary:     "Resolve Node Note",
+		Description: "Marks the thread of a note on the node resolved",
+		Tags:        []string{"Notes"},
+	}, handler.ResolveNodeNote)
+
+	// Reopen a note thread on a node
+	huma.Register(api, huma.Operation{
+		OperationID: "unresolveNodeNote",
+		Method:      "POST",
+		Path:        "/prompts/{id}/nodes/{nodeId}/notes/{noteId}/unresolve",
+		Summary:     "Unresolve Node Note",
+		Description: "Reopens the resolved thread of a note on the node",
+		Tags:        []string{"Notes"},
+	}, handler.UnresolveNodeNote)
+
+	// Reply to a note on a saved tree
+	huma.Register(api, huma.Operation{
+		OperationID:   "replyToSavedTreeNote",
+		Method:        "POST",
+		Path:          "/tree/saves/{name}/notes/{noteId}/replies",
+		Summary:       "Reply to Saved Tree Note",
+		Description:   "Adds a reply to the thread of a note on the saved tree. A note that is not on the saved tree is not found.",
+		Tags:          []string{"Notes"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.ReplyToSavedTreeNote)
+
+	// Resolve a note thread on a saved tree
+	huma.Register(api, huma.Operation{
+		OperationID: "resolveSavedTreeNote",
+		Method:      "POST",
+		Path:        "/tree/saves/{name}/notes/{noteId}/resolve",
+		Summary:     "Resolve Saved Tree Note",
+		Description: "Marks the thread of a note on the saved tree resolved",
+		Tags:        []string{"Notes"},
+	}, handler.ResolveSavedTreeNote)
+
+	// Reopen a note thread on a saved tree
+	huma.Register(api, huma.Operation{
+		OperationID: "unresolveSavedTreeNote",
+		Method:      "POST",
+		Path:        "/tree/saves/{name}/notes/{noteId}/unresolve",
+		Summary:     "Unresolve Saved Tree Note",
+		Description: "Reopens the resolved thread of a note on the saved tree",
+		Tags:        []string{"Notes"},
+	}, handler.UnresolveSavedTreeNote)
+
+	// Notes mentioning someone
+	huma.Register(api, huma.Operation{
+		OperationID: "getMentions",
+		Method:      "GET",
+		Path:        "/mentions/{name}",
+		Summary:     "Get Mentions",
+		Description: "Returns the notes and replies that @mention the name, newest first. With ?unresolved=true notes in resolved threads are left out.",
+		Tags:        []string{"Notes"},
+	}, handler.GetMentions)
+
+	// List tags
+	huma.Register(api, huma.Operation{
+		OperationID: "listTags",
+		Method:      "GET",
+		Path:        "/tags",
+		Summary:     "List Tags",
+		Description: "Returns every tag in use with how many prompts and nodes carry it",
+		Tags:        []string{"Tags"},
+	}, handler.ListTags)
+
+	// Tag a prompt
+	huma.Register(api, huma.Operation{
+		OperationID: "addPromptTag",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/tags/{tag}",
+		Summary:     "Add Prompt Tag",
+		Description: "Adds a tag to a prompt, creating the tag if it is new. Tags are lowercased; adding an existing tag is a no-op.",
+		Tags:        []string{"Tags"},
+	}, handler.AddPromptTag)
+
+	// Untag a prompt
+	huma.Register(api, huma.Operation{
+		OperationID: "removePromptTag",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/tags/{tag}",
+		Summary:     "Remove Prompt Tag",
+		Description: "Removes a tag from a prompt and returns the prompt",
+		Tags:        []string{"Tags"},
+	}, handler.RemovePromptTag)
+
+	// Tag a node
+	huma.Register(api, huma.Operation{
+		OperationID: "addNodeTag",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tags/{tag}",
+		Summary:     "Add Node Tag",
+		Description: "Adds a tag to a node, creating the tag if it is new. Tags are lowercased; adding an existing tag is a no-op.",
+		Tags:        []string{"Tags"},
+	}, handler.AddNodeTag)
+
+	// Untag a node
+	huma.Register(api, huma.Operation{
+		OperationID: "removeNodeTag",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tags/{tag}",
+		Summary:     "Remove Node Tag",
+		Description: "Removes a tag from a node and returns the node",
+		Tags:        []string{"Tags"},
+	}, handler.RemoveNodeTag)
+
+	// List a node's dependencies
+	huma.Register(api, huma.Operation{
+		OperationID: "getNodeDependencies",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/dependencies",
+		Summary:     "Get Node Dependencies",
+		Description: "Returns the nodes this node depends on and the nodes that depend on it",
+		Tags:        []string{"Dependencies"},
+	}, handler.GetNodeDependencies)
+
+	// Add a dependency
+	huma.Register(api, huma.Operation{
+		OperationID: "addNodeDependency",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/nodes/{nodeId}/dependencies/{dependsOnId}",
+		Summary:     "Add Node Dependency",
+		Description: "Makes the node wait on another node, which may belong to a different prompt. Adding an existing dependency is a no-op; one that would create a cycle is rejected with 409 dependency_cycle.",
+		Tags:        []string{"Dependencies"},
+	}, handler.AddNodeDependency)
+
+	// Remove a dependency
+	huma.Register(api, huma.Operation{
+		OperationID: "removeNodeDependency",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/nodes/{nodeId}/dependencies/{dependsOnId}",
+		Summary:     "Remove Node Dependency",
+		Description: "Removes a dependency and returns the node's remaining dependencies",
+		Tags:        []string{"Dependencies"},
+	}, handler.RemoveNodeDependency)
+
+	// List a node's test cases
+	huma.Register(api, huma.Operation{
+		OperationID: "listTestCases",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tests",
+		Summary:     "List Test Cases",
+		Description: "Returns the node's test cases, each with its most recent run",
+		Tags:        []string{"Tests"},
+	}, handler.ListTestCases)
+
+	// Create a test case
+	huma.Register(api, huma.Operation{
+		OperationID:   "createTestCase",
+		Method:        "POST",
+		Path:          "/prompts/{id}/nodes/{nodeId}/tests",
+		Summary:       "Create Test Case",
+		Description:   "Adds a test case to a node: variable values to run its action with and assertions (contains, not_contains, regex, json_schema) the model's output must pass",
+		Tags:          []string{"Tests"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.CreateTestCase)
+
+	// Update a test case
+	huma.Register(api, huma.Operation{
+		OperationID: "updateTestCase",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tests/{testId}",
+		Summary:     "Update Test Case",
+		Description: "Replaces a test case's name, variables and assertions. Earlier runs are kept.",
+		Tags:        []string{"Tests"},
+	}, handler.UpdateTestCase)
+
+	// Delete a test case
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteTestCase",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tests/{testId}",
+		Summary:     "Delete Test Case",
+		Description: "Deletes a test case and its run history",
+		Tags:        []string{"Tests"},
+	}, handler.DeleteTestCase)
+
+	// Run a node's test cases
+	huma.Register(api, huma.Operation{
+		OperationID: "runTests",
+		Method:      "POST",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tests/run",
+		Summary:     "Run Tests",
+		Description: "Sends the node's action, in the context of its prompt and project, to the configured LLM once per test case, checks the assertions and stores the results. ?variant= runs that variant's text instead, without promoting it. Returns 503 when no LLM provider is configured and 502, storing nothing, when the provider fails.",
+		Tags:        []string{"Tests"},
+	}, handler.RunTests)
+
+	// List a node's test runs
+	huma.Register(api, huma.Operation{
+		OperationID: "listTestRuns",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/tests/runs",
+		Summary:     "List Test Runs",
+		Description: "Returns the node's pass/fail history, newest first, optionally for one test case",
+		Tags:        []string{"Tests"},
+	}, handler.ListTestRuns)
+
+	// List a node's variants
+	huma.Register(api, huma.Operation{
+		OperationID: "listVariants",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/variants",
+		Summary:     "List Variants",
+		Description: "Returns the alternate phrasings of the node's action; the active one is the node's action",
+		Tags:        []string{"Variants"},
+	}, handler.ListVariants)
+
+	// Create a variant
+	huma.Register(api, huma.Operation{
+		OperationID:   "createVariant",
+		Method:        "POST",
+		Path:          "/prompts/{id}/nodes/{nodeId}/variants",
+		Summary:       "Create Variant",
+		Description:   "Adds a named phrasing of the node's action. The node's first variant also saves its current action as the active \"original\" variant. Set activate to make the new variant the action right away.",
+		Tags:          []string{"Variants"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.CreateVariant)
+
+	// Compare two variants
+	huma.Register(api, huma.Operation{
+		OperationID: "compareVariants",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/variants/compare",
+		Summary:     "Compare Variants",
+		Description: "Sets two variants side by side: token estimates, test runs made with each and how many passed, and a word-level diff from a to b",
+		Tags:        []string{"Variants"},
+	}, handler.CompareVariants)
+
+	// Update a variant
+	huma.Register(api, huma.Operation{
+		OperationID: "updateVariant",
+		Method:      "PUT",
+		Path:        "/prompts/{id}/nodes/{nodeId}/variants/{variantId}",
+		Summary:     "Update Variant",
+		Description: "Renames a variant and replaces its text. Editing the active variant changes the node's action too.",
+		Tags:        []string{"Variants"},
+	}, handler.UpdateVariant)
+
+	// Delete a variant
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteVariant",
+		Method:      "DELETE",
+		Path:        "/prompts/{id}/nodes/{nodeId}/variants/{variantId}",
+		Summary:     "Delete Variant",
+		Description: "Deletes a variant. The active variant cannot be deleted (409).",
+		Tags:        []string{"Variants"},
+	}, handler.DeleteVariant)
+
+	// Promote a variant
+	huma.Register(api, huma.Operation{
+		OperationID: "promoteVariant",
+		Method:      "POST",
+		Path:        "/prompts/{id}/nodes/{nodeId}/variants/{variantId}/promote",
+		Summary:     "Promote Variant",
+		Description: "Makes the variant active and its text the node's action, so the tree, exports and test runs use it. Honors If-Match against the node's version.",
+		Tags:        []string{"Variants"},
+	}, handler.PromoteVariant)
+
+	// Run a prompt's command nodes
+	huma.Register(api, huma.Operation{
+		OperationID: "runCommands",
+		Method:      "POST",
+		Path:        "/prompts/{id}/commands/run",
+		Summary:     "Run Commands",
+		Description: "Executes the prompt's command nodes one after another in plan order, with variables filled in, in the runner's working directory. Output is streamed on /events as it arrives and every run is logged on its node. The first failure stops the rest unless continue_on_error is set. Returns 503 when the runner is disabled.",
+		Tags:        []string{"Commands"},
+	}, handler.RunCommands)
+
+	// Run one command node
+	huma.Register(api, huma.Operation{
+		OperationID: "runNodeCommand",
+		Method:      "POST",
+		Path:        "/prompts/{id}/nodes/{nodeId}/commands/run",
+		Summary:     "Run Node Command",
+		Description: "Executes a single command node, whatever its status, streaming its output on /events and logging the run. Returns 404 if the node is not in the prompt, 409 for nodes that are not commands and 503 when the runner is disabled.",
+		Tags:        []string{"Commands"},
+	}, handler.RunNodeCommand)
+
+	// List a node's command runs
+	huma.Register(api, huma.Operation{
+		OperationID: "listCommandRuns",
+		Method:      "GET",
+		Path:        "/prompts/{id}/nodes/{nodeId}/commands/runs",
+		Summary:     "List Command Runs",
+		Description: "Returns the node's command run logs, newest first, with their exit status and output. Returns 404 if the node is not in the prompt.",
+		Tags:        []string{"Commands"},
+	}, handler.ListCommandRuns)
+
+	// Start a plan run
+	huma.Register(api, huma.Operation{
+		OperationID:   "startPlanRun",
+		Method:        "POST",
+		Path:          "/runs",
+		Summary:       "Start Plan Run",
+		Description:   "Starts walking a saved tree's plan, e.g. for one repository. The run copies the saved tree's nodes as todo steps in dependency order and tracks their status, timing and notes on its own; the live tree is not touched.",
+		Tags:          []string{"Runs"},
+		DefaultStatus: 201,
+		Middlewares:   idempotent,
+	}, handler.StartPlanRun)
+
+	// List plan runs
+	huma.Register(api, huma.Operation{
+		OperationID: "listPlanRuns",
+		Method:      "GET",
+		Path:        "/runs",
+		Summary:     "List Plan Runs",
+		Description: "Returns plan runs, newest first, with their progress, optionally only those of one saved tree or with one status",
+		Tags:        []string{"Runs"},
+	}, handler.ListPlanRuns)
+
+	// Get a plan run
+	huma.Register(api, huma.Operation{
+		OperationID: "getPlanRun",
+		Method:      "GET",
+		Path:        "/runs/{id}",
+		Summary:     "Get Plan Run",
+		Description: "Returns a plan run with its steps in plan order",
+		Tags:        []string{"Runs"},
+	}, handler.GetPlanRun)
+
+	// Set a step's status and notes in a run
+	huma.Register(api, huma.Operation{
+		OperationID: "updatePlanRunStep",
+		Method:      "PUT",
+		Path:        "/runs/{id}/steps/{nodeId}",
+		Summary:     "Update Plan Run Step",
+		Description: "Sets one step's status, and its notes if given, in an active run. Going in progress records the start time and done or skipped the finish time.",
+		Tags:        []string{"Runs"},
+	}, handler.UpdatePlanRunStep)
+
+	// Advance a plan run
+	huma.Register(api, huma.Operation{
+		OperationID: "advancePlanRun",
+		Method:      "POST",
+		Path:        "/runs/{id}/advance",
+		Summary:     "Advance Plan Run",
+		Description: "Finishes the step in progress as done (or skipped or blocked) with optional notes, then puts the first ready step in progress",
+		Tags:        []string{"Runs"},
+	}, handler.AdvancePlanRun)
+
+	// Complete a plan run
+	huma.Register(api, huma.Operation{
+		OperationID: "completePlanRun",
+		Method:      "POST",
+		Path:        "/runs/{id}/complete",
+		Summary:     "Complete Plan Run",
+		Description: "Closes an active run as completed or abandoned. A closed run can no longer change.",
+		Tags:        []string{"Runs"},
+	}, handler.CompletePlanRun)
+
+	// Summarize a plan run
+	huma.Register(api, huma.Operation{
+		OperationID: "planRunReport",
+		Method:      "GET",
+		Path:        "/runs/{id}/report",
+		Summary:     "Plan Run Report",
+		Description: "Sums up a run: total duration and time spent per prompt, progress, the slowest steps, blocked steps and the notes left on steps",
+		Tags:        []string{"Runs"},
+	}, handler.PlanRunReport)
+
+	// List variables
+	huma.Register(api, huma.Operation{
+		OperationID: "listVariables",
+		Method:      "GET",
+		Path:        "/variables",
+		Summary:     "List Variables",
+		Description: "Returns the project variables usable as {{name}} in prompt descriptions and node actions",
+		Tags:        []string{"Variables"},
+	}, handler.ListVariables)
+
+	// Create or update a variable
+	huma.Register(api, huma.Operation{
+		OperationID: "putVariable",
+		Method:      "PUT",
+		Path:        "/variables/{name}",
+		Summary:     "Put Variable",
+		Description: "Creates a variable or replaces its default and description",
+		Tags:        []string{"Variables"},
+	}, handler.PutVariable)
+
+	// Delete a variable
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteVariable",
+		Method:      "DELETE",
+		Path:        "/variables/{name}",
+		Summary:     "Delete Variable",
+		Description: "Deletes a variable. A variable still used by a prompt or node is rejected with 409 variable_in_use.",
+		Tags:        []string{"Variables"},
+	}, handler.DeleteVariable)
+
+	// List templates
+	huma.Register(api, huma.Operation{
+		OperationID: "listTemplates",
+		Method:      "GET",
+		Path:        "/templates",
+		Summary:     "List Templates",
+		Description: "Returns every template with its parameters, without the tree",
+		Tags:        []string{"Templates"},
+	}, handler.ListTemplates)
+
+	// Get a template
+	huma.Register(api, huma.Operation{
+		OperationID: "getTemplate",
+		Method:      "GET",
+		Path:        "/templates/{name}",
+		Summary:     "Get Template",
+		Description: "Returns a template including its tree",
+		Tags:        []string{"Templates"},
+	}, handler.GetTemplate)
+
+	// Create or replace a template
+	huma.Register(api, huma.Operation{
+		OperationID: "putTemplate",
+		Method:      "PUT",
+		Path:        "/templates/{name}",
+		Summary:     "Put Template",
+		Description: "Creates or replaces a template. {{parameter}} placeholders may appear in any text in the tree. The tree must be valid once instantiated; built-in templates cannot be replaced.",
+		Tags:        []string{"Templates"},
+	}, handler.PutTemplate)
+
+	// Delete a template
+	huma.Register(api, huma.Operation{
+		OperationID: "deleteTemplate",
+		Method:      "DELETE",
+		Path:        "/templates/{name}",
+		Summary:     "Delete Template",
+		Description: "Deletes a template. Built-in templates cannot be deleted.",
+		Tags:        []string{"Templates"},
+	}, handler.DeleteTemplate)
+
+	// Instantiate a template
+	huma.Register(api, huma.Operation{
+		OperationID: "instantiateTemplate",
+		Method:      "POST",
+		Path:        "/templates/{name}/instantiate",
+		Summary:     "Instantiate Template",
+		Description: "Replaces the current tree with the template's tree, substituting the given parameter values and defaults for the rest, and returns the new tree",
+		Tags:        []string{"Templates"},
+		Middlewares: idempotent,
+	}, handler.InstantiateTemplate)
+
+	// Run a batch of operations atomically
+	huma.Register(api, huma.Operation{
+		OperationID: "batch",
+		Method:      "POST",
+		Path:        "/batch",
+		Summary:     "Batch Operations",
+		Description: "Runs an ordered list of prompt, node and note operations in one transaction. Later operations can reference IDs created earlier as \"$ref\". If any operation fails, nothing is applied.",
+		Tags:        []string{"Batch"},
+	}, handler.Batch)
+}
+
+// exportResponses describes each export format: the tree or chat messages
+// as JSON, and text for the document, shell script and Makefile
+func exportResponses(api huma.API) map[string]*huma.Response {
+	registry := api.OpenAPI().Components.Schemas
+	text := &huma.Schema{Type: huma.TypeString}
+	return map[string]*huma.Response{
+		"200": {
+			Description: "The tree in the requested format",
+			Content: map[string]*huma.MediaType{
+				"application/json": {Schema: &huma.Schema{OneOf: []*huma.Schema{
+					registry.Schema(reflect.TypeFor[models.TreeResponse](), true, ""),
+					registry.Schema(reflect.TypeFor[models.ChatExport](), true, ""),
+				}}},
+				"text/plain":         {Schema: text},
+				"text/x-shellscript": {Schema: text},
+				"text/x-makefile":    {Schema: text},
+			},
+		},
+	}
+}
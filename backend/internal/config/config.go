@@ -1,7 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +15,37 @@ type Config struct {
 	Port        string
 	Environment string
 	APIKey      string
+
+	// IdempotencyWindow is how long a response stored under an
+	// Idempotency-Key is replayed before the key can be used again.
+	IdempotencyWindow time.Duration
+
+	// SeedPath is a seed file or directory; empty uses the embedded seeds.
+	// SeedTemplate names the one an empty database is created from.
+	SeedPath     string
+	SeedTemplate string
+
+	// LLM configures the model behind the generation endpoints. With no
+	// LLM_PROVIDER set, OpenAI is used when LLM_API_KEY is present and the
+	// endpoints are disabled otherwise.
+	LLMProvider string
+	LLMBaseURL  string
+	LLMAPIKey   string
+	LLMModel    string
+	LLMTimeout  time.Duration
+
+	// TokenBudget is the context window, in tokens, that each prompt and
+	// the whole tree are checked against; 0 turns the warnings off.
+	TokenBudget int
+
+	// Runner executes command nodes on this machine. It is off unless
+	// RUNNER_ENABLED is true, and then needs RUNNER_WORKDIR and an API_KEY.
+	// Commands see only the environment variables named in RUNNER_ENV.
+	RunnerEnabled   bool
+	RunnerWorkDir   string
+	RunnerTimeout   time.Duration
+	RunnerEnv       []string
+	RunnerMaxOutput int
 }
 
 func Load() (*Config, error) {
@@ -21,7 +56,64 @@ func Load() (*Config, error) {
 		Port:        getEnv("PORT", "8080"),
 		Environment: getEnv("ENVIRONMENT", "development"),
 		APIKey:      getEnv("API_KEY", ""),
+
+		SeedPath:     getEnv("SEED_PATH", ""),
+		SeedTemplate: getEnv("SEED_TEMPLATE", ""),
+
+		LLMProvider: getEnv("LLM_PROVIDER", ""),
+		LLMBaseURL:  getEnv("LLM_BASE_URL", "https://api.openai.com/v1"),
+		LLMAPIKey:   getEnv("LLM_API_KEY", ""),
+		LLMModel:    getEnv("LLM_MODEL", "gpt-4o-mini"),
+	}
+	if config.LLMProvider == "" && config.LLMAPIKey != "" {
+		config.LLMProvider = "openai"
+	}
+
+	window, err := time.ParseDuration(getEnv("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("IDEMPOTENCY_WINDOW must be a positive duration like 24h")
+	}
+	config.IdempotencyWindow = window
+
+	timeout, err := time.ParseDuration(getEnv("LLM_TIMEOUT", "60s"))
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("LLM_TIMEOUT must be a positive duration like 60s")
+	}
+	config.LLMTimeout = timeout
+
+	budget, err := strconv.Atoi(getEnv("TOKEN_BUDGET", "128000"))
+	if err != nil || budget < 0 {
+		return nil, fmt.Errorf("TOKEN_BUDGET must be a number of tokens, or 0 for none")
+	}
+	config.TokenBudget = budget
+
+	enabled, err := strconv.ParseBool(getEnv("RUNNER_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("RUNNER_ENABLED must be true or false")
+	}
+	if enabled && config.APIKey == "" {
+		return nil, fmt.Errorf("RUNNER_ENABLED requires API_KEY: without it anyone who can reach the server could run commands")
+	}
+	config.RunnerEnabled = enabled
+	config.RunnerWorkDir = getEnv("RUNNER_WORKDIR", "")
+
+	runnerTimeout, err := time.ParseDuration(getEnv("RUNNER_TIMEOUT", "5m"))
+	if err != nil || runnerTimeout <= 0 {
+		return nil, fmt.Errorf("RUNNER_TIMEOUT must be a positive duration like 5m")
+	}
+	config.RunnerTimeout = runnerTimeout
+
+	for _, name := range strings.Split(getEnv("RUNNER_ENV", "PATH,HOME,LANG"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			config.RunnerEnv = append(config.RunnerEnv, name)
+		}
+	}
+
+	maxOutput, err := strconv.Atoi(getEnv("RUNNER_MAX_OUTPUT", "262144"))
+	if err != nil || maxOutput <= 0 {
+		return nil, fmt.Errorf("RUNNER_MAX_OUTPUT must be a positive number of bytes")
 	}
+	config.RunnerMaxOutput = maxOutput
 
 	return config, nil
 }
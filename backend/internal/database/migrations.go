@@ -78,5 +78,329 @@ func Migrate() error {
 	}
 	fmt.Println("✓ Project settings table ready")
 
+	// Row versions back optimistic concurrency (ETag / If-Match). The tree
+	// version is bumped by a statement trigger on any prompt or node change so
+	// /tree/import can guard against the tree as a whole.
+	_, err = DB.Exec(`
+		ALTER TABLE prompts ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+		ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS tree_version INTEGER NOT NULL DEFAULT 1;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add version columns: %w", err)
+	}
+
+	_, err = DB.Exec(`
+		CREATE OR REPLACE FUNCTION bump_tree_version() RETURNS trigger AS $$
+		BEGIN
+			UPDATE project_settings SET tree_version = tree_version + 1 WHERE id = 1;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS prompts_bump_tree_version ON prompts;
+		CREATE TRIGGER prompts_bump_tree_version
+			AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON prompts
+			FOR EACH STATEMENT EXECUTE FUNCTION bump_tree_version();
+
+		DROP TRIGGER IF EXISTS nodes_bump_tree_version ON nodes;
+		CREATE TRIGGER nodes_bump_tree_version
+			AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON nodes
+			FOR EACH STATEMENT EXECUTE FUNCTION bump_tree_version();
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tree version triggers: %w", err)
+	}
+	fmt.Println("✓ Version tracking ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key VARCHAR(255) NOT NULL,
+			scope VARCHAR(512) NOT NULL,
+			request_hash CHAR(64) NOT NULL,
+			status_code INTEGER,
+			headers JSONB,
+			response_body BYTEA,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (key, scope)
+		);
+		CREATE INDEX IF NOT EXISTS idempotency_keys_created_at ON idempotency_keys (created_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotency_keys table: %w", err)
+	}
+	fmt.Println("✓ Idempotency keys table ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(64) NOT NULL UNIQUE
+		);
+		CREATE TABLE IF NOT EXISTS prompt_tags (
+			prompt_id INTEGER NOT NULL REFERENCES prompts(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (prompt_id, tag_id)
+		);
+		CREATE TABLE IF NOT EXISTS node_tags (
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (node_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS prompt_tags_tag_id ON prompt_tags (tag_id);
+		CREATE INDEX IF NOT EXISTS node_tags_tag_id ON node_tags (tag_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create tags tables: %w", err)
+	}
+	fmt.Println("✓ Tags tables ready")
+
+	_, err = DB.Exec(`
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'todo';
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP;
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS completed_at TIMESTAMP;
+		ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_status_check;
+		ALTER TABLE nodes ADD CONSTRAINT nodes_status_check
+			CHECK (status IN ('todo', 'in_progress', 'done', 'skipped', 'blocked'));
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add node status columns: %w", err)
+	}
+	fmt.Println("✓ Node status columns ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS node_dependencies (
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			depends_on_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			PRIMARY KEY (node_id, depends_on_id),
+			CHECK (node_id <> depends_on_id)
+		);
+		CREATE INDEX IF NOT EXISTS node_dependencies_depends_on_id ON node_dependencies (depends_on_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create node_dependencies table: %w", err)
+	}
+	fmt.Println("✓ Node dependencies table ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS variables (
+			name VARCHAR(64) PRIMARY KEY,
+			default_value TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT ''
+		);
+
+		DROP TRIGGER IF EXISTS variables_bump_tree_version ON variables;
+		CREATE TRIGGER variables_bump_tree_version
+			AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON variables
+			FOR EACH STATEMENT EXECUTE FUNCTION bump_tree_version();
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create variables table: %w", err)
+	}
+	fmt.Println("✓ Variables table ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS templates (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			parameters JSONB NOT NULL DEFAULT '[]',
+			tree_data JSONB NOT NULL,
+			builtin BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create templates table: %w", err)
+	}
+	fmt.Println("✓ Templates table ready")
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS test_cases (
+			id SERIAL PRIMARY KEY,
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			variables JSONB NOT NULL DEFAULT '{}',
+			assertions JSONB NOT NULL DEFAULT '[]',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS test_cases_node_id ON test_cases (node_id);
+
+		CREATE TABLE IF NOT EXISTS test_runs (
+			id SERIAL PRIMARY KEY,
+			test_case_id INTEGER NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			passed BOOLEAN NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			failures JSONB NOT NULL DEFAULT '[]',
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS test_runs_node_id ON test_runs (node_id, id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create test tables: %w", err)
+	}
+	fmt.Println("✓ Test case tables ready")
+
+	// Model settings: project defaults on the settings row, overrides on each
+	// prompt. An empty object inherits everything.
+	_, err = DB.Exec(`
+		ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS model_config JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE prompts ADD COLUMN IF NOT EXISTS model_config JSONB NOT NULL DEFAULT '{}';
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add model_config columns: %w", err)
+	}
+	fmt.Println("✓ Model config columns ready")
+
+	// Variants are alternate phrasings of a node's action. The node's action
+	// always holds the active variant's text; the trigger keeps the active
+	// variant in step when the action is edited directly.
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS node_variants (
+			id SERIAL PRIMARY KEY,
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			name VARCHAR(64) NOT NULL,
+			action TEXT NOT NULL DEFAULT '',
+			active BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (node_id, name)
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS node_variants_one_active ON node_variants (node_id) WHERE active;
+
+		CREATE OR REPLACE FUNCTION sync_active_variant() RETURNS trigger AS $$
+		BEGIN
+			UPDATE node_variants
+			SET action = NEW.action, updated_at = CURRENT_TIMESTAMP
+			WHERE node_id = NEW.id AND active AND action IS DISTINCT FROM NEW.action;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS nodes_sync_active_variant ON nodes;
+		CREATE TRIGGER nodes_sync_active_variant
+			AFTER UPDATE OF action ON nodes
+			FOR EACH ROW EXECUTE FUNCTION sync_active_variant();
+
+		ALTER TABLE test_runs ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES node_variants(id) ON DELETE SET NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create node_variants table: %w", err)
+	}
+	fmt.Println("✓ Node variants table ready")
+
+	_, err = DB.Exec(`
+		ALTER TABLE nodes ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'instruction'
+			CHECK (kind IN ('instruction', 'command', 'file'));
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add nodes.kind column: %w", err)
+	}
+	fmt.Println("✓ Node kind column ready")
+
+	// Command runs log each execution of a command node by the runner
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS command_runs (
+			id SERIAL PRIMARY KEY,
+			node_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+			command TEXT NOT NULL,
+			status VARCHAR(16) NOT NULL DEFAULT 'running'
+				CHECK (status IN ('running', 'succeeded', 'failed', 'timed_out', 'canceled')),
+			exit_code INTEGER,
+			output TEXT NOT NULL DEFAULT '',
+			truncated BOOLEAN NOT NULL DEFAULT FALSE,
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS command_runs_node_id ON command_runs (node_id, id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create command_runs table: %w", err)
+	}
+	fmt.Println("✓ Command runs table ready")
+
+	// Plan runs walk a saved tree's plan, keeping their own copy of its
+	// steps and how each went
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS plan_runs (
+			id SERIAL PRIMARY KEY,
+			saved_tree_id INTEGER REFERENCES saved_trees(id) ON DELETE SET NULL,
+			saved_tree_name VARCHAR(255) NOT NULL,
+			snapshot_at TIMESTAMP NOT NULL,
+			label VARCHAR(255) NOT NULL DEFAULT '',
+			status VARCHAR(16) NOT NULL DEFAULT 'active'
+				CHECK (status IN ('active', 'completed', 'abandoned')),
+			notes TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			completed_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS plan_run_steps (
+			run_id INTEGER NOT NULL REFERENCES plan_runs(id) ON DELETE CASCADE,
+			node_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			prompt_id INTEGER NOT NULL,
+			prompt_title VARCHAR(255) NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			depends_on INTEGER[] NOT NULL DEFAULT '{}',
+			status VARCHAR(20) NOT NULL DEFAULT 'todo'
+				CHECK (status IN ('todo', 'in_progress', 'done', 'skipped', 'blocked')),
+			notes TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMP,
+			finished_at TIMESTAMP,
+			PRIMARY KEY (run_id, node_id)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create plan run tables: %w", err)
+	}
+	fmt.Println("✓ Plan run tables ready")
+
+	// A note belongs to exactly one of a prompt, a node or a saved tree. Node
+	// notes take their prompt from the node, so they follow it when it moves.
+	_, err = DB.Exec(`
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS node_id INTEGER REFERENCES nodes(id) ON DELETE CASCADE;
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS saved_tree_id INTEGER REFERENCES saved_trees(id) ON DELETE CASCADE;
+		ALTER TABLE notes DROP CONSTRAINT IF EXISTS notes_target_check;
+		ALTER TABLE notes ADD CONSTRAINT notes_target_check
+			CHECK (num_nonnulls(prompt_id, node_id, saved_tree_id) = 1);
+		CREATE INDEX IF NOT EXISTS idx_notes_node_id ON notes(node_id);
+		CREATE INDEX IF NOT EXISTS idx_notes_saved_tree_id ON notes(saved_tree_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add note targets: %w", err)
+	}
+	fmt.Println("✓ Note targets ready")
+
+	// Replies copy their thread's target so they follow it and are listed
+	// with it; resolving applies to the thread's top note
+	_, err = DB.Exec(`
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES notes(id) ON DELETE CASCADE;
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS author VARCHAR(255) NOT NULL DEFAULT '';
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+		ALTER TABLE notes ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+		UPDATE notes SET updated_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE updated_at IS NULL;
+		ALTER TABLE notes ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP;
+		ALTER TABLE notes ALTER COLUMN updated_at SET NOT NULL;
+		CREATE INDEX IF NOT EXISTS idx_notes_parent_id ON notes(parent_id);
+
+		CREATE TABLE IF NOT EXISTS note_mentions (
+			note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+			name VARCHAR(64) NOT NULL,
+			PRIMARY KEY (note_id, name)
+		);
+		CREATE INDEX IF NOT EXISTS idx_note_mentions_name ON note_mentions(name);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add note threads: %w", err)
+	}
+	fmt.Println("✓ Note threads and mentions ready")
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,302 @@
+// Package jsonpatch applies RFC 6902 JSON Patch documents to JSON values.
+// Pointers follow RFC 6901 and arrays are addressed by index, with "-"
+// meaning one past the last element.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrTestFailed   = errors.New("test operation failed")
+)
+
+// Operation is a single RFC 6902 operation.
+type Operation struct {
+	Op    string          `json:"op" enum:"add,remove,replace,move,copy,test" doc:"Operation to perform"`
+	Path  string          `json:"path" doc:"JSON Pointer to the target location, e.g. /prompts/0/nodes/-"`
+	From  string          `json:"from,omitempty" doc:"JSON Pointer to the source location (move and copy only)"`
+	Value json.RawMessage `json:"value,omitempty" doc:"Value to add, replace or test against"`
+}
+
+// Apply runs ops against doc in order and returns the patched document. The
+// patch is all-or-nothing: the first failing operation aborts it.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	var root any
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, fmt.Errorf("%w: document is not valid JSON: %v", ErrInvalidPatch, err)
+	}
+
+	for i, op := range ops {
+		var err error
+		root, err = applyOne(root, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+func applyOne(root any, op Operation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %q requires a value", ErrInvalidPatch, op.Op)
+		}
+		var value any
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("%w: value is not valid JSON: %v", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(root, path, value)
+		case "replace":
+			return replace(root, path, value)
+		}
+
+		current, err := get(root, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return root, nil
+
+	case "remove":
+		root, _, err = remove(root, path)
+		return root, err
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			value, err := get(root, from)
+			if err != nil {
+				return nil, err
+			}
+			return add(root, path, deepCopy(value))
+		}
+
+		if op.Path == op.From {
+			return root, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("%w: cannot move a value into one of its children", ErrInvalidPatch)
+		}
+		root, value, err := remove(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, path, value)
+	}
+
+	return nil, fmt.Errorf("%w: unsupported operation %q", ErrInvalidPatch, op.Op)
+}
+
+// parsePointer splits an RFC 6901 pointer into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array reference token. When allowEnd is set, "-" and
+// len(list) are accepted as the position after the last element.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("%w: array index %d out of range", ErrInvalidPatch, index)
+	}
+	return index, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := node.(type) {
+		case map[string]any:
+			child, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+			}
+			node = child
+		case []any:
+			index, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, fmt.Errorf("%w: cannot traverse into a scalar at %q", ErrInvalidPatch, token)
+		}
+	}
+	return node, nil
+}
+
+// update rewrites the child at path[0] of node with fn and returns the new
+// node. Arrays are rebuilt because inserts and removals change their length.
+func update(node any, path []string, fn func(child any) (any, error)) (any, error) {
+	token := path[0]
+	switch container := node.(type) {
+	case map[string]any:
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+		}
+		updated, err := fn(child)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = updated
+		return container, nil
+	case []any:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := fn(container[index])
+		if err != nil {
+			return nil, err
+		}
+		container[index] = updated
+		return container, nil
+	}
+	return nil, fmt.Errorf("%w: cannot traverse into a scalar at %q", ErrInvalidPatch, token)
+}
+
+func add(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	if len(path) > 1 {
+		return update(node, path, func(child any) (any, error) {
+			return add(child, path[1:], value)
+		})
+	}
+
+	token := path[0]
+	switch container := node.(type) {
+	case map[string]any:
+		container[token] = value
+		return container, nil
+	case []any:
+		index, err := arrayIndex(token, len(container), true)
+		if err != nil {
+			return nil, err
+		}
+		container = append(container, nil)
+		copy(container[index+1:], container[index:])
+		container[index] = value
+		return container, nil
+	}
+	return nil, fmt.Errorf("%w: cannot add a member to a scalar", ErrInvalidPatch)
+}
+
+func remove(node any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, fmt.Errorf("%w: cannot remove the whole document", ErrInvalidPatch)
+	}
+	if len(path) > 1 {
+		var removed any
+		updated, err := update(node, path, func(child any) (any, error) {
+			var err error
+			child, removed, err = remove(child, path[1:])
+			return child, err
+		})
+		return updated, removed, err
+	}
+
+	token := path[0]
+	switch container := node.(type) {
+	case map[string]any:
+		removed, ok := container[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: member %q does not exist", ErrInvalidPatch, token)
+		}
+		delete(container, token)
+		return container, removed, nil
+	case []any:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		removed := container[index]
+		return append(container[:index], container[index+1:]...), removed, nil
+	}
+	return nil, nil, fmt.Errorf("%w: cannot remove a member from a scalar", ErrInvalidPatch)
+}
+
+func replace(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	if _, err := get(node, path); err != nil {
+		return nil, err
+	}
+	if len(path) > 1 {
+		return update(node, path, func(child any) (any, error) {
+			return replace(child, path[1:], value)
+		})
+	}
+	return update(node, path, func(any) (any, error) {
+		return value, nil
+	})
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, child := range v {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, child := range v {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	}
+	return value
+}
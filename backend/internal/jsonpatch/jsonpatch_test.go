@@ -0,0 +1,146 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const doc = `{"a":{"b":1},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`
+
+func ops(t *testing.T, patch string) []Operation {
+	t.Helper()
+	var parsed []Operation
+	if err := json.Unmarshal([]byte(patch), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+// sameJSON compares two documents ignoring member order
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var g, w any
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &w); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := json.Marshal(g)
+	b, _ := json.Marshal(w)
+	return string(a) == string(b)
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name, patch, want string
+	}{
+		{"add member", `[{"op":"add","path":"/a/c","value":2}]`,
+			`{"a":{"b":1,"c":2},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"add replaces member", `[{"op":"add","path":"/a/b","value":[]}]`,
+			`{"a":{"b":[]},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"add inserts into array", `[{"op":"add","path":"/list/1","value":9}]`,
+			`{"a":{"b":1},"list":[1,9,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"add appends with -", `[{"op":"add","path":"/list/-","value":4}]`,
+			`{"a":{"b":1},"list":[1,2,3,4],"a/b":"slash","m~n":"tilde"}`},
+		{"add at length appends", `[{"op":"add","path":"/list/3","value":4}]`,
+			`{"a":{"b":1},"list":[1,2,3,4],"a/b":"slash","m~n":"tilde"}`},
+		{"add replaces document", `[{"op":"add","path":"","value":{"x":true}}]`,
+			`{"x":true}`},
+		{"remove member", `[{"op":"remove","path":"/a/b"}]`,
+			`{"a":{},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"remove from array", `[{"op":"remove","path":"/list/0"}]`,
+			`{"a":{"b":1},"list":[2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"replace member", `[{"op":"replace","path":"/a/b","value":"new"}]`,
+			`{"a":{"b":"new"},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"replace array element", `[{"op":"replace","path":"/list/2","value":null}]`,
+			`{"a":{"b":1},"list":[1,2,null],"a/b":"slash","m~n":"tilde"}`},
+		{"move member", `[{"op":"move","from":"/a/b","path":"/c"}]`,
+			`{"a":{},"c":1,"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"move within array", `[{"op":"move","from":"/list/0","path":"/list/-"}]`,
+			`{"a":{"b":1},"list":[2,3,1],"a/b":"slash","m~n":"tilde"}`},
+		{"move onto itself", `[{"op":"move","from":"/a","path":"/a"}]`, doc},
+		{"copy member", `[{"op":"copy","from":"/a","path":"/list/0"}]`,
+			`{"a":{"b":1},"list":[{"b":1},1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"copy is deep", `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`,
+			`{"a":{"b":1},"c":{"b":2},"list":[1,2,3],"a/b":"slash","m~n":"tilde"}`},
+		{"test passes", `[{"op":"test","path":"/a","value":{"b":1}},{"op":"test","path":"/list/1","value":2}]`, doc},
+		{"~1 escapes slash", `[{"op":"replace","path":"/a~1b","value":"x"}]`,
+			`{"a":{"b":1},"list":[1,2,3],"a/b":"x","m~n":"tilde"}`},
+		{"~0 escapes tilde", `[{"op":"remove","path":"/m~0n"}]`,
+			`{"a":{"b":1},"list":[1,2,3],"a/b":"slash"}`},
+		{"ops run in order", `[{"op":"add","path":"/list/-","value":4},{"op":"remove","path":"/list/0"},{"op":"test","path":"/list","value":[2,3,4]}]`,
+			`{"a":{"b":1},"list":[2,3,4],"a/b":"slash","m~n":"tilde"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), ops(t, tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameJSON(t, got, tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFails(t *testing.T) {
+	tests := []struct {
+		name, patch string
+		want        error
+	}{
+		{"test mismatch", `[{"op":"test","path":"/a/b","value":2}]`, ErrTestFailed},
+		{"test type mismatch", `[{"op":"test","path":"/a/b","value":"1"}]`, ErrTestFailed},
+		{"add without value", `[{"op":"add","path":"/a/c"}]`, ErrInvalidPatch},
+		{"add past end", `[{"op":"add","path":"/list/4","value":1}]`, ErrInvalidPatch},
+		{"add under missing parent", `[{"op":"add","path":"/x/y","value":1}]`, ErrInvalidPatch},
+		{"remove missing member", `[{"op":"remove","path":"/a/c"}]`, ErrInvalidPatch},
+		{"remove with -", `[{"op":"remove","path":"/list/-"}]`, ErrInvalidPatch},
+		{"remove document", `[{"op":"remove","path":""}]`, ErrInvalidPatch},
+		{"replace missing member", `[{"op":"replace","path":"/a/c","value":1}]`, ErrInvalidPatch},
+		{"leading zero index", `[{"op":"replace","path":"/list/01","value":1}]`, ErrInvalidPatch},
+		{"negative index", `[{"op":"remove","path":"/list/-1"}]`, ErrInvalidPatch},
+		{"pointer without slash", `[{"op":"remove","path":"a"}]`, ErrInvalidPatch},
+		{"traverse scalar", `[{"op":"add","path":"/a/b/c","value":1}]`, ErrInvalidPatch},
+		{"move into child", `[{"op":"move","from":"/a","path":"/a/x"}]`, ErrInvalidPatch},
+		{"copy from missing", `[{"op":"copy","from":"/x","path":"/y"}]`, ErrInvalidPatch},
+		{"unknown op", `[{"op":"merge","path":"/a"}]`, ErrInvalidPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(doc), ops(t, tt.patch))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if got != nil {
+				t.Errorf("failed patch returned %s", got)
+			}
+		})
+	}
+}
+
+func TestApplyIsAllOrNothing(t *testing.T) {
+	input := []byte(doc)
+	_, err := Apply(input, ops(t, `[
+		{"op":"remove","path":"/a"},
+		{"op":"add","path":"/list/-","value":4},
+		{"op":"test","path":"/list/0","value":0}
+	]`))
+	if !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("err = %v, want ErrTestFailed", err)
+	}
+	if string(input) != doc {
+		t.Errorf("input changed to %s", input)
+	}
+
+	// The document is untouched, so the next patch sees the earlier ops undone
+	got, err := Apply(input, ops(t, `[{"op":"test","path":"/a","value":{"b":1}},{"op":"test","path":"/list","value":[1,2,3]}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameJSON(t, got, doc) {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Fake is a deterministic provider for development and tests. It ignores the
+// instructions and turns each sentence of the user's input into an outline
+// step, so the same input always produces the same answer.
+//
+// The answer is a JSON object with both "prompts" (one per sentence, each
+// with a fixed set of nodes) and "nodes" (those of the first prompt), which
+// covers the shapes the services ask for.
+type Fake struct{}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+type fakeNode struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+type fakePrompt struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Nodes       []fakeNode `json:"nodes"`
+}
+
+var sentenceEnd = regexp.MustCompile(`[.!?;\n]+`)
+
+// maxFakePrompts keeps the outline small whatever the input
+const maxFakePrompts = 5
+
+func (f *Fake) Complete(ctx context.Context, req Request) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var steps []string
+	for _, s := range sentenceEnd.Split(req.User, -1) {
+		if s = strings.Join(strings.Fields(s), " "); s != "" {
+			steps = append(steps, s)
+		}
+		if len(steps) == maxFakePrompts {
+			break
+		}
+	}
+	if len(steps) == 0 {
+		steps = []string{"Plan the work"}
+	}
+
+	prompts := make([]fakePrompt, 0, len(steps))
+	for _, step := range steps {
+		prompts = append(prompts, fakePrompt{
+			Title:       truncate(step, 60),
+			Description: step,
+			Nodes: []fakeNode{
+				{Name: "Design", Action: "Outline the approach for: " + step},
+				{Name: "Implement", Action: "Carry out: " + step},
+				{Name: "Verify", Action: "Check the result of: " + step},
+			},
+		})
+	}
+
+	out, err := json.Marshal(map[string]any{
+		"prompts": prompts,
+		"nodes":   prompts[0].Nodes,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type fakeAnswer struct {
+	Prompts []fakePrompt `json:"prompts"`
+	Nodes   []fakeNode   `json:"nodes"`
+}
+
+func completeFake(t *testing.T, user string) fakeAnswer {
+	t.Helper()
+	text, err := NewFake().Complete(context.Background(), Request{System: "ignored", User: user, JSON: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var answer fakeAnswer
+	if err := json.Unmarshal([]byte(text), &answer); err != nil {
+		t.Fatalf("answer is not JSON: %v\n%s", err, text)
+	}
+	return answer
+}
+
+func TestFakeOutlinesSentences(t *testing.T) {
+	answer := completeFake(t, "Build the track.  Add   cars!\nKeep score?")
+
+	var titles []string
+	for _, p := range answer.Prompts {
+		titles = append(titles, p.Title)
+		if len(p.Nodes) != 3 {
+			t.Errorf("prompt %q has %d nodes, want 3", p.Title, len(p.Nodes))
+		}
+	}
+	if want := "Build the track|Add cars|Keep score"; strings.Join(titles, "|") != want {
+		t.Errorf("titles = %q, want %q", titles, want)
+	}
+	if len(answer.Nodes) != 3 || answer.Nodes[1].Action != "Carry out: Build the track" {
+		t.Errorf("nodes = %+v, want the first prompt's", answer.Nodes)
+	}
+}
+
+func TestFakeIsDeterministic(t *testing.T) {
+	fake := NewFake()
+	req := Request{User: "One. Two. Three."}
+	first, err := fake.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		if again, _ := fake.Complete(context.Background(), req); again != first {
+			t.Fatalf("answers differ:\n%s\n%s", first, again)
+		}
+	}
+}
+
+func TestFakeLimits(t *testing.T) {
+	if answer := completeFake(t, " \n. ;"); len(answer.Prompts) != 1 || answer.Prompts[0].Title != "Plan the work" {
+		t.Errorf("empty input gave %+v", answer.Prompts)
+	}
+
+	if answer := completeFake(t, "a. b. c. d. e. f. g."); len(answer.Prompts) != maxFakePrompts {
+		t.Errorf("got %d prompts, want at most %d", len(answer.Prompts), maxFakePrompts)
+	}
+
+	long := strings.Repeat("é", 100)
+	answer := completeFake(t, long)
+	if title := []rune(answer.Prompts[0].Title); len(title) != 60 || title[59] != '…' {
+		t.Errorf("title = %q, want 59 runes and an ellipsis", string(title))
+	}
+	if answer.Prompts[0].Description != long {
+		t.Error("description was truncated")
+	}
+}
+
+func TestFakeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewFake().Complete(ctx, Request{User: "x"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package llm talks to large language models. Callers depend on Provider so
+// the OpenAI-compatible client can be swapped for the deterministic Fake in
+// development and tests.
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Request is one completion request: instructions for the model and the
+// user's input. JSON asks the model to answer with a single JSON object.
+// Model, Temperature and MaxTokens override the provider's defaults when set.
+type Request struct {
+	System      string
+	User        string
+	JSON        bool
+	Model       string
+	Temperature *float64
+	MaxTokens   int
+}
+
+// Provider completes a request and returns the model's text
+type Provider interface {
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// Config selects and configures a provider
+type Config struct {
+	Provider string // "openai", "fake" or "" for none
+	BaseURL  string
+	APIKey   string
+	Model    string
+	Timeout  time.Duration
+}
+
+// New returns the configured provider, or nil when none is configured
+func New(cfg Config) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "openai":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("LLM_API_KEY is required for the openai provider")
+		}
+		return NewOpenAI(cfg.BaseURL, cfg.APIKey, cfg.Model, cfg.Timeout), nil
+	case "fake":
+		return NewFake(), nil
+	}
+	return nil, fmt.Errorf("unknown LLM provider %q, expected openai or fake", cfg.Provider)
+}
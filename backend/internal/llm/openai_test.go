@@ -0,0 +1,109 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// provider serves one canned answer and records the request it got
+func provider(t *testing.T, status int, answer string) (*OpenAI, *chatRequest) {
+	t.Helper()
+	var got chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(server.Close)
+
+	return NewOpenAI(server.URL+"/v1/", "secret", "default-model", 5*time.Second), &got
+}
+
+func TestOpenAIComplete(t *testing.T) {
+	client, got := provider(t, http.StatusOK, `{"id":"x","choices":[{"index":0,"message":{"role":"assistant","content":"{\"ok\":true}"}},{"message":{"content":"second"}}]}`)
+
+	temperature := 0.2
+	text, err := client.Complete(context.Background(), Request{
+		System: "Be brief", User: "Hi", JSON: true,
+		Model: "gpt-4o-mini", Temperature: &temperature, MaxTokens: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != `{"ok":true}` {
+		t.Errorf("text = %q, want the first choice", text)
+	}
+
+	if got.Model != "gpt-4o-mini" || got.MaxTokens != 100 || got.Temperature == nil || *got.Temperature != 0.2 {
+		t.Errorf("request = %+v", got)
+	}
+	if len(got.Messages) != 2 || got.Messages[0] != (chatMessage{"system", "Be brief"}) || got.Messages[1] != (chatMessage{"user", "Hi"}) {
+		t.Errorf("messages = %+v", got.Messages)
+	}
+	if got.ResponseFormat == nil || got.ResponseFormat.Type != "json_object" {
+		t.Errorf("response_format = %+v", got.ResponseFormat)
+	}
+}
+
+func TestOpenAIDefaults(t *testing.T) {
+	client, got := provider(t, http.StatusOK, `{"choices":[{"message":{"content":"hello"}}]}`)
+
+	if _, err := client.Complete(context.Background(), Request{User: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "default-model" || got.Temperature != nil || got.MaxTokens != 0 || got.ResponseFormat != nil {
+		t.Errorf("request = %+v", got)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Role != "user" {
+		t.Errorf("messages = %+v, want only the user's", got.Messages)
+	}
+}
+
+func TestOpenAIErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		answer string
+		want   string
+	}{
+		{"error status", http.StatusTooManyRequests, `{"error":{"message":"slow down"}}` + "\n", `provider returned 429 Too Many Requests: {"error":{"message":"slow down"}}`},
+		{"not json", http.StatusOK, `<html>`, "failed to parse response"},
+		{"no choices", http.StatusOK, `{"choices":[]}`, "provider returned no choices"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, _ := provider(t, tt.status, tt.answer)
+			_, err := client.Complete(context.Background(), Request{User: "Hi"})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAITimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewOpenAI(server.URL, "secret", "m", 50*time.Millisecond)
+	if _, err := client.Complete(context.Background(), Request{User: "Hi"}); err == nil || !strings.Contains(err.Error(), "request failed") {
+		t.Errorf("err = %v, want the request to time out", err)
+	}
+}
@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens a model would see for a piece of text
+type Tokenizer interface {
+	Count(text string) int
+}
+
+// Approx estimates token counts the way BPE tokenizers such as OpenAI's
+// cl100k split text, without shipping a vocabulary. Text is cut into the
+// same pieces as cl100k's pre-tokenizer (words with one leading space or
+// symbol, runs of up to three digits, punctuation, whitespace) and each piece
+// is costed: ASCII letters take a token per six, other letters one each. It
+// is an estimate for budgeting, not an exact count; tokens_test.go checks it
+// against known cl100k counts.
+type Approx struct{}
+
+func NewApprox() *Approx {
+	return &Approx{}
+}
+
+// pretokenize is cl100k's pre-tokenizer pattern, minus the lookahead Go's
+// regexp does not support. Without it a run of spaces before a word stays
+// whole instead of giving its last space to the word, which costs the same.
+var pretokenize = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+func (a *Approx) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenize.FindAllString(text, -1) {
+		count += pieceTokens(piece)
+	}
+	return count
+}
+
+// pieceTokens costs one piece, going by its last rune: a leading space or
+// symbol is folded into the word after it, and trailing newlines into the
+// punctuation before them
+func pieceTokens(piece string) int {
+	last, _ := utf8.DecodeLastRuneInString(piece)
+
+	switch {
+	case unicode.IsLetter(last):
+		ascii, other := 0, 0
+		for _, r := range piece {
+			switch {
+			case !unicode.IsLetter(r):
+			case r < utf8.RuneSelf:
+				ascii++
+			default:
+				other++
+			}
+		}
+		return ceilDiv(ascii, 6) + other
+	case unicode.IsNumber(last):
+		return 1
+	case strings.TrimSpace(piece) == "":
+		return 1
+	default:
+		return ceilDiv(utf8.RuneCountInString(strings.TrimSpace(piece)), 2)
+	}
+}
+
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"slices"
+	"testing"
+)
+
+// cl100kCounts are token counts from OpenAI's cl100k_base encoding, as
+// tiktoken reports them
+var cl100kCounts = []struct {
+	text string
+	want int
+}{
+	{"hello world", 2},
+	{"Hello, world!", 4},
+	{"tiktoken is great!", 6},
+	{"antidisestablishmentarianism", 6},
+	{"2 + 2 = 4", 7},
+	{"お誕生日おめでとう", 9},
+}
+
+func TestApproxCountsNearCl100k(t *testing.T) {
+	approx := NewApprox()
+	for _, tt := range cl100kCounts {
+		got := approx.Count(tt.text)
+		if diff := got - tt.want; diff < -1 || diff > 1 {
+			t.Errorf("Count(%q) = %d, cl100k has %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPretokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"hello world", []string{"hello", " world"}},
+		{"Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{"2 + 2 = 4", []string{"2", " +", " ", "2", " =", " ", "4"}},
+		{"12345", []string{"123", "45"}},
+		{"it's done.\n\nNext", []string{"it", "'s", " done", ".\n\n", "Next"}},
+		{"(call)", []string{"(call", ")"}},
+	}
+	for _, tt := range tests {
+		if got := pretokenize.FindAllString(tt.text, -1); !slices.Equal(got, tt.want) {
+			t.Errorf("pieces of %q = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestApproxCount(t *testing.T) {
+	approx := NewApprox()
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"   ", 1},
+		{"a\n\nb", 3},
+		{" extraordinarily", 3},
+		{"café", 2},
+		{"1234567", 3},
+		{"...", 2},
+	}
+	for _, tt := range tests {
+		if got := approx.Count(tt.text); got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// BatchID identifies a resource in a batch operation. It is either a literal
+// ID or a "$name" reference to the ID created by an earlier operation whose
+// ref is "name".
+type BatchID struct {
+	ID  int
+	Ref string
+}
+
+func (b *BatchID) UnmarshalJSON(data []byte) error {
+	var ref string
+	if err := json.Unmarshal(data, &ref); err == nil {
+		if len(ref) < 2 || !strings.HasPrefix(ref, "$") {
+			return fmt.Errorf("reference %q must have the form $name", ref)
+		}
+		b.Ref = ref[1:]
+		return nil
+	}
+	return json.Unmarshal(data, &b.ID)
+}
+
+func (b BatchID) MarshalJSON() ([]byte, error) {
+	if b.Ref != "" {
+		return json.Marshal("$" + b.Ref)
+	}
+	return json.Marshal(b.ID)
+}
+
+// Schema documents the field as either an integer ID or a "$ref" string.
+func (b BatchID) Schema(r huma.Registry) *huma.Schema {
+	return &huma.Schema{
+		OneOf: []*huma.Schema{
+			{Type: huma.TypeInteger, Minimum: &[]float64{1}[0]},
+			{Type: huma.TypeString, Pattern: `^\$.+`},
+		},
+	}
+}
+
+type BatchOperation struct {
+	Op       string          `json:"op" enum:"createPrompt,updatePrompt,patchPrompt,deletePrompt,createNode,updateNode,patchNode,deleteNode,createNote,updateNote,patchNote,deleteNote" doc:"Operation to run"`
+	Ref      string          `json:"ref,omitempty" pattern:"^[A-Za-z0-9_-]+$" doc:"Name later operations can use as \"$name\" to refer to the ID this operation creates"`
+	PromptID *BatchID        `json:"promptId,omitempty" doc:"Prompt the operation targets (update/patch/deletePrompt) or adds to (createNode, createNote)"`
+	NodeID   *BatchID        `json:"nodeId,omitempty" doc:"Node the operation targets (update/patch/deleteNode) or, for createNote, annotates instead of the whole prompt"`
+	NoteID   *BatchID        `json:"noteId,omitempty" doc:"Note the operation targets (update/patch/deleteNote); a promptId and nodeId given with it must be the note's"`
+	Version  *int            `json:"version,omitempty" doc:"Expected version, like If-Match on the single-resource routes"`
+	Body     json.RawMessage `json:"body,omitempty" doc:"Request body the single-resource route would take, e.g. CreateNodeRequest for createNode"`
+}
+
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations" minItems:"1" maxItems:"100" doc:"Operations to run in order inside one transaction"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index" doc:"Position of the operation in the request"`
+	Op     string `json:"op" doc:"Operation that ran"`
+	Ref    string `json:"ref,omitempty" doc:"Ref given to the operation, if any"`
+	ID     int    `json:"id" doc:"ID of the resource the operation created or touched"`
+	Result any    `json:"result,omitempty" doc:"The resource as the single-resource route would return it; omitted for deletes"`
+}
+
+type BatchResponse struct {
+	Results []BatchResult `json:"results" doc:"One result per operation, in request order"`
+}
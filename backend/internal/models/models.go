@@ -3,25 +3,82 @@ package models
 import "time"
 
 type Prompt struct {
-	ID          int    `json:"id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	ProjectName string `json:"project_name,omitempty"`
+	ID          int          `json:"id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	ProjectName string       `json:"project_name,omitempty"`
+	Version     int          `json:"version"`
+	Tags        []string     `json:"tags"`
+	Model       *ModelConfig `json:"model,omitempty"`
 }
 
+// ModelConfig holds the model settings for a prompt or, as project defaults,
+// for the whole tree. Empty fields inherit: a prompt's from the project
+// defaults, the defaults' from the server configuration.
+type ModelConfig struct {
+	Provider     string   `json:"provider,omitempty" maxLength:"64" doc:"Provider the step is meant for, e.g. openai; informational, runs use the server's configured provider"`
+	Model        string   `json:"model,omitempty" maxLength:"255" doc:"Model name, e.g. gpt-4o-mini"`
+	Temperature  *float64 `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature, 0-2"`
+	MaxTokens    int      `json:"max_tokens,omitempty" minimum:"0" doc:"Cap on the tokens generated per reply"`
+	SystemPrompt string   `json:"system_prompt,omitempty" doc:"Replaces the system message built from the project and main request"`
+}
+
+// Node statuses track a step's progress when the tree is used as a plan
+const (
+	NodeStatusTodo       = "todo"
+	NodeStatusInProgress = "in_progress"
+	NodeStatusDone       = "done"
+	NodeStatusSkipped    = "skipped"
+	NodeStatusBlocked    = "blocked"
+)
+
+// NodeStatuses lists every valid node status
+var NodeStatuses = []string{NodeStatusTodo, NodeStatusInProgress, NodeStatusDone, NodeStatusSkipped, NodeStatusBlocked}
+
+// Node kinds say what a node's action holds: prose for a person or model,
+// a shell command, or the contents of the file the node's name is the path of
+const (
+	NodeKindInstruction = "instruction"
+	NodeKindCommand     = "command"
+	NodeKindFile        = "file"
+)
+
+// NodeKinds lists every valid node kind
+var NodeKinds = []string{NodeKindInstruction, NodeKindCommand, NodeKindFile}
+
 // Node represents a subprompt/step under a prompt (e.g., "npm create vite")
 type Node struct {
-	ID       int    `json:"id,omitempty"`
-	PromptID int    `json:"prompt_id,omitempty"`
-	Name     string `json:"name"`
-	Action   string `json:"action"`
+	ID              int        `json:"id,omitempty"`
+	PromptID        int        `json:"prompt_id,omitempty"`
+	Name            string     `json:"name"`
+	Action          string     `json:"action"`
+	Kind            string     `json:"kind" enum:"instruction,command,file" doc:"What the action holds: instruction text, a shell command, or file contents with the name as the path"`
+	Version         int        `json:"version"`
+	Status          string     `json:"status" enum:"todo,in_progress,done,skipped,blocked" doc:"Execution status"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty" doc:"When the status last changed"`
+	StartedAt       *time.Time `json:"started_at,omitempty" doc:"When the node first went in_progress"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty" doc:"When the node was done or skipped; cleared if reopened"`
+	Tags            []string   `json:"tags"`
 }
 
+// Note is an annotation on a prompt, one of its nodes or a saved tree. Node
+// notes also report the node's prompt; saved tree notes have no prompt. A
+// note with a parent is a reply in that note's thread.
 type Note struct {
-	ID        int       `json:"id"`
-	PromptID  int       `json:"prompt_id"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         int        `json:"id"`
+	PromptID   int        `json:"prompt_id,omitempty"`
+	NodeID     *int       `json:"node_id,omitempty"`
+	SavedTree  string     `json:"saved_tree,omitempty"`
+	ParentID   *int       `json:"parent_id,omitempty" doc:"Note this one replies to"`
+	Author     string     `json:"author,omitempty"`
+	Content    string     `json:"content"`
+	Mentions   []string   `json:"mentions" doc:"Names @mentioned in the content, lowercased"`
+	Resolved   bool       `json:"resolved" doc:"Whether the thread is resolved; replies report their thread's state"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Version    int        `json:"version"`
+	Replies    []Note     `json:"replies,omitempty" doc:"Replies, oldest first; only filled in on note lists"`
 }
 
 type SavedTree struct {
@@ -32,30 +89,82 @@ type SavedTree struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// IdempotencyRecord is a response stored under an Idempotency-Key. A nil
+// StatusCode means the original request is still running.
+type IdempotencyRecord struct {
+	Key         string
+	Scope       string
+	RequestHash string
+	StatusCode  *int
+	Headers     map[string][]string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
 type TreeResponse struct {
 	Project     string       `json:"project" doc:"Project name"`
 	MainRequest string       `json:"mainRequest" doc:"Main project description"`
+	Model       *ModelConfig `json:"model,omitempty" doc:"Project default model settings, inherited by every prompt"`
+	Variables   []Variable   `json:"variables,omitempty" doc:"Project variables, usable as {{name}} in prompt descriptions and node actions"`
+	Progress    *Progress    `json:"progress,omitempty" doc:"Status rollup over every node; ignored on import"`
+	Tokens      *TokenUsage  `json:"tokens,omitempty" doc:"Estimated tokens for the whole tree, with budget warnings; ignored on import"`
 	Prompts     []PromptNode `json:"prompts" doc:"List of prompts with their nodes"`
 }
 
+// TokenUsage is an estimated token count checked against the configured
+// context budget
+type TokenUsage struct {
+	Count      int      `json:"count" doc:"Estimated tokens"`
+	Budget     int      `json:"budget,omitempty" doc:"Configured context budget; omitted when there is none"`
+	OverBudget bool     `json:"over_budget" doc:"Whether count exceeds the budget"`
+	Warnings   []string `json:"warnings,omitempty" doc:"On the tree: every prompt, and the tree itself, that exceeds the budget"`
+}
+
+// Progress counts nodes by status. Done and skipped nodes count as complete.
+type Progress struct {
+	Total      int     `json:"total" doc:"Number of nodes"`
+	Todo       int     `json:"todo"`
+	InProgress int     `json:"in_progress"`
+	Done       int     `json:"done"`
+	Skipped    int     `json:"skipped"`
+	Blocked    int     `json:"blocked"`
+	Percent    float64 `json:"percent" doc:"Share of nodes done or skipped, 0-100"`
+}
+
 type PromptNode struct {
-	ID          int           `json:"id" doc:"Prompt ID"`
-	Title       string        `json:"title" doc:"Prompt title"`
-	Description string        `json:"description,omitempty" doc:"Prompt description"`
-	Nodes       []NodeSummary `json:"nodes,omitempty" doc:"Child nodes of this prompt"`
+	ID             int           `json:"id" doc:"Prompt ID"`
+	Title          string        `json:"title" doc:"Prompt title"`
+	Description    string        `json:"description,omitempty" doc:"Prompt description"`
+	Tags           []string      `json:"tags" doc:"Tags on this prompt"`
+	Model          *ModelConfig  `json:"model,omitempty" doc:"This prompt's own model settings"`
+	EffectiveModel *ModelConfig  `json:"effective_model,omitempty" doc:"Model settings after inheriting from the project defaults; ignored on import"`
+	Progress       *Progress     `json:"progress,omitempty" doc:"Status rollup over this prompt's nodes; ignored on import"`
+	Tokens         *TokenUsage   `json:"tokens,omitempty" doc:"Estimated tokens for the title, description and every node; ignored on import"`
+	Nodes          []NodeSummary `json:"nodes,omitempty" doc:"Child nodes of this prompt"`
 }
 
 type NodeSummary struct {
-	ID     int    `json:"id" doc:"Node ID"`
-	Name   string `json:"name" doc:"Node name"`
-	Action string `json:"action,omitempty" doc:"Node action description"`
+	ID        int      `json:"id" doc:"Node ID"`
+	Name      string   `json:"name" doc:"Node name"`
+	Action    string   `json:"action,omitempty" doc:"Node action description"`
+	Status    string   `json:"status,omitempty" enum:"todo,in_progress,done,skipped,blocked" doc:"Execution status; defaults to todo on import"`
+	Kind      string   `json:"kind,omitempty" enum:"instruction,command,file" doc:"What the action holds; defaults to instruction on import"`
+	Tags      []string `json:"tags" doc:"Tags on this node"`
+	DependsOn []int    `json:"depends_on,omitempty" doc:"IDs of nodes that must finish first; on import they refer to node IDs in the same document"`
+	Tokens    int      `json:"tokens,omitempty" doc:"Estimated tokens for the name and action; ignored on import"`
+	Variant   string   `json:"variant,omitempty" doc:"Name of the active variant, whose text is the action, when the node has variants; ignored on import"`
+	Notes     int      `json:"notes,omitempty" doc:"Number of notes on the node, filled in when asked for with note_counts; ignored on import"`
 }
 
 type PromptDetail struct {
-	ID          int    `json:"id" doc:"Prompt ID"`
-	Title       string `json:"title" doc:"Prompt title"`
-	Description string `json:"description" doc:"Prompt description"`
-	ProjectName string `json:"project_name" doc:"Parent project name"`
+	ID             int          `json:"id" doc:"Prompt ID"`
+	Title          string       `json:"title" doc:"Prompt title"`
+	Description    string       `json:"description" doc:"Prompt description"`
+	ProjectName    string       `json:"project_name" doc:"Parent project name"`
+	Version        int          `json:"version" doc:"Row version, also sent as the ETag header"`
+	Tags           []string     `json:"tags" doc:"Tags on this prompt"`
+	Model          *ModelConfig `json:"model,omitempty" doc:"This prompt's own model settings"`
+	EffectiveModel *ModelConfig `json:"effective_model,omitempty" doc:"Model settings after inheriting from the project defaults"`
 }
 
 type CreatePromptRequest struct {
@@ -66,10 +175,12 @@ type CreatePromptRequest struct {
 type CreateNodeRequest struct {
 	Name   string `json:"name" minLength:"1" doc:"Name of the node (required)"`
 	Action string `json:"action,omitempty" doc:"Action description"`
+	Kind   string `json:"kind,omitempty" enum:"instruction,command,file" doc:"What the action holds; defaults to instruction"`
 }
 
 type CreateNoteRequest struct {
-	Content string `json:"content" minLength:"1" doc:"Note content (required)"`
+	Content string `json:"content" minLength:"1" doc:"Note content (required); @name mentions are recorded"`
+	Author  string `json:"author,omitempty" maxLength:"255" doc:"Who wrote the note"`
 }
 
 type UpdatePromptRequest struct {
@@ -80,6 +191,8 @@ type UpdatePromptRequest struct {
 type UpdateNodeRequest struct {
 	Name   string `json:"name,omitempty" doc:"Name of the node"`
 	Action string `json:"action,omitempty" doc:"Action description"`
+	Status string `json:"status,omitempty" enum:"todo,in_progress,done,skipped,blocked" doc:"Execution status"`
+	Kind   string `json:"kind,omitempty" enum:"instruction,command,file" doc:"What the action holds"`
 }
 
 type UpdateNoteRequest struct {
@@ -102,4 +215,360 @@ type SavedTreeInfo struct {
 
 type SavedTreeListResponse struct {
 	Trees []SavedTreeInfo `json:"trees" doc:"List of saved trees"`
+}
+
+// TagInfo is a tag in use and how many prompts and nodes carry it
+type TagInfo struct {
+	Name    string `json:"name" doc:"Tag name"`
+	Prompts int    `json:"prompts" doc:"Number of prompts with this tag"`
+	Nodes   int    `json:"nodes" doc:"Number of nodes with this tag"`
+}
+
+type TagListResponse struct {
+	Tags []TagInfo `json:"tags" doc:"Tags in use, sorted by name"`
+}
+
+// PromptProgress is one prompt's line in the progress summary
+type PromptProgress struct {
+	ID       int      `json:"id" doc:"Prompt ID"`
+	Title    string   `json:"title" doc:"Prompt title"`
+	Progress Progress `json:"progress"`
+}
+
+type TreeProgressResponse struct {
+	Project  string           `json:"project" doc:"Project name"`
+	Progress Progress         `json:"progress" doc:"Rollup over every node in the project"`
+	Prompts  []PromptProgress `json:"prompts" doc:"Rollup per prompt"`
+}
+
+// NodeDependencies lists the edges touching one node
+type NodeDependencies struct {
+	NodeID     int   `json:"node_id" doc:"Node ID"`
+	DependsOn  []int `json:"depends_on" doc:"Nodes that must finish before this one"`
+	Dependents []int `json:"dependents" doc:"Nodes waiting on this one"`
+}
+
+// PlanStep is one node in the execution plan
+type PlanStep struct {
+	ID          int    `json:"id" doc:"Node ID"`
+	PromptID    int    `json:"prompt_id" doc:"Prompt the node belongs to"`
+	PromptTitle string `json:"prompt_title" doc:"Title of that prompt"`
+	Name        string `json:"name" doc:"Node name"`
+	Status      string `json:"status" doc:"Execution status"`
+	DependsOn   []int  `json:"depends_on" doc:"Nodes that must finish first"`
+	Level       int    `json:"level" doc:"Length of the longest dependency chain before this node; steps on the same level can run in parallel"`
+	Ready       bool   `json:"ready" doc:"Todo with every dependency done or skipped"`
+}
+
+type PlanResponse struct {
+	Steps        []PlanStep `json:"steps" doc:"Every node in dependency order, ties broken by tree order"`
+	CriticalPath []int      `json:"critical_path" doc:"Longest chain of unfinished nodes that each wait on the previous, first step first"`
+}
+
+// Variable is a project-level value substituted for {{name}} placeholders
+type Variable struct {
+	Name        string `json:"name" doc:"Variable name, as used in {{name}}"`
+	Default     string `json:"default" doc:"Value used when rendering without an override; empty means a value must be passed"`
+	Description string `json:"description,omitempty" doc:"What the variable controls"`
+}
+
+type PutVariableRequest struct {
+	Default     string `json:"default,omitempty" doc:"Default value"`
+	Description string `json:"description,omitempty" doc:"What the variable controls"`
+}
+
+type VariableListResponse struct {
+	Variables []Variable `json:"variables" doc:"Project variables, sorted by name"`
+}
+
+// Template is a reusable tree. Its parameters are substituted for {{name}}
+// anywhere in the tree's text when it is instantiated; placeholders naming
+// the tree's own variables are kept for rendering.
+type Template struct {
+	Name        string       `json:"name" doc:"Template name"`
+	Description string       `json:"description,omitempty" doc:"What the template builds"`
+	Parameters  []Variable   `json:"parameters" doc:"Values asked for on instantiation; one with an empty default is required"`
+	Builtin     bool         `json:"builtin" doc:"Shipped with the server; cannot be replaced or deleted"`
+	Tree        TreeResponse `json:"tree" doc:"Tree produced on instantiation, with {{parameter}} placeholders"`
+	CreatedAt   time.Time    `json:"created_at" doc:"When the template was created"`
+	UpdatedAt   time.Time    `json:"updated_at" doc:"When the template was last updated"`
+}
+
+type TemplateInfo struct {
+	Name        string     `json:"name" doc:"Template name"`
+	Description string     `json:"description,omitempty" doc:"What the template builds"`
+	Parameters  []Variable `json:"parameters" doc:"Values asked for on instantiation"`
+	Builtin     bool       `json:"builtin" doc:"Shipped with the server"`
+	UpdatedAt   time.Time  `json:"updated_at" doc:"When the template was last updated"`
+}
+
+type TemplateListResponse struct {
+	Templates []TemplateInfo `json:"templates" doc:"Templates sorted by name"`
+}
+
+type PutTemplateRequest struct {
+	Description string       `json:"description,omitempty" doc:"What the template builds"`
+	Parameters  []Variable   `json:"parameters,omitempty" doc:"Declared parameters"`
+	Tree        TreeResponse `json:"tree" doc:"Tree with {{parameter}} placeholders"`
+}
+
+type InstantiateTemplateRequest struct {
+	Values map[string]string `json:"values,omitempty" doc:"Parameter values by name; omitted parameters use their default"`
+}
+
+type GenerateTreeRequest struct {
+	MainRequest string `json:"mainRequest,omitempty" maxLength:"10000" doc:"What to build; defaults to the project's main request"`
+	Apply       bool   `json:"apply,omitempty" doc:"Replace the current tree with the generated one instead of returning a draft"`
+}
+
+type GenerateTreeResponse struct {
+	Applied bool         `json:"applied" doc:"Whether the generated tree replaced the current one"`
+	Tree    TreeResponse `json:"tree" doc:"Generated tree; when applied, the stored tree with its new IDs"`
+}
+
+type SuggestNodesRequest struct {
+	Guidance string `json:"guidance,omitempty" maxLength:"2000" doc:"Extra direction for the suggestions, e.g. what to focus on"`
+	Max      int    `json:"max,omitempty" minimum:"1" maximum:"20" default:"5" doc:"Most suggestions to return"`
+}
+
+type SuggestNodesResponse struct {
+	PromptID    int                 `json:"prompt_id" doc:"Prompt the suggestions are for"`
+	Suggestions []CreateNodeRequest `json:"suggestions" doc:"Proposed nodes, not yet stored; POST any of them to /prompts/{id}/nodes to accept it"`
+}
+
+// ChatMessage is one message of a tree exported as a chat
+type ChatMessage struct {
+	Role     string       `json:"role" enum:"system,user" doc:"system for the project context, user for each node"`
+	Content  string       `json:"content" doc:"Message text"`
+	PromptID int          `json:"prompt_id,omitempty" doc:"Prompt the node belongs to"`
+	NodeID   int          `json:"node_id,omitempty" doc:"Node the message asks for"`
+	Variant  string       `json:"variant,omitempty" doc:"Active variant of the node's action, if it has variants"`
+	Model    *ModelConfig `json:"model,omitempty" doc:"Model settings to send the message with: the project defaults on the system message, the prompt's effective settings on each node"`
+}
+
+type ChatExport struct {
+	Project  string        `json:"project" doc:"Project name"`
+	Messages []ChatMessage `json:"messages" doc:"System message, then one user message per node in dependency order"`
+}
+
+// Assertion is one check a test case makes on the model's output
+type Assertion struct {
+	Type   string         `json:"type" enum:"contains,not_contains,regex,json_schema" doc:"contains and not_contains look for value in the output, regex matches value as a pattern, json_schema parses the output as JSON and validates it against schema"`
+	Value  string         `json:"value,omitempty" doc:"Text or pattern, for every type but json_schema"`
+	Schema map[string]any `json:"schema,omitempty" doc:"JSON Schema (draft 2020-12 unless $schema says otherwise), for json_schema"`
+}
+
+// TestCase runs a node's action through a model with the given variables
+// and checks the output
+type TestCase struct {
+	ID         int               `json:"id" doc:"Test case ID"`
+	NodeID     int               `json:"node_id" doc:"Node under test"`
+	Name       string            `json:"name" doc:"Test case name"`
+	Variables  map[string]string `json:"variables" doc:"Values for project variables, overriding their defaults"`
+	Assertions []Assertion       `json:"assertions" doc:"Checks that must all pass"`
+	LastRun    *TestRun          `json:"last_run,omitempty" doc:"Most recent run, if any"`
+	CreatedAt  time.Time         `json:"created_at" doc:"When the test case was created"`
+	UpdatedAt  time.Time         `json:"updated_at" doc:"When the test case was last updated"`
+}
+
+type TestCaseRequest struct {
+	Name       string            `json:"name" minLength:"1" maxLength:"255" doc:"Test case name"`
+	Variables  map[string]string `json:"variables,omitempty" doc:"Values for project variables, overriding their defaults"`
+	Assertions []Assertion       `json:"assertions" minItems:"1" doc:"Checks that must all pass"`
+}
+
+type TestCaseListResponse struct {
+	TestCases []TestCase `json:"test_cases" doc:"The node's test cases, oldest first"`
+}
+
+// TestRun is the stored result of running one test case
+type TestRun struct {
+	ID         int       `json:"id" doc:"Run ID"`
+	TestCaseID int       `json:"test_case_id" doc:"Test case that ran"`
+	NodeID     int       `json:"node_id" doc:"Node under test"`
+	Passed     bool      `json:"passed" doc:"Whether every assertion passed"`
+	Output     string    `json:"output" doc:"What the model answered"`
+	Failures   []string  `json:"failures" doc:"One message per failed assertion"`
+	DurationMS int       `json:"duration_ms" doc:"How long the model took"`
+	VariantID  *int      `json:"variant_id,omitempty" doc:"Variant of the action that ran, if the node has variants"`
+	CreatedAt  time.Time `json:"created_at" doc:"When the run happened"`
+}
+
+type TestRunListResponse struct {
+	Runs []TestRun `json:"runs" doc:"Runs, newest first"`
+}
+
+type RunTestsResponse struct {
+	NodeID int       `json:"node_id" doc:"Node under test"`
+	Passed int       `json:"passed" doc:"Number of test cases that passed"`
+	Failed int       `json:"failed" doc:"Number of test cases that failed"`
+	Runs   []TestRun `json:"runs" doc:"One run per test case"`
+}
+
+// NodeVariant is an alternate phrasing of a node's action. A node with
+// variants has exactly one active, and its action is that variant's text.
+type NodeVariant struct {
+	ID        int       `json:"id" doc:"Variant ID"`
+	NodeID    int       `json:"node_id" doc:"Node the variant belongs to"`
+	Name      string    `json:"name" doc:"Variant name, unique per node"`
+	Action    string    `json:"action" doc:"Action text"`
+	Active    bool      `json:"active" doc:"Whether this is the node's current action"`
+	CreatedAt time.Time `json:"created_at" doc:"When the variant was created"`
+	UpdatedAt time.Time `json:"updated_at" doc:"When the variant was last updated"`
+}
+
+type CreateVariantRequest struct {
+	Name     string `json:"name" minLength:"1" maxLength:"64" doc:"Variant name, unique per node"`
+	Action   string `json:"action" doc:"Action text"`
+	Activate bool   `json:"activate,omitempty" doc:"Make the new variant the node's action right away"`
+}
+
+type UpdateVariantRequest struct {
+	Name   string `json:"name" minLength:"1" maxLength:"64" doc:"Variant name, unique per node"`
+	Action string `json:"action" doc:"Action text; editing the active variant also changes the node's action"`
+}
+
+type VariantListResponse struct {
+	NodeID   int           `json:"node_id" doc:"Node the variants belong to"`
+	Variants []NodeVariant `json:"variants" doc:"Variants, oldest first"`
+}
+
+// VariantSummary is one side of a variant comparison
+type VariantSummary struct {
+	Variant NodeVariant `json:"variant" doc:"The variant"`
+	Tokens  int         `json:"tokens" doc:"Estimated tokens for the action"`
+	Runs    int         `json:"runs" doc:"Test runs made with this variant"`
+	Passed  int         `json:"passed" doc:"Of those runs, how many passed"`
+}
+
+// DiffSegment is a run of words that both texts share, or that only one has
+type DiffSegment struct {
+	Op   string `json:"op" enum:"equal,removed,added" doc:"equal for shared text, removed for text only in a, added for text only in b"`
+	Text string `json:"text" doc:"The text, whitespace included"`
+}
+
+type VariantComparison struct {
+	NodeID int            `json:"node_id" doc:"Node the variants belong to"`
+	A      VariantSummary `json:"a" doc:"First variant"`
+	B      VariantSummary `json:"b" doc:"Second variant"`
+	Diff   []DiffSegment  `json:"diff" doc:"Word-level changes from a's action to b's"`
+}
+
+// Command run statuses: a run is running until its command exits or is
+// stopped
+const (
+	CommandRunRunning   = "running"
+	CommandRunSucceeded = "succeeded"
+	CommandRunFailed    = "failed"
+	CommandRunTimedOut  = "timed_out"
+	CommandRunCanceled  = "canceled"
+)
+
+// CommandRun is the log of executing a command node on the server
+type CommandRun struct {
+	ID         int        `json:"id" doc:"Run ID"`
+	NodeID     int        `json:"node_id" doc:"Command node that ran"`
+	Command    string     `json:"command" doc:"Command as run, with variables filled in"`
+	Status     string     `json:"status" enum:"running,succeeded,failed,timed_out,canceled" doc:"running until the command exits; succeeded on exit status 0, failed on any other, timed_out or canceled when it was stopped"`
+	ExitCode   *int       `json:"exit_code,omitempty" doc:"Exit status, if the command exited on its own"`
+	Output     string     `json:"output" doc:"stdout and stderr, interleaved line by line"`
+	Truncated  bool       `json:"truncated,omitempty" doc:"Whether output past the runner's limit was dropped"`
+	DurationMS int        `json:"duration_ms" doc:"How long the command ran"`
+	StartedAt  time.Time  `json:"started_at" doc:"When the command started"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" doc:"When the command ended"`
+}
+
+type CommandRunListResponse struct {
+	Runs []CommandRun `json:"runs" doc:"Runs, newest first"`
+}
+
+type RunCommandsResponse struct {
+	PromptID  int          `json:"prompt_id" doc:"Prompt whose nodes ran"`
+	Succeeded int          `json:"succeeded" doc:"Number of commands that succeeded"`
+	Failed    int          `json:"failed" doc:"Number of commands that failed, timed out or were canceled"`
+	Skipped   []int        `json:"skipped" doc:"Command nodes not run because an earlier one failed"`
+	Runs      []CommandRun `json:"runs" doc:"One run per command that ran, in order"`
+}
+
+// Plan run statuses: a run is active until it is completed or abandoned
+const (
+	PlanRunActive    = "active"
+	PlanRunCompleted = "completed"
+	PlanRunAbandoned = "abandoned"
+)
+
+// PlanRun is one walk through a saved tree's plan, such as setting it up in
+// one repository. Its steps are copied from the saved tree when it starts,
+// so later changes to the tree or the save do not affect it.
+type PlanRun struct {
+	ID          int           `json:"id" doc:"Run ID"`
+	SavedTree   string        `json:"saved_tree" doc:"Name of the saved tree the run follows"`
+	SnapshotAt  time.Time     `json:"snapshot_at" doc:"When that saved tree had last been saved as the run started"`
+	Label       string        `json:"label,omitempty" doc:"What the run is for, e.g. the repository"`
+	Status      string        `json:"status" enum:"active,completed,abandoned" doc:"active until the run is completed or abandoned"`
+	Notes       string        `json:"notes,omitempty" doc:"Notes on the run as a whole"`
+	Progress    *Progress     `json:"progress" doc:"Step status rollup"`
+	StartedAt   time.Time     `json:"started_at" doc:"When the run started"`
+	CompletedAt *time.Time    `json:"completed_at,omitempty" doc:"When the run was completed or abandoned"`
+	Steps       []PlanRunStep `json:"steps,omitempty" doc:"Steps in plan order; left out of lists"`
+}
+
+// PlanRunStep is one node of a run's plan and how it went in that run
+type PlanRunStep struct {
+	NodeID      int        `json:"node_id" doc:"Node ID in the saved tree"`
+	PromptID    int        `json:"prompt_id" doc:"Prompt ID in the saved tree"`
+	PromptTitle string     `json:"prompt_title" doc:"Title of that prompt"`
+	Name        string     `json:"name" doc:"Node name"`
+	DependsOn   []int      `json:"depends_on" doc:"Steps that must finish first"`
+	Status      string     `json:"status" enum:"todo,in_progress,done,skipped,blocked" doc:"Status in this run"`
+	Notes       string     `json:"notes,omitempty" doc:"Notes on the step in this run"`
+	Ready       bool       `json:"ready" doc:"Todo with every dependency done or skipped"`
+	StartedAt   *time.Time `json:"started_at,omitempty" doc:"When the step went in progress"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" doc:"When the step was done or skipped"`
+	DurationMS  *int       `json:"duration_ms,omitempty" doc:"Time from start to finish, when both are known"`
+}
+
+type StartPlanRunRequest struct {
+	SavedTree string `json:"saved_tree" minLength:"1" maxLength:"255" doc:"Name of the saved tree to follow"`
+	Label     string `json:"label,omitempty" maxLength:"255" doc:"What the run is for, e.g. the repository"`
+	Notes     string `json:"notes,omitempty" doc:"Notes on the run"`
+}
+
+type AdvancePlanRunRequest struct {
+	Status string `json:"status,omitempty" enum:"done,skipped,blocked" default:"done" doc:"What becomes of the step in progress"`
+	Notes  string `json:"notes,omitempty" doc:"Notes for the step in progress, replacing any it has"`
+}
+
+type UpdatePlanRunStepRequest struct {
+	Status string  `json:"status" enum:"todo,in_progress,done,skipped,blocked" doc:"Status in this run"`
+	Notes  *string `json:"notes,omitempty" doc:"Notes on the step; omit to keep them"`
+}
+
+type CompletePlanRunRequest struct {
+	Outcome string `json:"outcome,omitempty" enum:"completed,abandoned" default:"completed" doc:"completed when the plan was carried out, abandoned when it was given up"`
+	Notes   string `json:"notes,omitempty" doc:"Notes on the run, replacing any it has"`
+}
+
+type PlanRunListResponse struct {
+	Runs []PlanRun `json:"runs" doc:"Runs, newest first"`
+}
+
+// PlanRunPromptReport rolls up a run's steps under one prompt
+type PlanRunPromptReport struct {
+	PromptID    int      `json:"prompt_id" doc:"Prompt ID in the saved tree"`
+	PromptTitle string   `json:"prompt_title" doc:"Title of that prompt"`
+	Progress    Progress `json:"progress" doc:"Step status rollup"`
+	DurationMS  int      `json:"duration_ms" doc:"Time spent on the prompt's timed steps"`
+}
+
+// PlanRunReport sums up how a run went
+type PlanRunReport struct {
+	Run        PlanRun               `json:"run" doc:"The run, without its steps"`
+	DurationMS int                   `json:"duration_ms" doc:"Time from start to completion, or until now for an active run"`
+	StepTimeMS int                   `json:"step_time_ms" doc:"Time spent on steps with both a start and a finish"`
+	Untimed    int                   `json:"untimed" doc:"Finished steps that were never marked in progress, so have no duration"`
+	Prompts    []PlanRunPromptReport `json:"prompts" doc:"Rollup per prompt, in plan order"`
+	Slowest    []PlanRunStep         `json:"slowest" doc:"Up to five timed steps, longest first"`
+	Blocked    []PlanRunStep         `json:"blocked" doc:"Steps left blocked"`
+	Notes      []PlanRunStep         `json:"notes" doc:"Steps with notes, in plan order"`
 }
\ No newline at end of file
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// OptionalString is a JSON Merge Patch (RFC 7396) field. It records whether
+// the member was present in the document and whether it was an explicit null,
+// which a plain string or *string cannot tell apart.
+type OptionalString struct {
+	Set   bool
+	Null  bool
+	Value string
+}
+
+// UnmarshalJSON is only called for members present in the document, so
+// reaching it at all means the field was set.
+func (o *OptionalString) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Null = true
+		o.Value = ""
+		return nil
+	}
+	o.Null = false
+	return json.Unmarshal(data, &o.Value)
+}
+
+func (o OptionalString) MarshalJSON() ([]byte, error) {
+	if !o.Set || o.Null {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.Value)
+}
+
+// Schema documents the field as a nullable string in the OpenAPI spec.
+func (o OptionalString) Schema(r huma.Registry) *huma.Schema {
+	return &huma.Schema{Type: huma.TypeString, Nullable: true}
+}
+
+// Ptr converts the field into the repository's update convention: nil leaves
+// the column alone, and an explicit null clears it to the empty string.
+func (o OptionalString) Ptr() *string {
+	if !o.Set {
+		return nil
+	}
+	value := o.Value
+	return &value
+}
+
+type PatchPromptRequest struct {
+	Title       OptionalString `json:"title,omitempty" doc:"New title; cannot be null or empty"`
+	Description OptionalString `json:"description,omitempty" doc:"New description; null clears it"`
+}
+
+type PatchNodeRequest struct {
+	Name   OptionalString `json:"name,omitempty" doc:"New name; cannot be null or empty"`
+	Action OptionalString `json:"action,omitempty" doc:"New action description; null clears it"`
+	Status OptionalString `json:"status,omitempty" doc:"New status: todo, in_progress, done, skipped or blocked; cannot be null"`
+	Kind   OptionalString `json:"kind,omitempty" doc:"New kind: instruction, command or file; cannot be null"`
+}
+
+type PatchNoteRequest struct {
+	Content OptionalString `json:"content,omitempty" doc:"New note content; cannot be null or empty"`
+}
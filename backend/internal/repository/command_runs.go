@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+const commandRunColumns = "id, node_id, command, status, exit_code, output, truncated, duration_ms, started_at, finished_at"
+
+func scanCommandRun(row scanner) (*models.CommandRun, error) {
+	var r models.CommandRun
+	var exitCode sql.NullInt64
+	var finishedAt sql.NullTime
+	if err := row.Scan(&r.ID, &r.NodeID, &r.Command, &r.Status, &exitCode, &r.Output, &r.Truncated, &r.DurationMS, &r.StartedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		r.ExitCode = &code
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	return &r, nil
+}
+
+// StartCommandRun stores a running command, before it has any output
+func (r *PromptRepository) StartCommandRun(nodeID int, command string) (*models.CommandRun, error) {
+	query := `
+		INSERT INTO command_runs (node_id, command)
+		VALUES ($1, $2)
+		RETURNING ` + commandRunColumns
+
+	run, err := scanCommandRun(r.db().QueryRow(query, nodeID, command))
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return run, nil
+}
+
+// FinishCommandRun records how a run ended, filling in its finish time. A
+// run whose node was deleted meanwhile is gone, which is not an error.
+func (r *PromptRepository) FinishCommandRun(run *models.CommandRun) error {
+	query := `
+		UPDATE command_runs
+		SET status = $2, exit_code = $3, output = $4, truncated = $5, duration_ms = $6, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING finished_at
+	`
+
+	var finishedAt sql.NullTime
+	err := r.db().QueryRow(query, run.ID, run.Status, run.ExitCode, run.Output, run.Truncated, run.DurationMS).Scan(&finishedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return dbError("update failed", err)
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return nil
+}
+
+// ListCommandRuns returns up to limit of a node's runs, newest first
+func (r *PromptRepository) ListCommandRuns(nodeID, limit int) ([]models.CommandRun, error) {
+	query := `
+		SELECT ` + commandRunColumns + `
+		FROM command_runs
+		WHERE node_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db().Query(query, nodeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.CommandRun
+	for rows.Next() {
+		run, err := scanCommandRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import "fmt"
+
+// ListDependencies returns every dependency edge as a map from a node to the
+// nodes it depends on, each list sorted by ID
+func (r *PromptRepository) ListDependencies() (map[int][]int, error) {
+	rows, err := r.db().Query("SELECT node_id, depends_on_id FROM node_dependencies ORDER BY node_id, depends_on_id")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	deps := make(map[int][]int)
+	for rows.Next() {
+		var nodeID, dependsOnID int
+		if err := rows.Scan(&nodeID, &dependsOnID); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		deps[nodeID] = append(deps[nodeID], dependsOnID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return deps, nil
+}
+
+// AddNodeDependency records that nodeID depends on dependsOnID. Adding an
+// existing dependency is a no-op. Cycles are the caller's concern.
+func (r *PromptRepository) AddNodeDependency(nodeID, dependsOnID int) error {
+	return r.WithTx(func(tx *PromptRepository) error {
+		result, err := tx.db().Exec(`
+			INSERT INTO node_dependencies (node_id, depends_on_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, nodeID, dependsOnID)
+		if err != nil {
+			return dbError("insert failed", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return nil
+		}
+		return tx.bumpNodeVersion(nodeID)
+	})
+}
+
+// RemoveNodeDependency deletes a dependency. It reports false if there was
+// no such dependency.
+func (r *PromptRepository) RemoveNodeDependency(nodeID, dependsOnID int) (bool, error) {
+	var removed bool
+	err := r.WithTx(func(tx *PromptRepository) error {
+		result, err := tx.db().Exec("DELETE FROM node_dependencies WHERE node_id = $1 AND depends_on_id = $2", nodeID, dependsOnID)
+		if err != nil {
+			return dbError("delete failed", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		removed = rowsAffected > 0
+		if !removed {
+			return nil
+		}
+		return tx.bumpNodeVersion(nodeID)
+	})
+	return removed, err
+}
+
+// SetNodeDependencies replaces everything a node depends on
+func (r *PromptRepository) SetNodeDependencies(nodeID int, dependsOn []int) error {
+	return r.WithTx(func(tx *PromptRepository) error {
+		if _, err := tx.db().Exec("DELETE FROM node_dependencies WHERE node_id = $1", nodeID); err != nil {
+			return dbError("delete failed", err)
+		}
+		if err := tx.insertDependencies(nodeID, dependsOn); err != nil {
+			return err
+		}
+		return tx.bumpNodeVersion(nodeID)
+	})
+}
+
+func (r *PromptRepository) insertDependencies(nodeID int, dependsOn []int) error {
+	for _, dependsOnID := range dependsOn {
+		_, err := r.db().Exec(`
+			INSERT INTO node_dependencies (node_id, depends_on_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, nodeID, dependsOnID)
+		if err != nil {
+			return dbError("insert failed", err)
+		}
+	}
+	return nil
+}
+
+// bumpNodeVersion bumps a node's version so ETags and the tree version
+// reflect dependency changes
+func (r *PromptRepository) bumpNodeVersion(nodeID int) error {
+	if _, err := r.db().Exec("UPDATE nodes SET version = version + 1 WHERE id = $1", nodeID); err != nil {
+		return dbError("update failed", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+// dbError wraps a failed statement. Postgres errors the client caused, such
+// as constraint violations or values longer than their column, become typed
+// errors so they are not reported as internal failures.
+func dbError(op string, err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	switch pqErr.Code.Name() {
+	case "unique_violation":
+		return errs.New(errs.Conflict, "already_exists", "a record with this value already exists").Wrap(err)
+	case "foreign_key_violation":
+		if strings.Contains(pqErr.Detail, "is not present") {
+			return errs.New(errs.NotFound, "reference_not_found", "referenced record does not exist").Wrap(err)
+		}
+		return errs.New(errs.Conflict, "still_referenced", "record is still referenced by other records").Wrap(err)
+	case "string_data_right_truncation":
+		return errs.New(errs.Validation, "value_too_long", "value is longer than the field allows").Wrap(err)
+	case "not_null_violation":
+		return errs.New(errs.Validation, "missing_value", fmt.Sprintf("%s is required", pqErr.Column)).Wrap(err)
+	case "check_violation":
+		return errs.New(errs.Validation, "check_violation", "value is not allowed").Wrap(err)
+	case "serialization_failure", "deadlock_detected":
+		return errs.New(errs.Conflict, "concurrent_update", "request conflicted with a concurrent update; retry it").Wrap(err)
+	}
+	return fmt.Errorf("%s: %w", op, err)
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanTestCase(row scanner) (*models.TestCase, error) {
+	var t models.TestCase
+	var variables, assertions string
+	if err := row.Scan(&t.ID, &t.NodeID, &t.Name, &variables, &assertions, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(variables), &t.Variables); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal variables: %w", err)
+	}
+	if err := json.Unmarshal([]byte(assertions), &t.Assertions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal assertions: %w", err)
+	}
+	return &t, nil
+}
+
+func scanTestRun(row scanner) (*models.TestRun, error) {
+	var r models.TestRun
+	var failures string
+	var variantID sql.NullInt64
+	if err := row.Scan(&r.ID, &r.TestCaseID, &r.NodeID, &r.Passed, &r.Output, &failures, &r.DurationMS, &variantID, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(failures), &r.Failures); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal failures: %w", err)
+	}
+	if variantID.Valid {
+		id := int(variantID.Int64)
+		r.VariantID = &id
+	}
+	return &r, nil
+}
+
+const testCaseColumns = "id, node_id, name, variables::text, assertions::text, created_at, updated_at"
+
+const testRunColumns = "id, test_case_id, node_id, passed, output, failures::text, duration_ms, variant_id, created_at"
+
+// ListTestCases returns a node's test cases, oldest first, each with its
+// latest run
+func (r *PromptRepository) ListTestCases(nodeID int) ([]models.TestCase, error) {
+	rows, err := r.db().Query("SELECT "+testCaseColumns+" FROM test_cases WHERE node_id = $1 ORDER BY id", nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var cases []models.TestCase
+	for rows.Next() {
+		t, err := scanTestCase(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		cases = append(cases, *t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	query := `
+		SELECT DISTINCT ON (test_case_id) ` + testRunColumns + `
+		FROM test_runs
+		WHERE node_id = $1
+		ORDER BY test_case_id, id DESC
+	`
+	runRows, err := r.db().Query(query, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer runRows.Close()
+
+	lastRuns := make(map[int]*models.TestRun)
+	for runRows.Next() {
+		run, err := scanTestRun(runRows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		lastRuns[run.TestCaseID] = run
+	}
+	if err = runRows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	for i := range cases {
+		cases[i].LastRun = lastRuns[cases[i].ID]
+	}
+	return cases, nil
+}
+
+func (r *PromptRepository) GetTestCase(id int) (*models.TestCase, error) {
+	t, err := scanTestCase(r.db().QueryRow("SELECT "+testCaseColumns+" FROM test_cases WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return t, nil
+}
+
+func (r *PromptRepository) CreateTestCase(nodeID int, req models.TestCaseRequest) (*models.TestCase, error) {
+	variables, assertions, err := marshalTestCase(req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO test_cases (node_id, name, variables, assertions)
+		VALUES ($1, $2, $3::jsonb, $4::jsonb)
+		RETURNING ` + testCaseColumns
+
+	t, err := scanTestCase(r.db().QueryRow(query, nodeID, req.Name, variables, assertions))
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return t, nil
+}
+
+// UpdateTestCase replaces a test case's name, variables and assertions. It
+// returns nil if there is no such test case.
+func (r *PromptRepository) UpdateTestCase(id int, req models.TestCaseRequest) (*models.TestCase, error) {
+	variables, assertions, err := marshalTestCase(req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE test_cases
+		SET name = $2, variables = $3::jsonb, assertions = $4::jsonb, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + testCaseColumns
+
+	t, err := scanTestCase(r.db().QueryRow(query, id, req.Name, variables, assertions))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return t, nil
+}
+
+func marshalTestCase(req models.TestCaseRequest) (string, string, error) {
+	variables := req.Variables
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	v, err := json.Marshal(variables)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal variables: %w", err)
+	}
+	a, err := json.Marshal(req.Assertions)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal assertions: %w", err)
+	}
+	return string(v), string(a), nil
+}
+
+// DeleteTestCase removes a test case and its runs. It reports false if there
+// was none.
+func (r *PromptRepository) DeleteTestCase(id int) (bool, error) {
+	result, err := r.db().Exec("DELETE FROM test_cases WHERE id = $1", id)
+	if err != nil {
+		return false, dbError("delete failed", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// CreateTestRun stores a run, filling in its ID and time
+func (r *PromptRepository) CreateTestRun(run *models.TestRun) error {
+	failures, err := json.Marshal(run.Failures)
+	if err != nil {
+		return fmt.Errorf("failed to marshal failures: %w", err)
+	}
+
+	query := `
+		INSERT INTO test_runs (test_case_id, node_id, passed, output, failures, duration_ms, variant_id)
+		VALUES ($1, $2, $3, $4, $5::jsonb, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err = r.db().QueryRow(query, run.TestCaseID, run.NodeID, run.Passed, run.Output, string(failures), run.DurationMS, run.VariantID).Scan(&run.ID, &run.CreatedAt)
+	if err != nil {
+		return dbError("insert failed", err)
+	}
+	return nil
+}
+
+// ListTestRuns returns up to limit of a node's runs, newest first. A
+// testCaseID other than 0 keeps only that test case's runs.
+func (r *PromptRepository) ListTestRuns(nodeID, testCaseID, limit int) ([]models.TestRun, error) {
+	query := `
+		SELECT ` + testRunColumns + `
+		FROM test_runs
+		WHERE node_id = $1 AND ($2 = 0 OR test_case_id = $2)
+		ORDER BY id DESC
+		LIMIT $3
+	`
+
+	rows, err := r.db().Query(query, nodeID, testCaseID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.TestRun
+	for rows.Next() {
+		run, err := scanTestRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return runs, nil
+}
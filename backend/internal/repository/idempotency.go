@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// claimAttempts bounds how often a claim is retried when the key is released
+// between the insert and the read that follows it
+const claimAttempts = 3
+
+// ClaimIdempotencyKey reserves key for scope. It returns (nil, true) when the
+// caller now owns the key and should run the request, or the existing record
+// and false when the key was already claimed. Records created before cutoff
+// are purged first so expired keys can be reused, as are claims still in
+// progress since before leaseCutoff, whose request must have died.
+func (r *PromptRepository) ClaimIdempotencyKey(key, scope, requestHash string, cutoff, leaseCutoff time.Time) (*models.IdempotencyRecord, bool, error) {
+	_, err := r.db().Exec(`
+		DELETE FROM idempotency_keys
+		WHERE created_at < $1 OR (status_code IS NULL AND created_at < $2)
+	`, cutoff, leaseCutoff)
+	if err != nil {
+		return nil, false, fmt.Errorf("purge failed: %w", err)
+	}
+
+	for range claimAttempts {
+		result, err := r.db().Exec(`
+			INSERT INTO idempotency_keys (key, scope, request_hash)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (key, scope) DO NOTHING
+		`, key, scope, requestHash)
+		if err != nil {
+			return nil, false, dbError("insert failed", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 1 {
+			return nil, true, nil
+		}
+
+		rec, err := r.getIdempotencyRecord(key, scope)
+		if err != nil {
+			return nil, false, err
+		}
+		// Released between our insert and select; claim it again
+		if rec != nil {
+			return rec, false, nil
+		}
+	}
+	return nil, false, fmt.Errorf("idempotency key %q was released %d times while claiming it", key, claimAttempts)
+}
+
+func (r *PromptRepository) getIdempotencyRecord(key, scope string) (*models.IdempotencyRecord, error) {
+	query := `
+		SELECT key, scope, request_hash, status_code, COALESCE(headers, '{}'::jsonb)::text, response_body, created_at
+		FROM idempotency_keys
+		WHERE key = $1 AND scope = $2
+	`
+
+	var rec models.IdempotencyRecord
+	var status sql.NullInt64
+	var headers string
+	err := r.db().QueryRow(query, key, scope).Scan(
+		&rec.Key, &rec.Scope, &rec.RequestHash, &status, &headers, &rec.Body, &rec.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	if status.Valid {
+		code := int(status.Int64)
+		rec.StatusCode = &code
+	}
+	if err := json.Unmarshal([]byte(headers), &rec.Headers); err != nil {
+		return nil, fmt.Errorf("failed to decode stored headers: %w", err)
+	}
+
+	return &rec, nil
+}
+
+// CompleteIdempotencyKey stores the response for a claimed key
+func (r *PromptRepository) CompleteIdempotencyKey(key, scope string, statusCode int, headers map[string][]string, body []byte) error {
+	headerJSON, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	_, err = r.db().Exec(`
+		UPDATE idempotency_keys
+		SET status_code = $1, headers = $2::jsonb, response_body = $3
+		WHERE key = $4 AND scope = $5
+	`, statusCode, string(headerJSON), body, key, scope)
+	if err != nil {
+		return dbError("update failed", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey drops a claim so the request can be retried
+func (r *PromptRepository) ReleaseIdempotencyKey(key, scope string) error {
+	_, err := r.db().Exec("DELETE FROM idempotency_keys WHERE key = $1 AND scope = $2", key, scope)
+	if err != nil {
+		return dbError("delete failed", err)
+	}
+	return nil
+}
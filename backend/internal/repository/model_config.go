@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// unmarshalModelConfig reads a model_config column. An empty object, which
+// inherits everything, comes back as nil.
+func unmarshalModelConfig(data string) (*models.ModelConfig, error) {
+	var cfg models.ModelConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal model config: %w", err)
+	}
+	if cfg == (models.ModelConfig{}) {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+func marshalModelConfig(cfg *models.ModelConfig) (string, error) {
+	if cfg == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal model config: %w", err)
+	}
+	return string(data), nil
+}
+
+// GetModelDefaults returns the project's default model settings, or nil if
+// there are none
+func (r *PromptRepository) GetModelDefaults() (*models.ModelConfig, error) {
+	var data string
+	err := r.db().QueryRow("SELECT model_config::text FROM project_settings WHERE id = 1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return unmarshalModelConfig(data)
+}
+
+// SetModelDefaults replaces the project's default model settings and bumps
+// the tree version. A nil config clears them.
+func (r *PromptRepository) SetModelDefaults(cfg *models.ModelConfig) error {
+	data, err := marshalModelConfig(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = r.db().Exec(`
+		UPDATE project_settings
+		SET model_config = $1::jsonb, tree_version = tree_version + 1
+		WHERE id = 1
+	`, data)
+	if err != nil {
+		return dbError("update model defaults failed", err)
+	}
+	return nil
+}
+
+// SetPromptModel replaces a prompt's model settings and bumps its version.
+// A nil config clears them. When expectedVersion is set the update only
+// succeeds against that version.
+func (r *PromptRepository) SetPromptModel(id int, cfg *models.ModelConfig, expectedVersion *int) (*models.Prompt, error) {
+	data, err := marshalModelConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE prompts
+		SET model_config = $2::jsonb, version = version + 1
+		WHERE id = $1 AND ($3::int IS NULL OR version = $3)
+		RETURNING ` + promptColumns
+
+	p, err := scanPrompt(r.db().QueryRow(query, id, data, expectedVersion))
+	if err == sql.ErrNoRows {
+		return nil, r.missingOrStale("prompts", id, expectedVersion)
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return p, nil
+}
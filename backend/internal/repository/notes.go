@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// CreateNoteReply adds a reply to a note, on the same prompt, node or saved
+// tree. It returns nil if the parent does not exist.
+func (r *PromptRepository) CreateNoteReply(parentID int, author, content string) (*models.Note, error) {
+	query := `
+		WITH n AS (
+			INSERT INTO notes (prompt_id, node_id, saved_tree_id, parent_id, author, content, created_at, updated_at)
+			SELECT prompt_id, node_id, saved_tree_id, id, $2, $3, $4, $4
+			FROM notes
+			WHERE id = $1
+			RETURNING *
+		)
+		SELECT ` + noteColumns + ` FROM n` + noteJoins
+
+	note, err := scanNote(r.db().QueryRow(query, parentID, author, content, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return note, nil
+}
+
+// SetNoteResolved resolves a note, keeping the first resolution time if it
+// already is, or reopens it
+func (r *PromptRepository) SetNoteResolved(noteID int, resolved bool, expectedVersion *int) (*models.Note, error) {
+	query := `
+		WITH n AS (
+			UPDATE notes
+			SET resolved_at = CASE WHEN $2 THEN COALESCE(resolved_at, CURRENT_TIMESTAMP) END,
+				updated_at = CURRENT_TIMESTAMP,
+				version = version + 1
+			WHERE id = $1 AND ($3::int IS NULL OR version = $3)
+			RETURNING *
+		)
+		SELECT ` + noteColumns + ` FROM n` + noteJoins
+
+	note, err := scanNote(r.db().QueryRow(query, noteID, resolved, expectedVersion))
+	if err == sql.ErrNoRows {
+		return nil, r.missingOrStale("notes", noteID, expectedVersion)
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return note, nil
+}
+
+// LockNote locks a note until the transaction ends. A missing note is left
+// for the caller to report.
+func (r *PromptRepository) LockNote(noteID int) error {
+	_, err := r.db().Exec("SELECT id FROM notes WHERE id = $1 FOR UPDATE", noteID)
+	if err != nil {
+		return fmt.Errorf("lock failed: %w", err)
+	}
+	return nil
+}
+
+// GetNotesWithoutMentions returns the ID and content of the notes that hold
+// an @ but have no recorded mentions
+func (r *PromptRepository) GetNotesWithoutMentions() ([]models.Note, error) {
+	rows, err := r.db().Query(`
+		SELECT n.id, n.content
+		FROM notes n
+		WHERE n.content LIKE '%@%'
+			AND NOT EXISTS (SELECT 1 FROM note_mentions m WHERE m.note_id = n.id)
+		ORDER BY n.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		var n models.Note
+		if err := rows.Scan(&n.ID, &n.Content); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return notes, nil
+}
+
+// SetNoteMentions replaces the names a note mentions
+func (r *PromptRepository) SetNoteMentions(noteID int, names []string) error {
+	if _, err := r.db().Exec("DELETE FROM note_mentions WHERE note_id = $1", noteID); err != nil {
+		return dbError("delete failed", err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	_, err := r.db().Exec(`
+		INSERT INTO note_mentions (note_id, name)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`, noteID, pq.Array(names))
+	if err != nil {
+		return dbError("insert failed", err)
+	}
+	return nil
+}
+
+// ListNotesByMention returns the notes that mention name, newest first. With
+// unresolved set it leaves out notes in resolved threads.
+func (r *PromptRepository) ListNotesByMention(name string, unresolved bool) ([]models.Note, error) {
+	query := `
+		SELECT ` + noteColumns + `
+		FROM notes n` + noteJoins + `
+		WHERE EXISTS (SELECT 1 FROM note_mentions m WHERE m.note_id = n.id AND m.name = $1)
+			AND (NOT $2 OR COALESCE(n.resolved_at, pn.resolved_at) IS NULL)
+		ORDER BY n.created_at DESC
+	`
+
+	rows, err := r.db().Query(query, name, unresolved)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []models.Note
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		notes = append(notes, *n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return notes, nil
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+const planRunColumns = "id, saved_tree_name, snapshot_at, label, status, notes, started_at, completed_at"
+
+const planRunStepColumns = "node_id, prompt_id, prompt_title, name, depends_on, status, notes, started_at, finished_at"
+
+func scanPlanRun(row scanner) (*models.PlanRun, error) {
+	var run models.PlanRun
+	var completedAt sql.NullTime
+	if err := row.Scan(&run.ID, &run.SavedTree, &run.SnapshotAt, &run.Label, &run.Status, &run.Notes, &run.StartedAt, &completedAt); err != nil {
+		return nil, err
+	}
+	if completedAt.Valid {
+		run.CompletedAt = &completedAt.Time
+	}
+	return &run, nil
+}
+
+func scanPlanRunStep(row scanner) (*models.PlanRunStep, error) {
+	var step models.PlanRunStep
+	var dependsOn []int64
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&step.NodeID, &step.PromptID, &step.PromptTitle, &step.Name, pq.Array(&dependsOn), &step.Status, &step.Notes, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	step.DependsOn = make([]int, len(dependsOn))
+	for i, id := range dependsOn {
+		step.DependsOn[i] = int(id)
+	}
+	if startedAt.Valid {
+		step.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		step.FinishedAt = &finishedAt.Time
+	}
+	return &step, nil
+}
+
+// CreatePlanRun stores an active run of a saved tree. Its steps are added
+// separately with CreatePlanRunStep.
+func (r *PromptRepository) CreatePlanRun(savedTreeID int, savedTreeName string, snapshotAt time.Time, label, notes string) (*models.PlanRun, error) {
+	query := `
+		INSERT INTO plan_runs (saved_tree_id, saved_tree_name, snapshot_at, label, notes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + planRunColumns
+
+	run, err := scanPlanRun(r.db().QueryRow(query, savedTreeID, savedTreeName, snapshotAt, label, notes))
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return run, nil
+}
+
+// CreatePlanRunStep adds a step to a run at the given position in its plan
+func (r *PromptRepository) CreatePlanRunStep(runID, position int, step models.PlanRunStep) error {
+	dependsOn := make([]int64, len(step.DependsOn))
+	for i, id := range step.DependsOn {
+		dependsOn[i] = int64(id)
+	}
+
+	_, err := r.db().Exec(`
+		INSERT INTO plan_run_steps (run_id, node_id, position, prompt_id, prompt_title, name, depends_on, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, runID, step.NodeID, position, step.PromptID, step.PromptTitle, step.Name, pq.Array(dependsOn), step.Status)
+	if err != nil {
+		return dbError("insert failed", err)
+	}
+	return nil
+}
+
+func (r *PromptRepository) GetPlanRun(id int) (*models.PlanRun, error) {
+	return r.getPlanRun("SELECT "+planRunColumns+" FROM plan_runs WHERE id = $1", id)
+}
+
+// LockPlanRun returns a run like GetPlanRun and holds its row until the
+// transaction ends, so changes to one run happen one at a time
+func (r *PromptRepository) LockPlanRun(id int) (*models.PlanRun, error) {
+	return r.getPlanRun("SELECT "+planRunColumns+" FROM plan_runs WHERE id = $1 FOR UPDATE", id)
+}
+
+func (r *PromptRepository) getPlanRun(query string, id int) (*models.PlanRun, error) {
+	run, err := scanPlanRun(r.db().QueryRow(query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return run, nil
+}
+
+// ListPlanRuns returns runs, newest first. A savedTree or status other than
+// "" keeps only the runs of that saved tree or with that status.
+func (r *PromptRepository) ListPlanRuns(savedTree, status string) ([]models.PlanRun, error) {
+	query := `
+		SELECT ` + planRunColumns + `
+		FROM plan_runs
+		WHERE ($1 = '' OR saved_tree_name = $1) AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+	`
+
+	rows, err := r.db().Query(query, savedTree, status)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.PlanRun
+	for rows.Next() {
+		run, err := scanPlanRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return runs, nil
+}
+
+// ListPlanRunSteps returns a run's steps in plan order
+func (r *PromptRepository) ListPlanRunSteps(runID int) ([]models.PlanRunStep, error) {
+	rows, err := r.db().Query("SELECT "+planRunStepColumns+" FROM plan_run_steps WHERE run_id = $1 ORDER BY position", runID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var steps []models.PlanRunStep
+	for rows.Next() {
+		step, err := scanPlanRunStep(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		steps = append(steps, *step)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return steps, nil
+}
+
+// UpdatePlanRunStep sets a step's status, and its notes unless notes is
+// nil. Going in progress stamps the start, finishing stamps the end and
+// going back to todo clears both. It returns nil if there is no such step.
+func (r *PromptRepository) UpdatePlanRunStep(runID, nodeID int, status string, notes *string) (*models.PlanRunStep, error) {
+	query := `
+		UPDATE plan_run_steps
+		SET status = $3::text,
+			notes = COALESCE($4, notes),
+			started_at = CASE
+				WHEN $3::text = 'todo' THEN NULL
+				WHEN $3::text = 'in_progress' THEN COALESCE(started_at, CURRENT_TIMESTAMP)
+				ELSE started_at
+			END,
+			finished_at = CASE
+				WHEN $3::text IN ('done', 'skipped') THEN COALESCE(finished_at, CURRENT_TIMESTAMP)
+				ELSE NULL
+			END
+		WHERE run_id = $1 AND node_id = $2
+		RETURNING ` + planRunStepColumns
+
+	step, err := scanPlanRunStep(r.db().QueryRow(query, runID, nodeID, status, notes))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return step, nil
+}
+
+// FinishPlanRun closes a run with the given status, replacing its notes
+// unless notes is empty
+func (r *PromptRepository) FinishPlanRun(id int, status, notes string) (*models.PlanRun, error) {
+	query := `
+		UPDATE plan_runs
+		SET status = $2, notes = COALESCE(NULLIF($3, ''), notes), completed_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + planRunColumns
+
+	run, err := scanPlanRun(r.db().QueryRow(query, id, status, notes))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return run, nil
+}
@@ -2,27 +2,91 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
+
 	"github.com/pranavturlapati28/merget-takehome/internal/database"
 	"github.com/pranavturlapati28/merget-takehome/internal/models"
 )
 
-type PromptRepository struct{}
+// ErrVersionMismatch is returned when a write carries an expected version
+// that no longer matches the stored row.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// dbtx is the part of *sql.DB and *sql.Tx the repository uses, so the same
+// methods work standalone or inside a transaction.
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+type PromptRepository struct {
+	tx *sql.Tx
+}
 
 func NewPromptRepository() *PromptRepository {
 	return &PromptRepository{}
 }
 
+func (r *PromptRepository) db() dbtx {
+	if r.tx != nil {
+		return r.tx
+	}
+	return database.DB
+}
+
+// WithTx runs fn against a repository bound to a single transaction. The
+// transaction commits if fn returns nil and rolls back otherwise. Calls on a
+// repository that is already in a transaction join it.
+func (r *PromptRepository) WithTx(fn func(tx *PromptRepository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("transaction begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&PromptRepository{tx: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return dbError("transaction commit failed", err)
+	}
+	return nil
+}
+
+const promptColumns = "id, title, description, project_name, version, " + promptTagsColumn + ", model_config::text"
+
+func scanPrompt(row scanner) (*models.Prompt, error) {
+	var p models.Prompt
+	var modelConfig string
+	if err := row.Scan(&p.ID, &p.Title, &p.Description, &p.ProjectName, &p.Version, pq.Array(&p.Tags), &modelConfig); err != nil {
+		return nil, err
+	}
+	model, err := unmarshalModelConfig(modelConfig)
+	if err != nil {
+		return nil, err
+	}
+	p.Model = model
+	return &p, nil
+}
+
 func (r *PromptRepository) GetAllPrompts() ([]models.Prompt, error) {
 	query := `
-		SELECT id, title, description, project_name 
+		SELECT ` + promptColumns + `
 		FROM prompts 
 		ORDER BY id
 	`
 
-	rows, err := database.DB.Query(query)
+	rows, err := r.db().Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -30,12 +94,11 @@ func (r *PromptRepository) GetAllPrompts() ([]models.Prompt, error) {
 
 	var prompts []models.Prompt
 	for rows.Next() {
-		var p models.Prompt
-		err := rows.Scan(&p.ID, &p.Title, &p.Description, &p.ProjectName)
+		p, err := scanPrompt(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
-		prompts = append(prompts, p)
+		prompts = append(prompts, *p)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -47,15 +110,12 @@ func (r *PromptRepository) GetAllPrompts() ([]models.Prompt, error) {
 
 func (r *PromptRepository) GetPromptByID(id int) (*models.Prompt, error) {
 	query := `
-		SELECT id, title, description, project_name 
+		SELECT ` + promptColumns + `
 		FROM prompts 
 		WHERE id = $1
 	`
 
-	var p models.Prompt
-	err := database.DB.QueryRow(query, id).Scan(
-		&p.ID, &p.Title, &p.Description, &p.ProjectName,
-	)
+	p, err := scanPrompt(r.db().QueryRow(query, id))
 
 	if err == sql.ErrNoRows {
 		return nil, nil // Not found (not an error)
@@ -64,20 +124,20 @@ func (r *PromptRepository) GetPromptByID(id int) (*models.Prompt, error) {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	return &p, nil
+	return p, nil
 }
 
 func (r *PromptRepository) CreatePrompt(title, description string) (*models.Prompt, error) {
 	query := `
 		INSERT INTO prompts (title, description, project_name) 
 		VALUES ($1, $2, $3) 
-		RETURNING id
+		RETURNING id, version
 	`
 
-	var id int
-	err := database.DB.QueryRow(query, title, description, "3D Racing Game").Scan(&id)
+	var id, version int
+	err := r.db().QueryRow(query, title, description, "3D Racing Game").Scan(&id, &version)
 	if err != nil {
-		return nil, fmt.Errorf("insert failed: %w", err)
+		return nil, dbError("insert failed", err)
 	}
 
 	return &models.Prompt{
@@ -85,63 +145,75 @@ func (r *PromptRepository) CreatePrompt(title, description string) (*models.Prom
 		Title:       title,
 		Description: description,
 		ProjectName: "3D Racing Game",
+		Version:     version,
+		Tags:        []string{},
 	}, nil
 }
 
 func (r *PromptRepository) PromptExists(id int) (bool, error) {
 	var exists bool
 	query := "SELECT EXISTS(SELECT 1 FROM prompts WHERE id = $1)"
-	err := database.DB.QueryRow(query, id).Scan(&exists)
+	err := r.db().QueryRow(query, id).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("exists check failed: %w", err)
 	}
 	return exists, nil
 }
 
-func (r *PromptRepository) UpdatePrompt(id int, title, description string) (*models.Prompt, error) {
+// UpdatePrompt sets the non-nil fields (an empty string clears a column) and
+// bumps the row version. When expectedVersion is set the update only
+// succeeds against that version.
+func (r *PromptRepository) UpdatePrompt(id int, title, description *string, expectedVersion *int) (*models.Prompt, error) {
 	query := "UPDATE prompts SET"
 	var args []interface{}
 	argPos := 1
 
-	if title != "" {
+	if title != nil {
 		query += fmt.Sprintf(" title = $%d", argPos)
-		args = append(args, title)
+		args = append(args, *title)
 		argPos++
 	}
 
-	if description != "" {
+	if description != nil {
 		if len(args) > 0 {
 			query += ","
 		}
 		query += fmt.Sprintf(" description = $%d", argPos)
-		args = append(args, description)
+		args = append(args, *description)
 		argPos++
 	}
 
 	if len(args) == 0 {
-		return r.GetPromptByID(id)
+		p, err := r.GetPromptByID(id)
+		if err != nil || p == nil {
+			return p, err
+		}
+		if expectedVersion != nil && p.Version != *expectedVersion {
+			return nil, ErrVersionMismatch
+		}
+		return p, nil
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, title, description, project_name", argPos)
-	args = append(args, id)
+	query += fmt.Sprintf(", version = version + 1 WHERE id = $%d AND ($%d::int IS NULL OR version = $%d)", argPos, argPos+1, argPos+1)
+	query += " RETURNING " + promptColumns
+	args = append(args, id, expectedVersion)
 
-	var p models.Prompt
-	err := database.DB.QueryRow(query, args...).Scan(&p.ID, &p.Title, &p.Description, &p.ProjectName)
+	p, err := scanPrompt(r.db().QueryRow(query, args...))
 	if err == sql.ErrNoRows {
-		return nil, nil // Not found
+		return nil, r.missingOrStale("prompts", id, expectedVersion)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("update failed: %w", err)
+		return nil, dbError("update failed", err)
 	}
 
-	return &p, nil
+	return p, nil
 }
 
-func (r *PromptRepository) DeletePrompt(id int) error {
-	query := "DELETE FROM prompts WHERE id = $1"
-	result, err := database.DB.Exec(query, id)
+func (r *PromptRepository) DeletePrompt(id int, expectedVersion *int) error {
+	query := "DELETE FROM prompts WHERE id = $1 AND ($2::int IS NULL OR version = $2)"
+	result, err := r.db().Exec(query, id, expectedVersion)
 	if err != nil {
-		return fmt.Errorf("delete failed: %w", err)
+		return dbError("delete failed", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -150,21 +222,35 @@ func (r *PromptRepository) DeletePrompt(id int) error {
 	}
 
 	if rowsAffected == 0 {
-		return sql.ErrNoRows // Not found
+		return r.missingOrStale("prompts", id, expectedVersion)
 	}
 
 	return nil
 }
 
+const nodeColumns = "id, prompt_id, name, action, kind, version, status, status_changed_at, started_at, completed_at, " + nodeTagsColumn
+
+func scanNode(row scanner) (*models.Node, error) {
+	var n models.Node
+	err := row.Scan(
+		&n.ID, &n.PromptID, &n.Name, &n.Action, &n.Kind, &n.Version,
+		&n.Status, &n.StatusChangedAt, &n.StartedAt, &n.CompletedAt, pq.Array(&n.Tags),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
 func (r *PromptRepository) GetNodesByPromptID(promptID int) ([]models.Node, error) {
 	query := `
-		SELECT id, prompt_id, name, action 
+		SELECT ` + nodeColumns + `
 		FROM nodes 
 		WHERE prompt_id = $1 
 		ORDER BY id
 	`
 
-	rows, err := database.DB.Query(query, promptID)
+	rows, err := r.db().Query(query, promptID)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -172,12 +258,11 @@ func (r *PromptRepository) GetNodesByPromptID(promptID int) ([]models.Node, erro
 
 	var nodes []models.Node
 	for rows.Next() {
-		var n models.Node
-		err := rows.Scan(&n.ID, &n.PromptID, &n.Name, &n.Action)
+		n, err := scanNode(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
-		nodes = append(nodes, n)
+		nodes = append(nodes, *n)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -187,17 +272,17 @@ func (r *PromptRepository) GetNodesByPromptID(promptID int) ([]models.Node, erro
 	return nodes, nil
 }
 
-func (r *PromptRepository) CreateNode(promptID int, name, action string) (*models.Node, error) {
+func (r *PromptRepository) CreateNode(promptID int, name, action, kind string) (*models.Node, error) {
 	query := `
-		INSERT INTO nodes (prompt_id, name, action) 
-		VALUES ($1, $2, $3) 
-		RETURNING id
+		INSERT INTO nodes (prompt_id, name, action, kind) 
+		VALUES ($1, $2, $3, $4) 
+		RETURNING id, version
 	`
 
-	var id int
-	err := database.DB.QueryRow(query, promptID, name, action).Scan(&id)
+	var id, version int
+	err := r.db().QueryRow(query, promptID, name, action, kind).Scan(&id, &version)
 	if err != nil {
-		return nil, fmt.Errorf("insert failed: %w", err)
+		return nil, dbError("insert failed", err)
 	}
 
 	return &models.Node{
@@ -205,18 +290,21 @@ func (r *PromptRepository) CreateNode(promptID int, name, action string) (*model
 		PromptID: promptID,
 		Name:     name,
 		Action:   action,
+		Kind:     kind,
+		Version:  version,
+		Status:   models.NodeStatusTodo,
+		Tags:     []string{},
 	}, nil
 }
 
 func (r *PromptRepository) GetNodeByID(nodeID int) (*models.Node, error) {
 	query := `
-		SELECT id, prompt_id, name, action 
+		SELECT ` + nodeColumns + `
 		FROM nodes 
 		WHERE id = $1
 	`
 
-	var n models.Node
-	err := database.DB.QueryRow(query, nodeID).Scan(&n.ID, &n.PromptID, &n.Name, &n.Action)
+	n, err := scanNode(r.db().QueryRow(query, nodeID))
 
 	if err == sql.ErrNoRows {
 		return nil, nil // Not found (not an error)
@@ -225,53 +313,91 @@ func (r *PromptRepository) GetNodeByID(nodeID int) (*models.Node, error) {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	return &n, nil
+	return n, nil
 }
 
-func (r *PromptRepository) UpdateNode(nodeID int, name, action string) (*models.Node, error) {
+// UpdateNode sets the non-nil fields (an empty string clears a column) and
+// bumps the row version. When expectedVersion is set the update only
+// succeeds against that version. A status change also records when it
+// happened: started_at is set the first time the node goes in_progress and
+// completed_at while it is done or skipped.
+func (r *PromptRepository) UpdateNode(nodeID int, name, action, status, kind *string, expectedVersion *int) (*models.Node, error) {
 	query := "UPDATE nodes SET"
 	var args []interface{}
 	argPos := 1
 
-	if name != "" {
+	if name != nil {
 		query += fmt.Sprintf(" name = $%d", argPos)
-		args = append(args, name)
+		args = append(args, *name)
 		argPos++
 	}
 
-	if action != "" {
+	if action != nil {
 		if len(args) > 0 {
 			query += ","
 		}
 		query += fmt.Sprintf(" action = $%d", argPos)
-		args = append(args, action)
+		args = append(args, *action)
+		argPos++
+	}
+
+	if status != nil {
+		if len(args) > 0 {
+			query += ","
+		}
+		query += fmt.Sprintf(`
+			status = $%[1]d::varchar,
+			status_changed_at = CASE WHEN status IS DISTINCT FROM $%[1]d::varchar THEN CURRENT_TIMESTAMP ELSE status_changed_at END,
+			started_at = CASE WHEN $%[1]d::varchar = 'in_progress' AND started_at IS NULL THEN CURRENT_TIMESTAMP ELSE started_at END,
+			completed_at = CASE
+				WHEN $%[1]d::varchar NOT IN ('done', 'skipped') THEN NULL
+				WHEN status IN ('done', 'skipped') THEN completed_at
+				ELSE CURRENT_TIMESTAMP
+			END`, argPos)
+		args = append(args, *status)
+		argPos++
+	}
+
+	if kind != nil {
+		if len(args) > 0 {
+			query += ","
+		}
+		query += fmt.Sprintf(" kind = $%d", argPos)
+		args = append(args, *kind)
 		argPos++
 	}
 
 	if len(args) == 0 {
-		return r.GetNodeByID(nodeID)
+		n, err := r.GetNodeByID(nodeID)
+		if err != nil || n == nil {
+			return n, err
+		}
+		if expectedVersion != nil && n.Version != *expectedVersion {
+			return nil, ErrVersionMismatch
+		}
+		return n, nil
 	}
 
-	query += fmt.Sprintf(" WHERE id = $%d RETURNING id, prompt_id, name, action", argPos)
-	args = append(args, nodeID)
+	query += fmt.Sprintf(", version = version + 1 WHERE id = $%d AND ($%d::int IS NULL OR version = $%d)", argPos, argPos+1, argPos+1)
+	query += " RETURNING " + nodeColumns
+	args = append(args, nodeID, expectedVersion)
 
-	var n models.Node
-	err := database.DB.QueryRow(query, args...).Scan(&n.ID, &n.PromptID, &n.Name, &n.Action)
+	n, err := scanNode(r.db().QueryRow(query, args...))
 	if err == sql.ErrNoRows {
-		return nil, nil // Not found
+		return nil, r.missingOrStale("nodes", nodeID, expectedVersion)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("update failed: %w", err)
+		return nil, dbError("update failed", err)
 	}
 
-	return &n, nil
+	return n, nil
 }
 
-func (r *PromptRepository) DeleteNode(nodeID int) error {
-	query := "DELETE FROM nodes WHERE id = $1"
-	result, err := database.DB.Exec(query, nodeID)
+func (r *PromptRepository) DeleteNode(nodeID int, expectedVersion *int) error {
+	query := "DELETE FROM nodes WHERE id = $1 AND ($2::int IS NULL OR version = $2)"
+	result, err := r.db().Exec(query, nodeID, expectedVersion)
 	if err != nil {
-		return fmt.Errorf("delete failed: %w", err)
+		return dbError("delete failed", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -280,21 +406,77 @@ func (r *PromptRepository) DeleteNode(nodeID int) error {
 	}
 
 	if rowsAffected == 0 {
-		return sql.ErrNoRows // Not found
+		return r.missingOrStale("nodes", nodeID, expectedVersion)
 	}
 
 	return nil
 }
 
+// noteColumns reads a note through noteJoins, taking a node note's prompt
+// from its node, a saved tree note's name from the saved tree and a reply's
+// resolution from its thread
+const noteColumns = `n.id, COALESCE(n.prompt_id, nd.prompt_id), n.node_id, st.name, n.parent_id, n.author, n.content,
+	COALESCE((SELECT array_agg(m.name ORDER BY m.name) FROM note_mentions m WHERE m.note_id = n.id), '{}'),
+	COALESCE(n.resolved_at, pn.resolved_at), n.created_at, n.updated_at, n.version`
+
+const noteJoins = `
+	LEFT JOIN nodes nd ON nd.id = n.node_id
+	LEFT JOIN saved_trees st ON st.id = n.saved_tree_id
+	LEFT JOIN notes pn ON pn.id = n.parent_id`
+
+func scanNote(row scanner) (*models.Note, error) {
+	var n models.Note
+	var promptID, nodeID, parentID sql.NullInt64
+	var savedTree sql.NullString
+	var resolvedAt sql.NullTime
+	err := row.Scan(&n.ID, &promptID, &nodeID, &savedTree, &parentID, &n.Author, &n.Content,
+		pq.Array(&n.Mentions), &resolvedAt, &n.CreatedAt, &n.UpdatedAt, &n.Version)
+	if err != nil {
+		return nil, err
+	}
+	n.PromptID = int(promptID.Int64)
+	if nodeID.Valid {
+		id := int(nodeID.Int64)
+		n.NodeID = &id
+	}
+	n.SavedTree = savedTree.String
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		n.ParentID = &id
+	}
+	if resolvedAt.Valid {
+		n.Resolved = true
+		n.ResolvedAt = &resolvedAt.Time
+	}
+	return &n, nil
+}
+
+// GetNotesByPromptID returns the notes on a prompt itself, replies included,
+// newest first. Notes on its nodes are listed per node.
 func (r *PromptRepository) GetNotesByPromptID(promptID int) ([]models.Note, error) {
+	return r.listNotes("prompt_id", promptID)
+}
+
+// GetNotesByNodeID returns a node's notes, newest first
+func (r *PromptRepository) GetNotesByNodeID(nodeID int) ([]models.Note, error) {
+	return r.listNotes("node_id", nodeID)
+}
+
+// GetNotesBySavedTreeID returns a saved tree's notes, newest first
+func (r *PromptRepository) GetNotesBySavedTreeID(savedTreeID int) ([]models.Note, error) {
+	return r.listNotes("saved_tree_id", savedTreeID)
+}
+
+// listNotes returns the notes whose target column holds id
+func (r *PromptRepository) listNotes(target string, id int) ([]models.Note, error) {
 	query := `
-		SELECT id, prompt_id, content, created_at 
-		FROM notes 
-		WHERE prompt_id = $1 
-		ORDER BY created_at DESC
+		SELECT ` + noteColumns + `
+		FROM notes n` + noteJoins + `
+		WHERE n.` + target + ` = $1
+		ORDER BY n.created_at DESC
 	`
 
-	rows, err := database.DB.Query(query, promptID)
+	rows, err := r.db().Query(query, id)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -302,12 +484,11 @@ func (r *PromptRepository) GetNotesByPromptID(promptID int) ([]models.Note, erro
 
 	var notes []models.Note
 	for rows.Next() {
-		var n models.Note
-		err := rows.Scan(&n.ID, &n.PromptID, &n.Content, &n.CreatedAt)
+		n, err := scanNote(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
-		notes = append(notes, n)
+		notes = append(notes, *n)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -317,38 +498,68 @@ func (r *PromptRepository) GetNotesByPromptID(promptID int) ([]models.Note, erro
 	return notes, nil
 }
 
-func (r *PromptRepository) CreateNote(promptID int, content string) (*models.Note, error) {
-	query := `
-		INSERT INTO notes (prompt_id, content, created_at) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, created_at
-	`
-
-	var id int
-	var createdAt time.Time
-	err := database.DB.QueryRow(query, promptID, content, time.Now()).Scan(&id, &createdAt)
+// CountNotesByNode returns how many notes each node has, leaving out nodes
+// without any
+func (r *PromptRepository) CountNotesByNode() (map[int]int, error) {
+	rows, err := r.db().Query("SELECT node_id, COUNT(*) FROM notes WHERE node_id IS NOT NULL GROUP BY node_id")
 	if err != nil {
-		return nil, fmt.Errorf("insert failed: %w", err)
+		return nil, fmt.Errorf("query failed: %w", err)
 	}
+	defer rows.Close()
 
-	return &models.Note{
-		ID:        id,
-		PromptID:  promptID,
-		Content:   content,
-		CreatedAt: createdAt,
-	}, nil
+	counts := make(map[int]int)
+	for rows.Next() {
+		var nodeID, count int
+		if err := rows.Scan(&nodeID, &count); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		counts[nodeID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return counts, nil
+}
+
+func (r *PromptRepository) CreateNote(promptID int, author, content string) (*models.Note, error) {
+	return r.createNote("prompt_id", promptID, author, content)
+}
+
+func (r *PromptRepository) CreateNodeNote(nodeID int, author, content string) (*models.Note, error) {
+	return r.createNote("node_id", nodeID, author, content)
+}
+
+func (r *PromptRepository) CreateSavedTreeNote(savedTreeID int, author, content string) (*models.Note, error) {
+	return r.createNote("saved_tree_id", savedTreeID, author, content)
+}
+
+// createNote adds a note whose target column holds id
+func (r *PromptRepository) createNote(target string, id int, author, content string) (*models.Note, error) {
+	query := `
+		WITH n AS (
+			INSERT INTO notes (` + target + `, author, content, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+			RETURNING *
+		)
+		SELECT ` + noteColumns + ` FROM n` + noteJoins
+
+	note, err := scanNote(r.db().QueryRow(query, id, author, content, time.Now()))
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return note, nil
 }
 
 func (r *PromptRepository) GetNoteByID(noteID int) (*models.Note, error) {
 	query := `
-		SELECT id, prompt_id, content, created_at 
-		FROM notes 
-		WHERE id = $1
+		SELECT ` + noteColumns + `
+		FROM notes n` + noteJoins + `
+		WHERE n.id = $1
 	`
 
-	var n models.Note
-	err := database.DB.QueryRow(query, noteID).Scan(&n.ID, &n.PromptID, &n.Content, &n.CreatedAt)
-
+	note, err := scanNote(r.db().QueryRow(query, noteID))
 	if err == sql.ErrNoRows {
 		return nil, nil // Not found (not an error)
 	}
@@ -356,34 +567,35 @@ func (r *PromptRepository) GetNoteByID(noteID int) (*models.Note, error) {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	return &n, nil
+	return note, nil
 }
 
-func (r *PromptRepository) UpdateNote(noteID int, content string) (*models.Note, error) {
+func (r *PromptRepository) UpdateNote(noteID int, content string, expectedVersion *int) (*models.Note, error) {
 	query := `
-		UPDATE notes 
-		SET content = $1 
-		WHERE id = $2 
-		RETURNING id, prompt_id, content, created_at
-	`
-
-	var n models.Note
-	err := database.DB.QueryRow(query, content, noteID).Scan(&n.ID, &n.PromptID, &n.Content, &n.CreatedAt)
+		WITH n AS (
+			UPDATE notes
+			SET content = $1, updated_at = CURRENT_TIMESTAMP, version = version + 1
+			WHERE id = $2 AND ($3::int IS NULL OR version = $3)
+			RETURNING *
+		)
+		SELECT ` + noteColumns + ` FROM n` + noteJoins
+
+	note, err := scanNote(r.db().QueryRow(query, content, noteID, expectedVersion))
 	if err == sql.ErrNoRows {
-		return nil, nil // Not found
+		return nil, r.missingOrStale("notes", noteID, expectedVersion)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("update failed: %w", err)
+		return nil, dbError("update failed", err)
 	}
 
-	return &n, nil
+	return note, nil
 }
 
-func (r *PromptRepository) DeleteNote(noteID int) error {
-	query := "DELETE FROM notes WHERE id = $1"
-	result, err := database.DB.Exec(query, noteID)
+func (r *PromptRepository) DeleteNote(noteID int, expectedVersion *int) error {
+	query := "DELETE FROM notes WHERE id = $1 AND ($2::int IS NULL OR version = $2)"
+	result, err := r.db().Exec(query, noteID, expectedVersion)
 	if err != nil {
-		return fmt.Errorf("delete failed: %w", err)
+		return dbError("delete failed", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -392,12 +604,30 @@ func (r *PromptRepository) DeleteNote(noteID int) error {
 	}
 
 	if rowsAffected == 0 {
-		return sql.ErrNoRows // Not found
+		return r.missingOrStale("notes", noteID, expectedVersion)
 	}
 
 	return nil
 }
 
+// missingOrStale explains why a versioned write touched no rows: the row is
+// either gone (sql.ErrNoRows) or exists at a different version.
+func (r *PromptRepository) missingOrStale(table string, id int, expectedVersion *int) error {
+	if expectedVersion == nil {
+		return sql.ErrNoRows
+	}
+
+	var exists bool
+	query := fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = $1)", table)
+	if err := r.db().QueryRow(query, id).Scan(&exists); err != nil {
+		return fmt.Errorf("exists check failed: %w", err)
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return ErrVersionMismatch
+}
+
 
 // SaveTree saves a tree configuration with a name
 func (r *PromptRepository) SaveTree(name string, treeData string) error {
@@ -410,9 +640,9 @@ func (r *PromptRepository) SaveTree(name string, treeData string) error {
 			updated_at = CURRENT_TIMESTAMP
 	`
 
-	_, err := database.DB.Exec(query, name, treeData)
+	_, err := r.db().Exec(query, name, treeData)
 	if err != nil {
-		return fmt.Errorf("save failed: %w", err)
+		return dbError("save failed", err)
 	}
 
 	return nil
@@ -426,7 +656,7 @@ func (r *PromptRepository) GetSavedTree(name string) (*models.SavedTree, error)
 	`
 
 	var st models.SavedTree
-	err := database.DB.QueryRow(query, name).Scan(&st.ID, &st.Name, &st.TreeData, &st.CreatedAt, &st.UpdatedAt)
+	err := r.db().QueryRow(query, name).Scan(&st.ID, &st.Name, &st.TreeData, &st.CreatedAt, &st.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil // Not found
@@ -445,7 +675,7 @@ func (r *PromptRepository) ListSavedTrees() ([]models.SavedTreeInfo, error) {
 		ORDER BY updated_at DESC
 	`
 
-	rows, err := database.DB.Query(query)
+	rows, err := r.db().Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -470,9 +700,9 @@ func (r *PromptRepository) ListSavedTrees() ([]models.SavedTreeInfo, error) {
 
 func (r *PromptRepository) DeleteSavedTree(name string) error {
 	query := "DELETE FROM saved_trees WHERE name = $1"
-	result, err := database.DB.Exec(query, name)
+	result, err := r.db().Exec(query, name)
 	if err != nil {
-		return fmt.Errorf("delete failed: %w", err)
+		return dbError("delete failed", err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -487,71 +717,182 @@ func (r *PromptRepository) DeleteSavedTree(name string) error {
 	return nil
 }
 
-func (r *PromptRepository) ImportTree(treeData *models.TreeResponse) error {
-	tx, err := database.DB.Begin()
+// ImportTree replaces the whole tree. When expectedVersion is set the import
+// only proceeds if the tree version still matches; the settings row is locked
+// for the duration so concurrent writers queue behind it.
+func (r *PromptRepository) ImportTree(treeData *models.TreeResponse, expectedVersion *int) error {
+	err := r.WithTx(func(tx *PromptRepository) error {
+		treeVersion, err := tx.LockTreeVersion()
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && treeVersion != *expectedVersion {
+			return ErrVersionMismatch
+		}
+
+		fmt.Printf("About to update project_settings with: project=%s, mainRequest=%s\n", treeData.Project, treeData.MainRequest)
+		if err := tx.UpdateProjectSettings(treeData.Project, treeData.MainRequest); err != nil {
+			fmt.Printf("ERROR updating project_settings: %v\n", err)
+			return err
+		}
+		fmt.Printf("SUCCESS: Updated project_settings: project = %s\n", treeData.Project)
+
+		if err := tx.SetVariables(treeData.Variables); err != nil {
+			return err
+		}
+		if err := tx.SetModelDefaults(treeData.Model); err != nil {
+			return err
+		}
+
+		// Delete rather than truncate: truncating would empty notes as a
+		// whole, including the notes on saved trees
+		_, err = tx.db().Exec("DELETE FROM nodes; DELETE FROM prompts")
+		if err != nil {
+			return dbError("delete failed", err)
+		}
+
+		// Dependencies name nodes by their IDs in the imported document,
+		// which are only known once every node has been inserted
+		newNodeIDs := make(map[int]int)
+		dependsOn := make(map[int][]int)
+
+		for _, promptNode := range treeData.Prompts {
+			modelConfig, err := marshalModelConfig(promptNode.Model)
+			if err != nil {
+				return err
+			}
+
+			var newID int
+			err = tx.db().QueryRow(`
+				INSERT INTO prompts (title, description, project_name, model_config)
+				VALUES ($1, $2, $3, $4::jsonb)
+				RETURNING id
+			`, promptNode.Title, promptNode.Description, treeData.Project, modelConfig).Scan(&newID)
+
+			if err != nil {
+				return dbError("insert prompt failed", err)
+			}
+			if err := tx.SetPromptTags(newID, promptNode.Tags); err != nil {
+				return err
+			}
+
+			for _, nodeSummary := range promptNode.Nodes {
+				var nodeID int
+				err = tx.db().QueryRow(`
+					INSERT INTO nodes (prompt_id, name, action, kind, status, status_changed_at, started_at, completed_at)
+					VALUES ($1, $2, $3, $5, $4::varchar,
+						CASE WHEN $4::varchar <> 'todo' THEN CURRENT_TIMESTAMP END,
+						CASE WHEN $4::varchar IN ('in_progress', 'done') THEN CURRENT_TIMESTAMP END,
+						CASE WHEN $4::varchar IN ('done', 'skipped') THEN CURRENT_TIMESTAMP END)
+					RETURNING id
+				`, newID, nodeSummary.Name, nodeSummary.Action, nodeSummary.Status, nodeSummary.Kind).Scan(&nodeID)
+
+				if err != nil {
+					return dbError("insert node failed", err)
+				}
+				if err := tx.SetNodeTags(nodeID, nodeSummary.Tags); err != nil {
+					return err
+				}
+				if nodeSummary.ID != 0 {
+					newNodeIDs[nodeSummary.ID] = nodeID
+				}
+				if len(nodeSummary.DependsOn) > 0 {
+					dependsOn[nodeID] = nodeSummary.DependsOn
+				}
+			}
+		}
+
+		for nodeID, ids := range dependsOn {
+			mapped := make([]int, 0, len(ids))
+			for _, id := range ids {
+				mapped = append(mapped, newNodeIDs[id])
+			}
+			if err := tx.insertDependencies(nodeID, mapped); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("transaction begin failed: %w", err)
+		fmt.Printf("ERROR importing tree: %v\n", err)
+		return err
 	}
-	defer tx.Rollback()
 
-	fmt.Printf("About to update project_settings with: project=%s, mainRequest=%s\n", treeData.Project, treeData.MainRequest)
-	result, err := tx.Exec(`
+	fmt.Printf("=== ImportTree SUCCESS: project=%s ===\n", treeData.Project)
+	return nil
+}
+
+// LockTreeVersion reads the tree version and, inside a transaction, locks
+// the settings row so no other tree write can commit until this one does.
+func (r *PromptRepository) LockTreeVersion() (int, error) {
+	var version int
+	err := r.db().QueryRow("SELECT tree_version FROM project_settings WHERE id = 1 FOR UPDATE").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("tree version lookup failed: %w", err)
+	}
+	return version, nil
+}
+
+// UpdateProjectSettings stores the project name and main request, creating
+// the settings row if it is missing. A change bumps the tree version.
+func (r *PromptRepository) UpdateProjectSettings(projectName, mainRequest string) error {
+	_, err := r.db().Exec(`
 		INSERT INTO project_settings (id, project_name, main_request)
 		VALUES (1, $1, $2)
 		ON CONFLICT (id) 
 		DO UPDATE SET 
 			project_name = EXCLUDED.project_name,
-			main_request = EXCLUDED.main_request
-	`, treeData.Project, treeData.MainRequest)
+			main_request = EXCLUDED.main_request,
+			tree_version = project_settings.tree_version + 1
+		WHERE (project_settings.project_name, project_settings.main_request)
+			IS DISTINCT FROM (EXCLUDED.project_name, EXCLUDED.main_request)
+	`, projectName, mainRequest)
 	if err != nil {
-		fmt.Printf("ERROR updating project_settings: %v\n", err)
-		return fmt.Errorf("update project settings failed: %w", err)
+		return dbError("update project settings failed", err)
 	}
-	rowsAffected, _ := result.RowsAffected()
-	fmt.Printf("SUCCESS: Updated project_settings: rows affected = %d, project = %s\n", rowsAffected, treeData.Project)
+	return nil
+}
 
-	_, err = tx.Exec("TRUNCATE TABLE notes, nodes, prompts CASCADE")
+// MoveNode re-parents a node under another prompt and bumps its version.
+func (r *PromptRepository) MoveNode(nodeID, promptID int) error {
+	query := "UPDATE nodes SET prompt_id = $1, version = version + 1 WHERE id = $2"
+	result, err := r.db().Exec(query, promptID, nodeID)
 	if err != nil {
-		return fmt.Errorf("truncate failed: %w", err)
+		return dbError("move failed", err)
 	}
 
-	for _, promptNode := range treeData.Prompts {
-		var newID int
-		err := tx.QueryRow(`
-			INSERT INTO prompts (title, description, project_name)
-			VALUES ($1, $2, $3)
-			RETURNING id
-		`, promptNode.Title, promptNode.Description, treeData.Project).Scan(&newID)
-
-		if err != nil {
-			return fmt.Errorf("insert prompt failed: %w", err)
-		}
-
-		for _, nodeSummary := range promptNode.Nodes {
-			_, err = tx.Exec(`
-				INSERT INTO nodes (prompt_id, name, action)
-				VALUES ($1, $2, $3)
-			`, newID, nodeSummary.Name, nodeSummary.Action)
-
-			if err != nil {
-				return fmt.Errorf("insert node failed: %w", err)
-			}
-		}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
-
-	if err = tx.Commit(); err != nil {
-		fmt.Printf("ERROR committing transaction: %v\n", err)
-		return fmt.Errorf("transaction commit failed: %w", err)
+	if rowsAffected == 0 {
+		return sql.ErrNoRows // Not found
 	}
-
-	fmt.Printf("=== ImportTree SUCCESS: project=%s ===\n", treeData.Project)
 	return nil
 }
 
+// GetTreeVersion returns the counter bumped on every prompt, node or project
+// settings change. It is 0 while there is no settings row.
+func (r *PromptRepository) GetTreeVersion() (int, error) {
+	var version int
+	query := "SELECT tree_version FROM project_settings WHERE id = 1"
+	err := r.db().QueryRow(query).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query failed: %w", err)
+	}
+	return version, nil
+}
+
 func (r *PromptRepository) GetProjectSettings() (string, string, error) {
 	var projectName, mainRequest string
 	query := "SELECT project_name, main_request FROM project_settings WHERE id = 1"
-	err := database.DB.QueryRow(query).Scan(&projectName, &mainRequest)
+	err := r.db().QueryRow(query).Scan(&projectName, &mainRequest)
 	if err != nil {
 		return "", "", fmt.Errorf("query failed: %w", err)
 	}
@@ -0,0 +1,294 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// promptTagsColumn and nodeTagsColumn select a row's tag names, sorted, as a
+// text array. They refer to the unaliased prompts and nodes tables.
+const (
+	promptTagsColumn = `COALESCE((
+		SELECT array_agg(t.name ORDER BY t.name)
+		FROM prompt_tags pt JOIN tags t ON t.id = pt.tag_id
+		WHERE pt.prompt_id = prompts.id
+	), '{}')`
+	nodeTagsColumn = `COALESCE((
+		SELECT array_agg(t.name ORDER BY t.name)
+		FROM node_tags nt JOIN tags t ON t.id = nt.tag_id
+		WHERE nt.node_id = nodes.id
+	), '{}')`
+)
+
+// tagLink describes one of the join tables linking tags to a resource
+type tagLink struct {
+	table  string // join table, e.g. prompt_tags
+	column string // owner column in the join table, e.g. prompt_id
+	owner  string // owner table, whose version is bumped on change
+}
+
+var (
+	promptTagLink = tagLink{table: "prompt_tags", column: "prompt_id", owner: "prompts"}
+	nodeTagLink   = tagLink{table: "node_tags", column: "node_id", owner: "nodes"}
+)
+
+// AddPromptTag tags a prompt, creating the tag if needed. Adding a tag the
+// prompt already has is a no-op.
+func (r *PromptRepository) AddPromptTag(promptID int, tag string) error {
+	return r.addTag(promptTagLink, promptID, tag)
+}
+
+// RemovePromptTag untags a prompt. It reports false if the prompt did not
+// have the tag.
+func (r *PromptRepository) RemovePromptTag(promptID int, tag string) (bool, error) {
+	return r.removeTag(promptTagLink, promptID, tag)
+}
+
+// SetPromptTags replaces all of a prompt's tags
+func (r *PromptRepository) SetPromptTags(promptID int, tags []string) error {
+	return r.setTags(promptTagLink, promptID, tags)
+}
+
+func (r *PromptRepository) AddNodeTag(nodeID int, tag string) error {
+	return r.addTag(nodeTagLink, nodeID, tag)
+}
+
+func (r *PromptRepository) RemoveNodeTag(nodeID int, tag string) (bool, error) {
+	return r.removeTag(nodeTagLink, nodeID, tag)
+}
+
+func (r *PromptRepository) SetNodeTags(nodeID int, tags []string) error {
+	return r.setTags(nodeTagLink, nodeID, tags)
+}
+
+// ListTags returns every tag in use with how many prompts and nodes carry it
+func (r *PromptRepository) ListTags() ([]models.TagInfo, error) {
+	query := `
+		SELECT t.name,
+			(SELECT COUNT(*) FROM prompt_tags pt WHERE pt.tag_id = t.id),
+			(SELECT COUNT(*) FROM node_tags nt WHERE nt.tag_id = t.id)
+		FROM tags t
+		WHERE EXISTS (SELECT 1 FROM prompt_tags pt WHERE pt.tag_id = t.id)
+			OR EXISTS (SELECT 1 FROM node_tags nt WHERE nt.tag_id = t.id)
+		ORDER BY t.name
+	`
+
+	rows, err := r.db().Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []models.TagInfo
+	for rows.Next() {
+		var t models.TagInfo
+		if err := rows.Scan(&t.Name, &t.Prompts, &t.Nodes); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (r *PromptRepository) addTag(link tagLink, id int, tag string) error {
+	return r.WithTx(func(tx *PromptRepository) error {
+		tagID, err := tx.ensureTag(tag)
+		if err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", link.table, link.column)
+		result, err := tx.db().Exec(query, id, tagID)
+		if err != nil {
+			return dbError("insert failed", err)
+		}
+		return tx.bumpIfChanged(link, id, result)
+	})
+}
+
+func (r *PromptRepository) removeTag(link tagLink, id int, tag string) (bool, error) {
+	var removed bool
+	err := r.WithTx(func(tx *PromptRepository) error {
+		query := fmt.Sprintf(`
+			DELETE FROM %s
+			WHERE %s = $1 AND tag_id = (SELECT id FROM tags WHERE name = $2)
+		`, link.table, link.column)
+		result, err := tx.db().Exec(query, id, tag)
+		if err != nil {
+			return dbError("delete failed", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		removed = rowsAffected > 0
+		return tx.bumpIfChanged(link, id, result)
+	})
+	return removed, err
+}
+
+func (r *PromptRepository) setTags(link tagLink, id int, tags []string) error {
+	return r.WithTx(func(tx *PromptRepository) error {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", link.table, link.column)
+		if _, err := tx.db().Exec(query, id); err != nil {
+			return dbError("delete failed", err)
+		}
+
+		for _, tag := range tags {
+			tagID, err := tx.ensureTag(tag)
+			if err != nil {
+				return err
+			}
+			query := fmt.Sprintf("INSERT INTO %s (%s, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", link.table, link.column)
+			if _, err := tx.db().Exec(query, id, tagID); err != nil {
+				return dbError("insert failed", err)
+			}
+		}
+
+		query = fmt.Sprintf("UPDATE %s SET version = version + 1 WHERE id = $1", link.owner)
+		if _, err := tx.db().Exec(query, id); err != nil {
+			return dbError("update failed", err)
+		}
+		return nil
+	})
+}
+
+// ensureTag returns the ID of the named tag, creating it if it is new
+func (r *PromptRepository) ensureTag(name string) (int, error) {
+	var id int
+	err := r.db().QueryRow(`
+		INSERT INTO tags (name) VALUES ($1)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id
+	`, name).Scan(&id)
+	if err != nil {
+		return 0, dbError("insert failed", err)
+	}
+	return id, nil
+}
+
+// bumpIfChanged bumps the owner's version when a tag link was added or
+// removed, so ETags and the tree version reflect tag changes.
+func (r *PromptRepository) bumpIfChanged(link tagLink, id int, result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET version = version + 1 WHERE id = $1", link.owner)
+	if _, err := r.db().Exec(query, id); err != nil {
+		return dbError("update failed", err)
+	}
+	return nil
+}
+
+// nodeAndPromptTags is a node's tags together with its prompt's, as a text
+// array. It refers to the unaliased nodes table.
+const nodeAndPromptTags = `(` + nodeTagsColumn + ` || COALESCE((
+		SELECT array_agg(t.name)
+		FROM prompt_tags pt JOIN tags t ON t.id = pt.tag_id
+		WHERE pt.prompt_id = nodes.prompt_id
+	), '{}'))`
+
+// GetPromptsByTags returns the prompts carrying every tag, ordered by ID.
+// With no tags it returns every prompt.
+func (r *PromptRepository) GetPromptsByTags(tags []string) ([]models.Prompt, error) {
+	return r.queryPrompts(`
+		SELECT `+promptColumns+`
+		FROM prompts
+		WHERE `+promptTagsColumn+` @> $1::text[]
+		ORDER BY id
+	`, tagArray(tags))
+}
+
+// GetTreePromptsByTags returns the prompts that carry every tag or have a
+// node that does together with them, ordered by ID
+func (r *PromptRepository) GetTreePromptsByTags(tags []string) ([]models.Prompt, error) {
+	return r.queryPrompts(`
+		SELECT `+promptColumns+`
+		FROM prompts
+		WHERE `+promptTagsColumn+` @> $1::text[]
+			OR EXISTS (
+				SELECT 1 FROM nodes
+				WHERE nodes.prompt_id = prompts.id AND `+nodeAndPromptTags+` @> $1::text[]
+			)
+		ORDER BY id
+	`, tagArray(tags))
+}
+
+// GetNodesByTags returns a prompt's nodes that, together with the prompt,
+// carry every tag, ordered by ID
+func (r *PromptRepository) GetNodesByTags(promptID int, tags []string) ([]models.Node, error) {
+	query := `
+		SELECT ` + nodeColumns + `
+		FROM nodes
+		WHERE prompt_id = $1 AND ` + nodeAndPromptTags + ` @> $2::text[]
+		ORDER BY id
+	`
+
+	rows, err := r.db().Query(query, promptID, tagArray(tags))
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []models.Node
+	for rows.Next() {
+		n, err := scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		nodes = append(nodes, *n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// tagArray binds tags as a text array. pq binds a nil slice as NULL, which
+// would match nothing, so no tags become an empty array that matches all.
+func tagArray(tags []string) any {
+	if tags == nil {
+		tags = []string{}
+	}
+	return pq.Array(tags)
+}
+
+func (r *PromptRepository) queryPrompts(query string, args ...any) ([]models.Prompt, error) {
+	rows, err := r.db().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var prompts []models.Prompt
+	for rows.Next() {
+		p, err := scanPrompt(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		prompts = append(prompts, *p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return prompts, nil
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lib/pq"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// PutTemplate creates the template or replaces everything but its creation
+// time
+func (r *PromptRepository) PutTemplate(t *models.Template) error {
+	parameters, err := json.Marshal(t.Parameters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal parameters: %w", err)
+	}
+	tree, err := json.Marshal(t.Tree)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tree: %w", err)
+	}
+
+	query := `
+		INSERT INTO templates (name, description, parameters, tree_data, builtin, updated_at)
+		VALUES ($1, $2, $3::jsonb, $4::jsonb, $5, CURRENT_TIMESTAMP)
+		ON CONFLICT (name)
+		DO UPDATE SET
+			description = EXCLUDED.description,
+			parameters = EXCLUDED.parameters,
+			tree_data = EXCLUDED.tree_data,
+			builtin = EXCLUDED.builtin,
+			updated_at = CURRENT_TIMESTAMP
+	`
+
+	_, err = r.db().Exec(query, t.Name, t.Description, string(parameters), string(tree), t.Builtin)
+	if err != nil {
+		return dbError("save failed", err)
+	}
+
+	return nil
+}
+
+func (r *PromptRepository) GetTemplate(name string) (*models.Template, error) {
+	query := `
+		SELECT name, description, parameters::text, tree_data::text, builtin, created_at, updated_at
+		FROM templates
+		WHERE name = $1
+	`
+
+	var t models.Template
+	var parameters, tree string
+	err := r.db().QueryRow(query, name).Scan(&t.Name, &t.Description, &parameters, &tree, &t.Builtin, &t.CreatedAt, &t.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(parameters), &t.Parameters); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
+	}
+	if err := json.Unmarshal([]byte(tree), &t.Tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tree: %w", err)
+	}
+
+	return &t, nil
+}
+
+func (r *PromptRepository) ListTemplates() ([]models.TemplateInfo, error) {
+	query := `
+		SELECT name, description, parameters::text, builtin, updated_at
+		FROM templates
+		ORDER BY name
+	`
+
+	rows, err := r.db().Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []models.TemplateInfo
+	for rows.Next() {
+		var t models.TemplateInfo
+		var parameters string
+		if err := rows.Scan(&t.Name, &t.Description, &parameters, &t.Builtin, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if err := json.Unmarshal([]byte(parameters), &t.Parameters); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return templates, nil
+}
+
+// DeleteTemplate removes a template. It reports false if there was none.
+func (r *PromptRepository) DeleteTemplate(name string) (bool, error) {
+	result, err := r.db().Exec("DELETE FROM templates WHERE name = $1", name)
+	if err != nil {
+		return false, dbError("delete failed", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// DeleteBuiltinTemplatesExcept removes the built-in templates not named in
+// keep and returns how many it removed
+func (r *PromptRepository) DeleteBuiltinTemplatesExcept(keep []string) (int64, error) {
+	result, err := r.db().Exec("DELETE FROM templates WHERE builtin AND NOT (name = ANY($1::text[]))", pq.Array(keep))
+	if err != nil {
+		return 0, dbError("delete failed", err)
+	}
+
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return removed, nil
+}
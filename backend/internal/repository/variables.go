@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// ListVariables returns the project variables sorted by name
+func (r *PromptRepository) ListVariables() ([]models.Variable, error) {
+	rows, err := r.db().Query("SELECT name, default_value, description FROM variables ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var variables []models.Variable
+	for rows.Next() {
+		var v models.Variable
+		if err := rows.Scan(&v.Name, &v.Default, &v.Description); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		variables = append(variables, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return variables, nil
+}
+
+// PutVariable creates the variable or replaces its default and description
+func (r *PromptRepository) PutVariable(v models.Variable) error {
+	_, err := r.db().Exec(`
+		INSERT INTO variables (name, default_value, description)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+		DO UPDATE SET
+			default_value = EXCLUDED.default_value,
+			description = EXCLUDED.description
+	`, v.Name, v.Default, v.Description)
+	if err != nil {
+		return dbError("insert failed", err)
+	}
+	return nil
+}
+
+// DeleteVariable removes a variable. It reports false if there was none.
+func (r *PromptRepository) DeleteVariable(name string) (bool, error) {
+	result, err := r.db().Exec("DELETE FROM variables WHERE name = $1", name)
+	if err != nil {
+		return false, dbError("delete failed", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// SetVariables replaces every project variable
+func (r *PromptRepository) SetVariables(variables []models.Variable) error {
+	return r.WithTx(func(tx *PromptRepository) error {
+		if _, err := tx.db().Exec("DELETE FROM variables"); err != nil {
+			return dbError("delete failed", err)
+		}
+		for _, v := range variables {
+			if err := tx.PutVariable(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+const variantColumns = "id, node_id, name, action, active, created_at, updated_at"
+
+func scanVariant(row scanner) (*models.NodeVariant, error) {
+	var v models.NodeVariant
+	if err := row.Scan(&v.ID, &v.NodeID, &v.Name, &v.Action, &v.Active, &v.CreatedAt, &v.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ListVariants returns a node's variants, oldest first
+func (r *PromptRepository) ListVariants(nodeID int) ([]models.NodeVariant, error) {
+	rows, err := r.db().Query("SELECT "+variantColumns+" FROM node_variants WHERE node_id = $1 ORDER BY id", nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []models.NodeVariant
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		variants = append(variants, *v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return variants, nil
+}
+
+func (r *PromptRepository) GetVariant(id int) (*models.NodeVariant, error) {
+	v, err := scanVariant(r.db().QueryRow("SELECT "+variantColumns+" FROM node_variants WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return v, nil
+}
+
+// ListInactiveVariantsWithPlaceholders returns the variants, across all
+// nodes, that are not a node's action and may use a variable
+func (r *PromptRepository) ListInactiveVariantsWithPlaceholders() ([]models.NodeVariant, error) {
+	rows, err := r.db().Query("SELECT " + variantColumns + " FROM node_variants WHERE NOT active AND action LIKE '%{{%' ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []models.NodeVariant
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		variants = append(variants, *v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return variants, nil
+}
+
+// ActiveVariants maps each node that has variants to its active variant's
+// name
+func (r *PromptRepository) ActiveVariants() (map[int]string, error) {
+	rows, err := r.db().Query("SELECT node_id, name FROM node_variants WHERE active")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	active := make(map[int]string)
+	for rows.Next() {
+		var nodeID int
+		var name string
+		if err := rows.Scan(&nodeID, &name); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		active[nodeID] = name
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	return active, nil
+}
+
+func (r *PromptRepository) CreateVariant(nodeID int, name, action string, active bool) (*models.NodeVariant, error) {
+	query := `
+		INSERT INTO node_variants (node_id, name, action, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + variantColumns
+
+	v, err := scanVariant(r.db().QueryRow(query, nodeID, name, action, active))
+	if err != nil {
+		return nil, dbError("insert failed", err)
+	}
+	return v, nil
+}
+
+// UpdateVariant renames a variant and replaces its action. It returns nil if
+// there is no such variant.
+func (r *PromptRepository) UpdateVariant(id int, name, action string) (*models.NodeVariant, error) {
+	query := `
+		UPDATE node_variants
+		SET name = $2, action = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + variantColumns
+
+	v, err := scanVariant(r.db().QueryRow(query, id, name, action))
+	if err == sql.ErrNoRows {
+		return nil, nil // Not found
+	}
+	if err != nil {
+		return nil, dbError("update failed", err)
+	}
+	return v, nil
+}
+
+// DeleteVariant removes a variant unless it is active. It reports false if
+// there was no such inactive variant.
+func (r *PromptRepository) DeleteVariant(id int) (bool, error) {
+	result, err := r.db().Exec("DELETE FROM node_variants WHERE id = $1 AND NOT active", id)
+	if err != nil {
+		return false, dbError("delete failed", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// ActivateVariant makes the variant the node's only active one. It does not
+// touch the node's action; run it in a transaction with that update.
+func (r *PromptRepository) ActivateVariant(nodeID, id int) error {
+	// Two statements, since the one-active index is checked row by row
+	if _, err := r.db().Exec("UPDATE node_variants SET active = FALSE WHERE node_id = $1 AND active AND id <> $2", nodeID, id); err != nil {
+		return dbError("update failed", err)
+	}
+	if _, err := r.db().Exec("UPDATE node_variants SET active = TRUE WHERE id = $1 AND node_id = $2", id, nodeID); err != nil {
+		return dbError("update failed", err)
+	}
+	return nil
+}
+
+// CountVariantRuns returns how many test runs used the variant and how many
+// of them passed
+func (r *PromptRepository) CountVariantRuns(id int) (int, int, error) {
+	var runs, passed int
+	query := "SELECT COUNT(*), COUNT(*) FILTER (WHERE passed) FROM test_runs WHERE variant_id = $1"
+	if err := r.db().QueryRow(query, id).Scan(&runs, &passed); err != nil {
+		return 0, 0, fmt.Errorf("query failed: %w", err)
+	}
+	return runs, passed, nil
+}
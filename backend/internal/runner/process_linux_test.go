@@ -0,0 +1,66 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// alive reports whether a process is still running; a zombie that nobody
+// has reaped yet counts as gone
+func alive(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestRunTimeoutKillsProcessGroup(t *testing.T) {
+	r := newTestRunner(t, 300*time.Millisecond, 1024)
+
+	result, err := r.Run(context.Background(), "sleep 30 & echo $!; wait", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.TimedOut || result.ExitCode != nil {
+		t.Fatalf("timed out %v, exit code %v", result.TimedOut, result.ExitCode)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(result.Output))
+	if err != nil {
+		t.Fatalf("output %q is not the background pid", result.Output)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("background process %d outlived the timeout", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	r := newTestRunner(t, 10*time.Second, 1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := r.Run(ctx, "sleep 30", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Canceled || result.TimedOut {
+		t.Errorf("canceled %v, timed out %v", result.Canceled, result.TimedOut)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancel took %v", elapsed)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package runner
+
+import "os/exec"
+
+// killGroup leaves the default cancellation, which stops only the shell
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,237 @@
+// Package runner executes shell commands on the server's machine for
+// command nodes. It is off unless configured. It is not a sandbox: commands
+// run as the server's user and can reach anything it can. The runner only
+// starts them in a working directory, withholds all but an allowlist of
+// environment variables and bounds their time and output.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream names the output a line came from
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// Config enables and limits the runner
+type Config struct {
+	Enabled bool
+	// Authenticated says the API requires a key; the runner refuses to
+	// start on a server anyone can call
+	Authenticated bool
+	// WorkDir is the directory commands start in; it must exist. Nothing
+	// stops a command from leaving it.
+	WorkDir string
+	// Timeout stops a command that runs longer
+	Timeout time.Duration
+	// Env names the server's environment variables commands can see; all
+	// others are withheld
+	Env []string
+	// MaxOutput caps the bytes of output kept and streamed per command
+	MaxOutput int
+}
+
+// Result is how a command ended and what it printed. ExitCode is nil when
+// the command was stopped rather than exiting on its own.
+type Result struct {
+	ExitCode  *int
+	TimedOut  bool
+	Canceled  bool
+	Output    string
+	Truncated bool
+	Duration  time.Duration
+}
+
+// Succeeded reports whether the command exited with status 0
+func (r *Result) Succeeded() bool {
+	return r.ExitCode != nil && *r.ExitCode == 0
+}
+
+// Runner runs commands with /bin/sh under its Config
+type Runner struct {
+	cfg Config
+}
+
+// New returns the configured runner, or nil when it is disabled
+func New(cfg Config) (*Runner, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if !cfg.Authenticated {
+		return nil, fmt.Errorf("the runner needs API_KEY to be set")
+	}
+	if cfg.WorkDir == "" {
+		return nil, fmt.Errorf("RUNNER_WORKDIR is required when the runner is enabled")
+	}
+	info, err := os.Stat(cfg.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("runner working directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("runner working directory %s is not a directory", cfg.WorkDir)
+	}
+	return &Runner{cfg: cfg}, nil
+}
+
+// WorkDir returns the directory commands run in
+func (r *Runner) WorkDir() string {
+	return r.cfg.WorkDir
+}
+
+// Run executes command and waits for it, calling onLine with each line of
+// output as it arrives. The error is only for commands that could not be
+// started; a failing command is reported in the Result.
+func (r *Runner) Run(ctx context.Context, command string, onLine func(stream Stream, line string)) (*Result, error) {
+	runCtx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+
+	log := &outputLog{limit: r.cfg.MaxOutput, onLine: onLine}
+	stdout, stderr := log.writer(Stdout), log.writer(Stderr)
+
+	cmd := exec.CommandContext(runCtx, "/bin/sh", "-c", command)
+	cmd.Dir = r.cfg.WorkDir
+	cmd.Env = r.env()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Children that outlive the shell would otherwise keep the pipes open
+	cmd.WaitDelay = time.Second
+	killGroup(cmd)
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	err := cmd.Wait()
+	stdout.flush()
+	stderr.flush()
+
+	result := &Result{Duration: time.Since(start)}
+	result.Output, result.Truncated = log.String()
+	switch {
+	case ctx.Err() != nil:
+		result.Canceled = true
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		result.TimedOut = true
+	default:
+		var exit *exec.ExitError
+		if err == nil || errors.As(err, &exit) && exit.Exited() {
+			code := cmd.ProcessState.ExitCode()
+			result.ExitCode = &code
+		}
+	}
+	return result, nil
+}
+
+// env copies the allowlisted variables from the server's environment
+func (r *Runner) env() []string {
+	env := []string{}
+	for _, name := range r.cfg.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// outputLog collects both streams in the order lines arrive, up to limit
+// bytes. Lines past the limit are dropped rather than streamed.
+type outputLog struct {
+	mu        sync.Mutex
+	buf       strings.Builder
+	limit     int
+	truncated bool
+	onLine    func(Stream, string)
+}
+
+func (l *outputLog) add(stream Stream, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.truncated {
+		return
+	}
+	if l.limit > 0 && l.buf.Len()+len(line)+1 > l.limit {
+		l.truncated = true
+		return
+	}
+	l.buf.WriteString(line + "\n")
+	if l.onLine != nil {
+		l.onLine(stream, line)
+	}
+}
+
+// truncate drops the rest of the output
+func (l *outputLog) truncate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.truncated = true
+}
+
+// full reports whether output is being dropped
+func (l *outputLog) full() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.truncated
+}
+
+func (l *outputLog) String() (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String(), l.truncated
+}
+
+func (l *outputLog) writer(stream Stream) *lineWriter {
+	return &lineWriter{stream: stream, log: l}
+}
+
+// lineWriter splits one stream into lines for the log. The partial line it
+// holds never grows past the log's limit.
+type lineWriter struct {
+	stream  Stream
+	log     *outputLog
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if w.log.full() {
+		w.pending = nil
+		return len(p), nil
+	}
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.add(w.stream, strings.TrimRight(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+
+	// A line longer than the whole log could never be kept
+	if w.log.limit > 0 && len(w.pending) > w.log.limit {
+		w.log.truncate()
+		w.pending = nil
+	}
+	return len(p), nil
+}
+
+// flush logs a last line that had no newline
+func (w *lineWriter) flush() {
+	if len(w.pending) > 0 {
+		w.log.add(w.stream, string(w.pending))
+		w.pending = nil
+	}
+}
@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriterCapsPartialLine(t *testing.T) {
+	log := &outputLog{limit: 64}
+	w := log.writer(Stdout)
+
+	chunk := bytes.Repeat([]byte("x"), 50)
+	for range 1000 {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.pending) > log.limit {
+			t.Fatalf("pending grew to %d bytes, limit %d", len(w.pending), log.limit)
+		}
+	}
+	w.flush()
+
+	output, truncated := log.String()
+	if !truncated {
+		t.Error("output not marked truncated")
+	}
+	if output != "" {
+		t.Errorf("output = %q, want nothing from the overlong line", output)
+	}
+}
+
+func TestLineWriterSplitsLines(t *testing.T) {
+	var lines []string
+	log := &outputLog{limit: 1024, onLine: func(stream Stream, line string) {
+		lines = append(lines, string(stream)+":"+line)
+	}}
+	w := log.writer(Stderr)
+
+	for _, chunk := range []string{"one\ntw", "o\r\n", "\nthree"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.flush()
+
+	want := []string{"stderr:one", "stderr:two", "stderr:", "stderr:three"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if output, truncated := log.String(); output != "one\ntwo\n\nthree\n" || truncated {
+		t.Errorf("output = %q, truncated %v", output, truncated)
+	}
+}
+
+func TestOutputLogTruncates(t *testing.T) {
+	var streamed int
+	log := &outputLog{limit: 10, onLine: func(Stream, string) { streamed++ }}
+	for _, line := range []string{"1234", "5678", "9", "later"} {
+		log.add(Stdout, line)
+	}
+
+	output, truncated := log.String()
+	if output != "1234\n5678\n" || !truncated {
+		t.Errorf("output = %q, truncated %v", output, truncated)
+	}
+	if streamed != 2 {
+		t.Errorf("streamed %d lines, want only the 2 kept", streamed)
+	}
+}
+
+func newTestRunner(t *testing.T, timeout time.Duration, maxOutput int) *Runner {
+	t.Helper()
+	r, err := New(Config{
+		Enabled:       true,
+		Authenticated: true,
+		WorkDir:       t.TempDir(),
+		Timeout:       timeout,
+		Env:           []string{"PATH"},
+		MaxOutput:     maxOutput,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRunOutputAndExitCode(t *testing.T) {
+	r := newTestRunner(t, 10*time.Second, 1024)
+
+	result, err := r.Run(context.Background(), "echo out; echo err >&2; exit 3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode == nil || *result.ExitCode != 3 || result.Succeeded() {
+		t.Errorf("exit code = %v, want 3", result.ExitCode)
+	}
+	if !strings.Contains(result.Output, "out\n") || !strings.Contains(result.Output, "err\n") {
+		t.Errorf("output = %q", result.Output)
+	}
+}
+
+func TestRunTruncatesOutput(t *testing.T) {
+	r := newTestRunner(t, 10*time.Second, 100)
+
+	result, err := r.Run(context.Background(), "i=0; while [ $i -lt 100 ]; do echo line $i; i=$((i+1)); done", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || len(result.Output) > 100 {
+		t.Errorf("kept %d bytes, truncated %v", len(result.Output), result.Truncated)
+	}
+	if !result.Succeeded() {
+		t.Errorf("exit code = %v, want 0", result.ExitCode)
+	}
+}
+
+func TestRunRefusesWithoutAuthentication(t *testing.T) {
+	if _, err := New(Config{Enabled: true, WorkDir: t.TempDir()}); err == nil {
+		t.Error("runner started without an API key")
+	}
+	if r, err := New(Config{}); r != nil || err != nil {
+		t.Errorf("disabled runner = %v, %v; want nil, nil", r, err)
+	}
+}
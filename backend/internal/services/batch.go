@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// BatchError reports which operation of a batch failed. It unwraps to the
+// underlying service error so callers can map it as they would for the
+// equivalent single request.
+type BatchError struct {
+	Index int
+	Op    string
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d (%s): %v", e.Index, e.Op, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ExecuteBatch runs the operations in order inside one transaction. Any
+// failure rolls back the whole batch and is returned as a *BatchError. On
+// success a single tree_changed event is broadcast.
+func (s *PromptService) ExecuteBatch(ops []models.BatchOperation) ([]models.BatchResult, error) {
+	var results []models.BatchResult
+
+	err := s.inTx(func(tx *PromptService) error {
+		refs := make(map[string]int)
+		results = make([]models.BatchResult, 0, len(ops))
+
+		for i, op := range ops {
+			if _, dup := refs[op.Ref]; op.Ref != "" && dup {
+				return &BatchError{Index: i, Op: op.Op, Err: ErrInvalidInput.Withf("ref %q is already used", op.Ref)}
+			}
+
+			result, err := tx.runBatchOperation(op, refs)
+			if err != nil {
+				return &BatchError{Index: i, Op: op.Op, Err: err}
+			}
+
+			result.Index = i
+			result.Op = op.Op
+			result.Ref = op.Ref
+			if op.Ref != "" {
+				refs[op.Ref] = result.ID
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return results, nil
+}
+
+func (s *PromptService) runBatchOperation(op models.BatchOperation, refs map[string]int) (models.BatchResult, error) {
+	switch op.Op {
+	case "createPrompt":
+		var req models.CreatePromptRequest
+		if err := decodeBatchBody(op.Body, &req); err != nil {
+			return models.BatchResult{}, err
+		}
+		prompt, err := s.CreatePrompt(req.Title, req.Description)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		return models.BatchResult{ID: prompt.ID, Result: prompt}, nil
+
+	case "updatePrompt", "patchPrompt", "deletePrompt":
+		id, err := resolveBatchID(op.PromptID, "promptId", refs)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+
+		var prompt *models.Prompt
+		switch op.Op {
+		case "updatePrompt":
+			var req models.UpdatePromptRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			prompt, err = s.UpdatePrompt(id, req.Title, req.Description, op.Version)
+		case "patchPrompt":
+			var req models.PatchPromptRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			prompt, err = s.PatchPrompt(id, req, op.Version)
+		default:
+			err = s.DeletePrompt(id, op.Version)
+		}
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		if prompt == nil {
+			return models.BatchResult{ID: id}, nil
+		}
+		return models.BatchResult{ID: id, Result: prompt}, nil
+
+	case "createNode":
+		promptID, err := resolveBatchID(op.PromptID, "promptId", refs)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		var req models.CreateNodeRequest
+		if err := decodeBatchBody(op.Body, &req); err != nil {
+			return models.BatchResult{}, err
+		}
+		node, err := s.CreateNode(promptID, req.Name, req.Action, req.Kind)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		return models.BatchResult{ID: node.ID, Result: node}, nil
+
+	case "updateNode", "patchNode", "deleteNode":
+		id, err := resolveBatchID(op.NodeID, "nodeId", refs)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+
+		var node *models.Node
+		switch op.Op {
+		case "updateNode":
+			var req models.UpdateNodeRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			node, err = s.UpdateNode(id, req.Name, req.Action, req.Status, req.Kind, op.Version)
+		case "patchNode":
+			var req models.PatchNodeRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			node, err = s.PatchNode(id, req, op.Version)
+		default:
+			err = s.DeleteNode(id, op.Version)
+		}
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		if node == nil {
+			return models.BatchResult{ID: id}, nil
+		}
+		return models.BatchResult{ID: id, Result: node}, nil
+
+	case "createNote":
+		promptID, err := resolveBatchID(op.PromptID, "promptId", refs)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		var req models.CreateNoteRequest
+		if err := decodeBatchBody(op.Body, &req); err != nil {
+			return models.BatchResult{}, err
+		}
+		var note *models.Note
+		if op.NodeID != nil {
+			var nodeID int
+			if nodeID, err = resolveBatchID(op.NodeID, "nodeId", refs); err != nil {
+				return models.BatchResult{}, err
+			}
+			note, err = s.CreateNodeNote(promptID, nodeID, req)
+		} else {
+			note, err = s.CreateNote(promptID, req)
+		}
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		return models.BatchResult{ID: note.ID, Result: note}, nil
+
+	case "updateNote", "patchNote", "deleteNote":
+		id, err := resolveBatchID(op.NoteID, "noteId", refs)
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		// Like the routes, a prompt and node given with the note must be its own
+		var target *NoteTarget
+		if op.PromptID != nil {
+			target = &NoteTarget{}
+			if target.PromptID, err = resolveBatchID(op.PromptID, "promptId", refs); err != nil {
+				return models.BatchResult{}, err
+			}
+			if op.NodeID != nil {
+				if target.NodeID, err = resolveBatchID(op.NodeID, "nodeId", refs); err != nil {
+					return models.BatchResult{}, err
+				}
+			}
+		}
+
+		var note *models.Note
+		switch op.Op {
+		case "updateNote":
+			var req models.UpdateNoteRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			if req.Content == "" {
+				return models.BatchResult{}, ErrInvalidInput.Withf("content is required")
+			}
+			note, err = s.UpdateNote(target, id, req.Content, op.Version)
+		case "patchNote":
+			var req models.PatchNoteRequest
+			if err := decodeBatchBody(op.Body, &req); err != nil {
+				return models.BatchResult{}, err
+			}
+			note, err = s.PatchNote(target, id, req, op.Version)
+		default:
+			err = s.DeleteNote(target, id, op.Version)
+		}
+		if err != nil {
+			return models.BatchResult{}, err
+		}
+		if note == nil {
+			return models.BatchResult{ID: id}, nil
+		}
+		return models.BatchResult{ID: id, Result: note}, nil
+	}
+
+	return models.BatchResult{}, ErrInvalidInput.Withf("unknown operation %q", op.Op)
+}
+
+// resolveBatchID turns a literal ID or "$ref" into a concrete ID using the
+// refs recorded by earlier operations.
+func resolveBatchID(id *models.BatchID, field string, refs map[string]int) (int, error) {
+	if id == nil {
+		return 0, ErrInvalidInput.Withf("%s is required", field)
+	}
+	if id.Ref == "" {
+		return id.ID, nil
+	}
+
+	resolved, ok := refs[id.Ref]
+	if !ok {
+		return 0, ErrInvalidInput.Withf("%s refers to unknown ref $%s", field, id.Ref)
+	}
+	return resolved, nil
+}
+
+// batchBodies holds the schema of each operation body, as the
+// single-resource routes validate it
+var batchRegistry, batchBodies = batchBodySchemas()
+
+func batchBodySchemas() (huma.Registry, map[reflect.Type]*huma.Schema) {
+	registry := huma.NewMapRegistry("#/components/schemas/", huma.DefaultSchemaNamer)
+	bodies := make(map[reflect.Type]*huma.Schema)
+	for _, body := range []any{
+		models.CreatePromptRequest{}, models.UpdatePromptRequest{}, models.PatchPromptRequest{},
+		models.CreateNodeRequest{}, models.UpdateNodeRequest{}, models.PatchNodeRequest{},
+		models.CreateNoteRequest{}, models.UpdateNoteRequest{}, models.PatchNoteRequest{},
+	} {
+		t := reflect.TypeOf(body)
+		schema := registry.Schema(t, false, t.Name())
+		schema.PrecomputeMessages()
+		bodies[t] = schema
+	}
+	return registry, bodies
+}
+
+// decodeBatchBody checks an operation body against the schema its route
+// uses, then decodes it strictly so a misspelled field fails the batch
+// instead of being ignored.
+func decodeBatchBody(body json.RawMessage, v any) error {
+	if len(body) == 0 {
+		body = json.RawMessage("{}")
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return ErrInvalidInput.Withf("invalid body: %v", err)
+	}
+	res := &huma.ValidateResult{}
+	huma.Validate(batchRegistry, batchBodies[reflect.TypeOf(v).Elem()], huma.NewPathBuffer([]byte("body"), len("body")), huma.ModeWriteToServer, value, res)
+	if len(res.Errors) > 0 {
+		problems := make([]string, len(res.Errors))
+		for i, err := range res.Errors {
+			problems[i] = err.Error()
+		}
+		return ErrInvalidInput.Withf("invalid body: %s", strings.Join(problems, "; "))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return ErrInvalidInput.Withf("invalid body: %v", err)
+	}
+	return nil
+}
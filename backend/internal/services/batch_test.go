@@ -0,0 +1,60 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func TestDecodeBatchBody(t *testing.T) {
+	var create models.CreateNodeRequest
+	if err := decodeBatchBody(json.RawMessage(`{"name":"Track","kind":"command"}`), &create); err != nil {
+		t.Fatal(err)
+	}
+	if create.Name != "Track" || create.Kind != "command" {
+		t.Errorf("decoded %+v", create)
+	}
+
+	var patch models.PatchPromptRequest
+	if err := decodeBatchBody(json.RawMessage(`{"description":null}`), &patch); err != nil {
+		t.Fatal(err)
+	}
+	if !patch.Description.Set || !patch.Description.Null {
+		t.Errorf("decoded %+v", patch)
+	}
+}
+
+func TestDecodeBatchBodyChecksSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		v    any
+		// want is part of the expected message
+		want string
+	}{
+		{"missing required", `{}`, &models.CreatePromptRequest{}, "title"},
+		{"empty body", ``, &models.CreateNoteRequest{}, "content"},
+		{"below minLength", `{"title":""}`, &models.CreatePromptRequest{}, "body.title"},
+		{"above maxLength", `{"content":"x","author":"` + strings.Repeat("a", 256) + `"}`, &models.CreateNoteRequest{}, "body.author"},
+		{"outside enum", `{"name":"Track","kind":"script"}`, &models.CreateNodeRequest{}, "body.kind"},
+		{"status outside enum", `{"status":"finished"}`, &models.UpdateNodeRequest{}, "body.status"},
+		{"wrong type", `{"content":1}`, &models.UpdateNoteRequest{}, "body.content"},
+		{"unknown field", `{"title":"A","titel":"B"}`, &models.UpdatePromptRequest{}, "titel"},
+		{"not an object", `[]`, &models.PatchNodeRequest{}, "object"},
+		{"not json", `{`, &models.PatchNoteRequest{}, "invalid body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeBatchBody(json.RawMessage(tt.body), tt.v)
+			if !errors.Is(err, ErrInvalidInput) {
+				t.Fatalf("err = %v, want ErrInvalidInput", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
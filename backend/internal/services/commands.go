@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/runner"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrRunnerDisabled = errs.New(errs.Unavailable, "runner_disabled", "command execution is disabled")
+	ErrNotCommand     = errs.New(errs.Conflict, "not_a_command", "node is not a command")
+)
+
+// defaultCommandRunLimit is how many runs ListCommandRuns returns unless
+// told otherwise
+const defaultCommandRunLimit = 50
+
+// Output is streamed in chunks of whole lines, sent once this many bytes
+// build up or this long after the first line waiting, so a chatty command
+// sends tens of events rather than thousands
+const (
+	outputChunkBytes    = 8 << 10
+	outputChunkInterval = 250 * time.Millisecond
+)
+
+// commandNodes returns a prompt's nodes in plan order with variables filled
+// in, keeping only command nodes
+func (s *PromptService) commandNodes(promptID int, vars []string) ([]*models.NodeSummary, error) {
+	exists, err := s.repo.PromptExists(promptID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPromptNotFound
+	}
+
+	_, steps, err := s.scriptSteps(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []*models.NodeSummary
+	for _, step := range steps {
+		if step.prompt.ID == promptID && step.node.Kind == models.NodeKindCommand {
+			nodes = append(nodes, step.node)
+		}
+	}
+	return nodes, nil
+}
+
+// RunCommands executes a prompt's command nodes one after another in plan
+// order, in the runner's working directory. Without nodeIDs it runs every
+// command node that is not skipped; with them, just those. A failure stops
+// the rest, which are returned as skipped, unless continueOnError is set.
+func (s *PromptService) RunCommands(ctx context.Context, promptID int, nodeIDs []int, vars []string, continueOnError bool) (*models.RunCommandsResponse, error) {
+	if s.runner == nil {
+		return nil, ErrRunnerDisabled
+	}
+
+	nodes, err := s.commandNodes(promptID, vars)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodeIDs) > 0 {
+		for _, id := range nodeIDs {
+			if !slices.ContainsFunc(nodes, func(n *models.NodeSummary) bool { return n.ID == id }) {
+				return nil, s.notCommand(promptID, id)
+			}
+		}
+		nodes = slices.DeleteFunc(nodes, func(n *models.NodeSummary) bool { return !slices.Contains(nodeIDs, n.ID) })
+	} else {
+		nodes = slices.DeleteFunc(nodes, func(n *models.NodeSummary) bool { return !isRunnable(n) })
+	}
+
+	result := &models.RunCommandsResponse{PromptID: promptID, Skipped: []int{}, Runs: []models.CommandRun{}}
+	for i, node := range nodes {
+		run, err := s.runCommand(ctx, promptID, node)
+		if err != nil {
+			return nil, err
+		}
+		result.Runs = append(result.Runs, *run)
+		if run.Status == models.CommandRunSucceeded {
+			result.Succeeded++
+			continue
+		}
+		result.Failed++
+		if !continueOnError || ctx.Err() != nil {
+			for _, rest := range nodes[i+1:] {
+				result.Skipped = append(result.Skipped, rest.ID)
+			}
+			break
+		}
+	}
+	return result, nil
+}
+
+// RunNodeCommand executes one command node of a prompt, whatever its status
+func (s *PromptService) RunNodeCommand(ctx context.Context, promptID, nodeID int, vars []string) (*models.CommandRun, error) {
+	if s.runner == nil {
+		return nil, ErrRunnerDisabled
+	}
+
+	if _, err := s.promptNode(promptID, nodeID); err != nil {
+		return nil, err
+	}
+	nodes, err := s.commandNodes(promptID, vars)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(nodes, func(n *models.NodeSummary) bool { return n.ID == nodeID })
+	if i < 0 {
+		return nil, s.notCommand(promptID, nodeID)
+	}
+	return s.runCommand(ctx, promptID, nodes[i])
+}
+
+// notCommand explains why a node the caller named cannot run
+func (s *PromptService) notCommand(promptID, nodeID int) error {
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return err
+	}
+	if node == nil || node.PromptID != promptID {
+		return ErrNodeNotFound.Withf("node %d is not in prompt %d", nodeID, promptID)
+	}
+	return ErrNotCommand.Withf("node %d has kind %q; only command nodes run", nodeID, node.Kind)
+}
+
+// runCommand executes a rendered command node, logging the run and
+// streaming its output to event subscribers as it arrives
+func (s *PromptService) runCommand(ctx context.Context, promptID int, node *models.NodeSummary) (*models.CommandRun, error) {
+	command := strings.TrimSpace(node.Action)
+	if command == "" {
+		return nil, ErrInvalidInput.Withf("node %d has no command to run", node.ID)
+	}
+
+	run, err := s.repo.StartCommandRun(node.ID, command)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.BroadcastCommandRunChanged(promptID, run)
+
+	chunks := &outputChunks{send: func(stream, output string) {
+		s.notifier.BroadcastCommandOutput(promptID, run, stream, output)
+	}}
+	result, err := s.runner.Run(ctx, command, func(stream runner.Stream, line string) {
+		chunks.add(string(stream), line)
+	})
+	chunks.flush()
+	switch {
+	case err != nil:
+		run.Status = models.CommandRunFailed
+		run.Output = "failed to start: " + err.Error() + "\n"
+	case result.TimedOut:
+		run.Status = models.CommandRunTimedOut
+	case result.Canceled:
+		run.Status = models.CommandRunCanceled
+	case result.Succeeded():
+		run.Status = models.CommandRunSucceeded
+	default:
+		run.Status = models.CommandRunFailed
+	}
+	if result != nil {
+		run.ExitCode = result.ExitCode
+		run.Output = result.Output
+		run.Truncated = result.Truncated
+		run.DurationMS = int(result.Duration.Milliseconds())
+	}
+
+	if err := s.repo.FinishCommandRun(run); err != nil {
+		return nil, err
+	}
+	s.notifier.BroadcastCommandRunChanged(promptID, run)
+	return run, nil
+}
+
+// ListCommandRuns returns the command runs of a prompt's node, newest first
+func (s *PromptService) ListCommandRuns(promptID, nodeID, limit int) ([]models.CommandRun, error) {
+	if _, err := s.promptNode(promptID, nodeID); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultCommandRunLimit
+	}
+
+	runs, err := s.repo.ListCommandRuns(nodeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []models.CommandRun{}
+	}
+	return runs, nil
+}
+
+// outputChunks gathers a run's output lines, one stream at a time, and
+// hands them to send in chunks
+type outputChunks struct {
+	mu     sync.Mutex
+	stream string
+	buf    strings.Builder
+	timer  *time.Timer
+	send   func(stream, output string)
+}
+
+// add queues a line, sending what came before first if it is from the other
+// stream
+func (c *outputChunks) add(stream, line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.buf.Len() > 0 && stream != c.stream {
+		c.flushLocked()
+	}
+	c.stream = stream
+	c.buf.WriteString(line + "\n")
+	if c.buf.Len() >= outputChunkBytes {
+		c.flushLocked()
+	} else if c.timer == nil {
+		c.timer = time.AfterFunc(outputChunkInterval, c.flush)
+	}
+}
+
+// flush sends the queued lines, if any
+func (c *outputChunks) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *outputChunks) flushLocked() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.buf.Len() == 0 {
+		return
+	}
+	c.send(c.stream, c.buf.String())
+	c.buf.Reset()
+}
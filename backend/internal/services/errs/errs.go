@@ -0,0 +1,83 @@
+// Package errs defines the typed errors the service and repository layers
+// return. The Kind decides how an error is reported to clients and the Code
+// is a stable, machine-readable identifier clients can branch on.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Kind int
+
+const (
+	// Internal errors are not the client's fault and are reported as 500s
+	Internal Kind = iota
+	NotFound
+	Conflict
+	Validation
+	// Invalid means data could not be read at all, such as a stored
+	// document that is not valid JSON
+	Invalid
+	TooLarge
+	// Precondition means a conditional request (If-Match, version) failed
+	Precondition
+	// Unavailable means an optional dependency, such as the LLM provider,
+	// is not configured
+	Unavailable
+	// Upstream means a service this one relies on failed or returned
+	// something unusable
+	Upstream
+)
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Err     error
+}
+
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any *Error with the same code, so errors derived from a
+// sentinel with Withf or Wrap still satisfy errors.Is against it.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Withf returns a copy of e with a more specific message
+func (e *Error) Withf(format string, args ...any) *Error {
+	c := *e
+	c.Message = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// Wrap returns a copy of e that records cause
+func (e *Error) Wrap(cause error) *Error {
+	c := *e
+	c.Err = cause
+	return &c
+}
+
+// As returns the first *Error in err's chain
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
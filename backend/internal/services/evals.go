@@ -0,0 +1,432 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/llm"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrTestCaseNotFound = errs.New(errs.NotFound, "test_case_not_found", "test case not found")
+	ErrInvalidAssertion = errs.New(errs.Validation, "invalid_assertion", "assertion is invalid")
+)
+
+// defaultTestRunLimit is how many runs ListTestRuns returns unless told
+const defaultTestRunLimit = 50
+
+// testMessageTemplate is the user message a test case sends: the node's
+// action in the context of its prompt
+const testMessageTemplate = "## {{prompt_title}}\n{{prompt_description}}\n\n### {{node_name}}\n{{node_action}}"
+
+// check returns why output fails an assertion, or "" if it passes
+type check func(output string) string
+
+// compileAssertion validates an assertion and returns its check
+func compileAssertion(a models.Assertion) (check, error) {
+	switch a.Type {
+	case "contains", "not_contains":
+		if a.Value == "" {
+			return nil, fmt.Errorf("%s needs a value", a.Type)
+		}
+		want := a.Type == "contains"
+		return func(output string) string {
+			if strings.Contains(output, a.Value) != want {
+				if want {
+					return fmt.Sprintf("output does not contain %q", a.Value)
+				}
+				return fmt.Sprintf("output contains %q", a.Value)
+			}
+			return ""
+		}, nil
+
+	case "regex":
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		return func(output string) string {
+			if !re.MatchString(output) {
+				return fmt.Sprintf("output does not match /%s/", a.Value)
+			}
+			return ""
+		}, nil
+
+	case "json_schema":
+		if a.Schema == nil {
+			return nil, fmt.Errorf("json_schema needs a schema")
+		}
+		schema, err := compileSchema(a.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return func(output string) string {
+			value, err := jsonschema.UnmarshalJSON(strings.NewReader(stripFences(output)))
+			if err != nil {
+				return fmt.Sprintf("output is not JSON: %v", err)
+			}
+
+			if problems := validateJSON(schema, value); len(problems) > 0 {
+				return "output does not match the schema: " + strings.Join(problems, "; ")
+			}
+			return ""
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown assertion type %q", a.Type)
+}
+
+// schemaURL names an assertion's schema to the compiler
+const schemaURL = "urn:assertion"
+
+// compileSchema compiles a JSON Schema, by default as draft 2020-12. $ref
+// can only point inside the schema; nothing is loaded from files or the
+// network.
+func compileSchema(raw map[string]any) (*jsonschema.Schema, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := compiler.AddResource(schemaURL, doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	schema, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return schema, nil
+}
+
+// validateJSON returns how value breaks schema, one problem per failing
+// keyword
+func validateJSON(schema *jsonschema.Schema, value any) []string {
+	err := schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) > 0 {
+			for _, cause := range e.Causes {
+				walk(cause)
+			}
+			return
+		}
+		problem := e.ErrorKind.LocalizedString(schemaMessages)
+		if len(e.InstanceLocation) > 0 {
+			problem += " at /" + strings.Join(e.InstanceLocation, "/")
+		}
+		problems = append(problems, problem)
+	}
+	walk(verr)
+	return problems
+}
+
+// schemaMessages prints validation problems in English
+var schemaMessages = message.NewPrinter(language.English)
+
+// stripFences removes a Markdown code fence around the output, which models
+// often add to JSON
+func stripFences(output string) string {
+	output = strings.TrimSpace(output)
+	if !strings.HasPrefix(output, "```") {
+		return output
+	}
+	if i := strings.Index(output, "\n"); i >= 0 {
+		output = output[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(output), "```"))
+}
+
+// validateTestCase checks that the test case only sets defined variables and
+// that every assertion compiles
+func validateTestCase(req models.TestCaseRequest, variables []models.Variable) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return ErrInvalidInput.Withf("name is required")
+	}
+	if len(req.Assertions) == 0 {
+		return ErrInvalidInput.Withf("at least one assertion is required")
+	}
+	for name := range req.Variables {
+		if err := checkPlaceholders(variables, "{{"+name+"}}"); err != nil {
+			return err
+		}
+	}
+	for i, a := range req.Assertions {
+		if _, err := compileAssertion(a); err != nil {
+			return ErrInvalidAssertion.Withf("assertion %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *PromptService) requireNode(nodeID int) (*models.Node, error) {
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+	return node, nil
+}
+
+// testCase returns the test case if it belongs to the node
+func (s *PromptService) testCase(nodeID, testID int) (*models.TestCase, error) {
+	t, err := s.repo.GetTestCase(testID)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || t.NodeID != nodeID {
+		return nil, ErrTestCaseNotFound
+	}
+	return t, nil
+}
+
+func (s *PromptService) ListTestCases(nodeID int) ([]models.TestCase, error) {
+	if _, err := s.requireNode(nodeID); err != nil {
+		return nil, err
+	}
+
+	cases, err := s.repo.ListTestCases(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if cases == nil {
+		cases = []models.TestCase{}
+	}
+	return cases, nil
+}
+
+func (s *PromptService) CreateTestCase(nodeID int, req models.TestCaseRequest) (*models.TestCase, error) {
+	if _, err := s.requireNode(nodeID); err != nil {
+		return nil, err
+	}
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTestCase(req, variables); err != nil {
+		return nil, err
+	}
+
+	return s.repo.CreateTestCase(nodeID, req)
+}
+
+func (s *PromptService) UpdateTestCase(nodeID, testID int, req models.TestCaseRequest) (*models.TestCase, error) {
+	if _, err := s.testCase(nodeID, testID); err != nil {
+		return nil, err
+	}
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTestCase(req, variables); err != nil {
+		return nil, err
+	}
+
+	t, err := s.repo.UpdateTestCase(testID, req)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTestCaseNotFound
+	}
+	return t, nil
+}
+
+func (s *PromptService) DeleteTestCase(nodeID, testID int) error {
+	if _, err := s.testCase(nodeID, testID); err != nil {
+		return err
+	}
+
+	deleted, err := s.repo.DeleteTestCase(testID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrTestCaseNotFound
+	}
+	return nil
+}
+
+// RunTests runs every test case of a node through the LLM and stores the
+// results. Each case sends the node's action, in the context of its prompt
+// and project, with the case's variables laid over the defaults, using the
+// prompt's effective model settings. A variantID other than 0 runs that
+// variant's text instead of the node's action, without promoting it. Runs
+// record the variant they used. If the provider fails, nothing is stored.
+func (s *PromptService) RunTests(ctx context.Context, nodeID, variantID int) (*models.RunTestsResponse, error) {
+	node, err := s.requireNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	var ranVariant *int
+	if variantID != 0 {
+		v, err := s.variant(nodeID, variantID)
+		if err != nil {
+			return nil, err
+		}
+		node.Action = v.Action
+		ranVariant = &v.ID
+	} else {
+		variants, err := s.repo.ListVariants(nodeID)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range variants {
+			if v.Active {
+				ranVariant = &v.ID
+			}
+		}
+	}
+	prompt, err := s.GetPrompt(node.PromptID)
+	if err != nil {
+		return nil, err
+	}
+	project, mainRequest, err := s.repo.GetProjectSettings()
+	if err != nil {
+		return nil, err
+	}
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+	cases, err := s.repo.ListTestCases(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	systemTemplate := defaultSystemTemplate
+	if prompt.EffectiveModel != nil && prompt.EffectiveModel.SystemPrompt != "" {
+		systemTemplate = prompt.EffectiveModel.SystemPrompt
+	}
+
+	result := &models.RunTestsResponse{NodeID: nodeID, Runs: []models.TestRun{}}
+	if len(cases) == 0 {
+		return result, nil
+	}
+	if s.llm == nil {
+		return nil, ErrLLMNotConfigured
+	}
+
+	for _, t := range cases {
+		values := make(map[string]string, len(variables))
+		for _, v := range variables {
+			values[v.Name] = v.Default
+		}
+		for name, value := range t.Variables {
+			values[name] = value
+		}
+
+		run := models.TestRun{TestCaseID: t.ID, NodeID: nodeID, VariantID: ranVariant, Failures: []string{}}
+		for _, name := range placeholders(prompt.Description + "\n" + node.Action) {
+			if values[name] == "" {
+				run.Failures = append(run.Failures, fmt.Sprintf("variable %q has no value; set it in the test case", name))
+			}
+		}
+
+		if len(run.Failures) == 0 {
+			system := fillTemplate(systemTemplate, map[string]string{
+				"project":      project,
+				"main_request": mainRequest,
+			})
+			user := fillTemplate(testMessageTemplate, map[string]string{
+				"prompt_title":       prompt.Title,
+				"prompt_description": substitute(prompt.Description, values),
+				"node_name":          node.Name,
+				"node_action":        substitute(node.Action, values),
+			})
+
+			start := time.Now()
+			output, err := s.llm.Complete(ctx, withModel(llm.Request{System: system, User: user}, prompt.EffectiveModel))
+			if err != nil {
+				log.Printf("LLM provider request failed: %v\n", err)
+				return nil, ErrLLMFailed.Wrap(err)
+			}
+			run.DurationMS = int(time.Since(start).Milliseconds())
+			run.Output = output
+
+			for i, a := range t.Assertions {
+				check, err := compileAssertion(a)
+				if err != nil {
+					run.Failures = append(run.Failures, fmt.Sprintf("assertion %d: %v", i+1, err))
+				} else if failure := check(output); failure != "" {
+					run.Failures = append(run.Failures, fmt.Sprintf("assertion %d: %s", i+1, failure))
+				}
+			}
+		}
+
+		run.Passed = len(run.Failures) == 0
+		if run.Passed {
+			result.Passed++
+		} else {
+			result.Failed++
+		}
+		result.Runs = append(result.Runs, run)
+	}
+
+	err = s.inTx(func(tx *PromptService) error {
+		for i := range result.Runs {
+			if err := tx.repo.CreateTestRun(&result.Runs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListTestRuns returns a node's run history, newest first. A testCaseID
+// other than 0 keeps only that test case's runs.
+func (s *PromptService) ListTestRuns(nodeID, testCaseID, limit int) ([]models.TestRun, error) {
+	if _, err := s.requireNode(nodeID); err != nil {
+		return nil, err
+	}
+	if testCaseID != 0 {
+		if _, err := s.testCase(nodeID, testCaseID); err != nil {
+			return nil, err
+		}
+	}
+	if limit <= 0 {
+		limit = defaultTestRunLimit
+	}
+
+	runs, err := s.repo.ListTestRuns(nodeID, testCaseID, limit)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []models.TestRun{}
+	}
+	return runs, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func schemaAssertion(t *testing.T, schema string) models.Assertion {
+	t.Helper()
+	a := models.Assertion{Type: "json_schema"}
+	if err := json.Unmarshal([]byte(schema), &a.Schema); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestCompileAssertion(t *testing.T) {
+	person := `{"type":"object","required":["name"],"properties":{"name":{"type":"string","minLength":1},"tags":{"type":"array","items":{"type":"string"}}}}`
+	tests := []struct {
+		name      string
+		assertion models.Assertion
+		output    string
+		// failure is part of the expected failure, or "" for a pass
+		failure string
+	}{
+		{"contains", models.Assertion{Type: "contains", Value: "ok"}, "all ok", ""},
+		{"contains misses", models.Assertion{Type: "contains", Value: "ok"}, "fine", `does not contain "ok"`},
+		{"not_contains", models.Assertion{Type: "not_contains", Value: "error"}, "fine", ""},
+		{"not_contains hits", models.Assertion{Type: "not_contains", Value: "error"}, "an error", `contains "error"`},
+		{"regex", models.Assertion{Type: "regex", Value: `^\d+$`}, "42", ""},
+		{"regex misses", models.Assertion{Type: "regex", Value: `^\d+$`}, "forty", "does not match"},
+		{"schema", schemaAssertion(t, person), `{"name":"Ada","tags":["x"]}`, ""},
+		{"schema in fences", schemaAssertion(t, person), "```json\n{\"name\":\"Ada\"}\n```", ""},
+		{"schema not json", schemaAssertion(t, person), "Ada", "not JSON"},
+		{"schema missing member", schemaAssertion(t, person), `{"tags":[]}`, "name"},
+		{"schema wrong item", schemaAssertion(t, person), `{"name":"Ada","tags":[1]}`, "at /tags/0"},
+		{"required without property", schemaAssertion(t, `{"type":"object","required":["id"]}`), `{}`, "id"},
+		{"array without items", schemaAssertion(t, `{"type":"array","minItems":1}`), `[]`, "does not match"},
+		{"additional properties schema", schemaAssertion(t, `{"type":"object","additionalProperties":{"type":"integer"}}`), `{"a":"x"}`, "does not match"},
+		{"every problem", schemaAssertion(t, person), `{"tags":["x",2,3]}`, "'name'; got number, want string at /tags/1; got number, want string at /tags/2"},
+		{"local reference", schemaAssertion(t, `{"$defs":{"id":{"type":"integer"}},"properties":{"id":{"$ref":"#/$defs/id"}}}`), `{"id":"x"}`, "at /id"},
+		{"large integer", schemaAssertion(t, `{"type":"integer","maximum":9007199254740993}`), `9007199254740994`, "maximum"},
+		{"trailing text", schemaAssertion(t, person), `{"name":"Ada"} and more`, "not JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, err := compileAssertion(tt.assertion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failure := check(tt.output)
+			if tt.failure == "" && failure != "" {
+				t.Errorf("failed: %s", failure)
+			}
+			if tt.failure != "" && !strings.Contains(failure, tt.failure) {
+				t.Errorf("failure = %q, want it to mention %q", failure, tt.failure)
+			}
+		})
+	}
+}
+
+func TestCompileAssertionRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		assertion models.Assertion
+	}{
+		{"contains without value", models.Assertion{Type: "contains"}},
+		{"invalid regex", models.Assertion{Type: "regex", Value: "("}},
+		{"schema missing", models.Assertion{Type: "json_schema"}},
+		{"schema missing reference", schemaAssertion(t, `{"properties":{"a":{"$ref":"#/x"}}}`)},
+		{"schema file reference", schemaAssertion(t, `{"$ref":"file:///etc/passwd"}`)},
+		{"schema remote reference", schemaAssertion(t, `{"$ref":"https://example.com/schema.json"}`)},
+		{"schema unknown type", schemaAssertion(t, `{"type":"strin"}`)},
+		{"schema invalid pattern", schemaAssertion(t, `{"type":"string","pattern":"("}`)},
+		{"nested invalid pattern", schemaAssertion(t, `{"type":"array","items":{"type":"string","pattern":"["}}`)},
+		{"schema wrong shape", schemaAssertion(t, `{"type":"object","properties":[]}`)},
+		{"unknown type", models.Assertion{Type: "equals", Value: "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileAssertion(tt.assertion); err == nil {
+				t.Error("compiled")
+			}
+		})
+	}
+}
+
+// TestSchemaAssertionsNeverPanic throws odd but valid schemas at the
+// validator with outputs of every JSON type
+func TestSchemaAssertionsNeverPanic(t *testing.T) {
+	schemas := []string{
+		`{}`,
+		`{"type":"array"}`,
+		`{"type":"array","items":{"type":"array"}}`,
+		`{"type":"object","properties":{"a":{"type":"array"}},"required":["a","b"]}`,
+		`{"additionalProperties":{"type":"array"}}`,
+		`{"additionalProperties":false,"properties":{"a":{}}}`,
+		`{"oneOf":[{"type":"array"},{"type":"object","required":["x"]}]}`,
+		`{"anyOf":[{"type":"string","pattern":"a"}],"not":{"type":"array"}}`,
+		`{"allOf":[{"type":"object","properties":{"n":{"type":"integer","multipleOf":0}}}]}`,
+		`{"type":"string","format":"unknown-format","enum":["a",1,null]}`,
+		`{"type":"number","minimum":5,"exclusiveMaximum":1}`,
+		`{"items":{"properties":{"deep":{"type":"array"}}}}`,
+		`{"type":"object","minProperties":1,"maxProperties":0,"dependentRequired":{"a":["b"]}}`,
+	}
+	outputs := []string{`null`, `true`, `0`, `1.5`, `"a"`, `[]`, `[[1],{"a":[]}]`, `{}`, `{"a":[1],"n":3,"deep":[[]]}`}
+
+	for _, schema := range schemas {
+		check, err := compileAssertion(schemaAssertion(t, schema))
+		if err != nil {
+			continue
+		}
+		for _, output := range outputs {
+			check(output)
+		}
+	}
+}
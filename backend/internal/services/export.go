@@ -0,0 +1,113 @@
+package services
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// Default templates for the chat and document exports. Both may use
+// {{project}} and {{main_request}} and the model settings {{provider}},
+// {{model}}, {{temperature}}, {{max_tokens}} and {{system_prompt}}: the
+// project defaults in the system template, the prompt's effective settings
+// in the message template. The message template also gets {{prompt_title}},
+// {{prompt_description}}, {{node_name}}, {{node_action}}, {{node_status}},
+// {{step}} and {{total}}.
+const (
+	defaultSystemTemplate  = "You are helping build {{project}}.\n\n{{main_request}}"
+	defaultMessageTemplate = "## {{prompt_title}}\n{{prompt_description}}\n\n### Step {{step}} of {{total}}: {{node_name}}\n{{node_action}}"
+)
+
+// blankLines collapses the gaps left by empty fields
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+func fillTemplate(template string, values map[string]string) string {
+	return strings.TrimSpace(blankLines.ReplaceAllString(substitute(template, values), "\n\n"))
+}
+
+// ExportChat turns the rendered tree into chat messages: a system message
+// from the project and its main request, then one user message per node in
+// dependency order, each carrying its prompt's title and description and its
+// prompt's effective model settings. An empty system template falls back to
+// the project's default system prompt, then to the default template; an
+// empty message template uses the default.
+func (s *PromptService) ExportChat(vars []string, systemTemplate, messageTemplate string) (*models.ChatExport, error) {
+	tree, err := s.RenderTree(vars)
+	if err != nil {
+		return nil, err
+	}
+	if systemTemplate == "" && tree.Model != nil {
+		systemTemplate = tree.Model.SystemPrompt
+	}
+	if systemTemplate == "" {
+		systemTemplate = defaultSystemTemplate
+	}
+	if messageTemplate == "" {
+		messageTemplate = defaultMessageTemplate
+	}
+
+	prompts := make(map[int]*models.PromptNode, len(tree.Prompts))
+	nodes := make(map[int]*models.NodeSummary)
+	for i := range tree.Prompts {
+		prompt := &tree.Prompts[i]
+		prompts[prompt.ID] = prompt
+		for j := range prompt.Nodes {
+			nodes[prompt.Nodes[j].ID] = &prompt.Nodes[j]
+		}
+	}
+
+	project := map[string]string{
+		"project":      tree.Project,
+		"main_request": tree.MainRequest,
+	}
+	modelValues(tree.Model, project)
+	export := &models.ChatExport{
+		Project: tree.Project,
+		Messages: []models.ChatMessage{
+			{Role: "system", Content: fillTemplate(systemTemplate, project), Model: tree.Model},
+		},
+	}
+
+	steps := planSteps(tree)
+	for i, step := range steps {
+		prompt, node := prompts[step.PromptID], nodes[step.ID]
+		values := map[string]string{
+			"project":            tree.Project,
+			"main_request":       tree.MainRequest,
+			"prompt_title":       prompt.Title,
+			"prompt_description": prompt.Description,
+			"node_name":          node.Name,
+			"node_action":        node.Action,
+			"node_status":        node.Status,
+			"step":               strconv.Itoa(i + 1),
+			"total":              strconv.Itoa(len(steps)),
+		}
+		modelValues(prompt.EffectiveModel, values)
+		export.Messages = append(export.Messages, models.ChatMessage{
+			Role:     "user",
+			Content:  fillTemplate(messageTemplate, values),
+			PromptID: prompt.ID,
+			NodeID:   node.ID,
+			Variant:  node.Variant,
+			Model:    prompt.EffectiveModel,
+		})
+	}
+	return export, nil
+}
+
+// ExportDocument flattens the chat export into a single prompt, the
+// messages separated by blank lines
+func (s *PromptService) ExportDocument(vars []string, systemTemplate, messageTemplate string) (string, error) {
+	export, err := s.ExportChat(vars, systemTemplate, messageTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	parts := make([]string, 0, len(export.Messages))
+	for _, m := range export.Messages {
+		parts = append(parts, m.Content)
+	}
+	return strings.Join(parts, "\n\n") + "\n", nil
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/llm"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrLLMNotConfigured = errs.New(errs.Unavailable, "llm_not_configured", "no LLM provider is configured")
+	ErrLLMFailed        = errs.New(errs.Upstream, "llm_failed", "LLM provider request failed")
+	ErrLLMInvalidOutput = errs.New(errs.Upstream, "llm_invalid_output", "LLM provider returned an unusable answer")
+)
+
+const generateTreeInstructions = `You plan software projects as a tree of prompts.
+Break the user's request into 3 to 8 prompts, each a self-contained piece of
+work, and each prompt into 2 to 6 nodes, the concrete steps to complete it.
+Answer with a single JSON object and nothing else, shaped like:
+{"prompts": [{"title": "...", "description": "...", "nodes": [{"name": "...", "action": "..."}]}]}
+Titles and node names are short; descriptions and actions are one or two
+sentences.`
+
+const suggestNodesInstructions = `You help break a prompt in a software project into nodes,
+the concrete steps that complete it. The user gives the prompt's title and
+description, the project it belongs to and the nodes it already has.
+Propose up to %d new nodes that fill the gaps, without repeating existing
+ones, in the order they should be done.
+Answer with a single JSON object and nothing else, shaped like:
+{"nodes": [{"name": "...", "action": "..."}]}
+Names are short; actions are one or two sentences.`
+
+// defaultSuggestions is how many nodes SuggestNodes proposes unless told
+const defaultSuggestions = 5
+
+// outline is the shape the model is asked to answer in: prompts when
+// generating a tree, nodes when expanding a single prompt
+type outline struct {
+	Prompts []outlinePrompt `json:"prompts"`
+	Nodes   []outlineNode   `json:"nodes"`
+}
+
+type outlinePrompt struct {
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Nodes       []outlineNode `json:"nodes"`
+}
+
+type outlineNode struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// complete asks the provider for a JSON answer and decodes it into an
+// outline. Code fences and any prose around the object are ignored, since
+// not every model honours JSON mode.
+func (s *PromptService) complete(ctx context.Context, req llm.Request) (*outline, error) {
+	if s.llm == nil {
+		return nil, ErrLLMNotConfigured
+	}
+
+	req.JSON = true
+	text, err := s.llm.Complete(ctx, req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		log.Printf("LLM provider request failed: %v\n", err)
+		return nil, ErrLLMFailed.Wrap(err)
+	}
+
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, ErrLLMInvalidOutput.Withf("answer contains no JSON object")
+	}
+
+	var out outline
+	if err := json.Unmarshal([]byte(text[start:end+1]), &out); err != nil {
+		return nil, ErrLLMInvalidOutput.Wrap(err)
+	}
+	return &out, nil
+}
+
+// variableList describes the project variables for the instructions, so the
+// model may use them as placeholders
+func variableList(variables []models.Variable) string {
+	if len(variables) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\nThese project variables may be used as {{name}} placeholders; do not invent others:\n")
+	for _, v := range variables {
+		fmt.Fprintf(&b, "- %s: %s\n", v.Name, v.Description)
+	}
+	return b.String()
+}
+
+// GenerateTree asks the LLM to break mainRequest, or the project's own main
+// request when it is empty, into prompts and nodes. The result must pass the
+// same rules as an import. It is returned as a draft unless apply is set, in
+// which case it replaces the current tree like ImportTree.
+func (s *PromptService) GenerateTree(ctx context.Context, mainRequest string, apply bool, ifVersion *int) (*models.GenerateTreeResponse, error) {
+	current, err := s.GetTree()
+	if err != nil {
+		return nil, err
+	}
+
+	mainRequest = strings.TrimSpace(mainRequest)
+	if mainRequest == "" {
+		mainRequest = current.MainRequest
+	}
+	if mainRequest == "" {
+		return nil, ErrInvalidInput.Withf("mainRequest is required when the project has none")
+	}
+
+	out, err := s.complete(ctx, llm.Request{
+		System: generateTreeInstructions + variableList(current.Variables),
+		User:   mainRequest,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &models.TreeResponse{
+		Project:     current.Project,
+		MainRequest: mainRequest,
+		Model:       current.Model,
+		Variables:   current.Variables,
+		Prompts:     make([]models.PromptNode, 0, len(out.Prompts)),
+	}
+	for _, p := range out.Prompts {
+		prompt := models.PromptNode{
+			Title:       strings.TrimSpace(p.Title),
+			Description: strings.TrimSpace(p.Description),
+			Tags:        []string{},
+		}
+		for _, n := range p.Nodes {
+			prompt.Nodes = append(prompt.Nodes, models.NodeSummary{
+				Name:   strings.TrimSpace(n.Name),
+				Action: strings.TrimSpace(n.Action),
+			})
+		}
+		tree.Prompts = append(tree.Prompts, prompt)
+	}
+
+	if err := validateTree(tree); err != nil {
+		return nil, ErrLLMInvalidOutput.Withf("generated tree is invalid: %v", err)
+	}
+
+	if !apply {
+		return &models.GenerateTreeResponse{Tree: *tree}, nil
+	}
+
+	if err := s.ImportTree(tree, ifVersion); err != nil {
+		return nil, err
+	}
+	applied, err := s.GetTree()
+	if err != nil {
+		return nil, err
+	}
+	return &models.GenerateTreeResponse{Applied: true, Tree: *applied}, nil
+}
+
+// SuggestNodes asks the LLM for up to max new nodes for a prompt, given the
+// project, the prompt and the nodes it already has. Nothing is stored; each
+// suggestion can be accepted by creating it as a node. Suggestions that
+// repeat an existing node name or use an undefined variable are dropped.
+func (s *PromptService) SuggestNodes(ctx context.Context, promptID int, guidance string, max int) ([]models.CreateNodeRequest, error) {
+	if max <= 0 {
+		max = defaultSuggestions
+	}
+
+	prompt, err := s.GetPrompt(promptID)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := s.repo.GetNodesByPromptID(promptID)
+	if err != nil {
+		return nil, err
+	}
+	project, mainRequest, err := s.repo.GetProjectSettings()
+	if err != nil {
+		return nil, err
+	}
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+
+	// The prompt's title leads so it is the subject of the request
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", prompt.Title)
+	if prompt.Description != "" {
+		fmt.Fprintf(&b, "%s\n", prompt.Description)
+	}
+	fmt.Fprintf(&b, "\nProject: %s\n", project)
+	if mainRequest != "" {
+		fmt.Fprintf(&b, "Project goal: %s\n", mainRequest)
+	}
+	if len(nodes) > 0 {
+		b.WriteString("Existing nodes:\n")
+		for _, n := range nodes {
+			fmt.Fprintf(&b, "- %s: %s\n", n.Name, n.Action)
+		}
+	}
+	if guidance = strings.TrimSpace(guidance); guidance != "" {
+		fmt.Fprintf(&b, "Guidance: %s\n", guidance)
+	}
+
+	out, err := s.complete(ctx, llm.Request{
+		System: fmt.Sprintf(suggestNodesInstructions, max) + variableList(variables),
+		User:   b.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		seen[strings.ToLower(n.Name)] = true
+	}
+
+	suggestions := []models.CreateNodeRequest{}
+	for _, n := range out.Nodes {
+		name, action := strings.TrimSpace(n.Name), strings.TrimSpace(n.Action)
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		if checkPlaceholders(variables, action) != nil {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		suggestions = append(suggestions, models.CreateNodeRequest{Name: name, Action: action})
+		if len(suggestions) == max {
+			break
+		}
+	}
+	return suggestions, nil
+}
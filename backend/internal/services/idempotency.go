@@ -0,0 +1,80 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/repository"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errs.New(errs.Validation, "idempotency_key_reused", "idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProgress = errs.New(errs.Conflict, "idempotency_key_in_progress", "a request with this idempotency key is still in progress")
+)
+
+// idempotencyLease is how long a claimed key may stay in progress. A claim
+// older than that belongs to a request that crashed or was killed, so a
+// retry may take the key over. It outlasts the default LLM timeout.
+const idempotencyLease = 5 * time.Minute
+
+// IdempotencyService stores responses under client-supplied Idempotency-Key
+// values so a retried create or import replays the original result instead
+// of running again.
+type IdempotencyService struct {
+	repo   *repository.PromptRepository
+	window time.Duration
+}
+
+func NewIdempotencyService(repo *repository.PromptRepository, window time.Duration) *IdempotencyService {
+	return &IdempotencyService{repo: repo, window: window}
+}
+
+// Begin claims key for scope (method and path). It returns nil when the
+// caller should run the request and then call Finish, or the stored record
+// when the response should be replayed as-is.
+func (s *IdempotencyService) Begin(key, scope string, requestBody []byte) (*models.IdempotencyRecord, error) {
+	if len(key) > 255 {
+		return nil, errs.New(errs.TooLarge, "idempotency_key_too_long", "Idempotency-Key must be at most 255 characters")
+	}
+
+	hash := sha256.Sum256(requestBody)
+	requestHash := hex.EncodeToString(hash[:])
+
+	now := time.Now()
+	record, claimed, err := s.repo.ClaimIdempotencyKey(key, scope, requestHash, now.Add(-s.window), now.Add(-idempotencyLease))
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if record.StatusCode == nil {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return record, nil
+}
+
+// Finish stores the response for a claimed key. Server errors are not
+// stored; the claim is released instead so the client can retry.
+func (s *IdempotencyService) Finish(key, scope string, statusCode int, headers map[string][]string, body []byte) {
+	if statusCode < 500 {
+		err := s.repo.CompleteIdempotencyKey(key, scope, statusCode, headers, body)
+		if err == nil {
+			return
+		}
+		log.Printf("Warning: failed to store response for idempotency key %q: %v\n", key, err)
+	}
+
+	// Never leave a claim pending, or every retry would see "in progress".
+	if err := s.repo.ReleaseIdempotencyKey(key, scope); err != nil {
+		log.Printf("Warning: failed to release idempotency key %q: %v\n", key, err)
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/llm"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var ErrInvalidModelConfig = errs.New(errs.Validation, "invalid_model_config", "model settings are invalid")
+
+// normalizeModelConfig trims the settings and checks their ranges. Settings
+// that set nothing come back as nil, meaning inherit everything.
+func normalizeModelConfig(cfg *models.ModelConfig) (*models.ModelConfig, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	out := *cfg
+	out.Provider = strings.TrimSpace(out.Provider)
+	out.Model = strings.TrimSpace(out.Model)
+	out.SystemPrompt = strings.TrimSpace(out.SystemPrompt)
+	if out.Temperature != nil {
+		if *out.Temperature < 0 || *out.Temperature > 2 {
+			return nil, ErrInvalidModelConfig.Withf("temperature must be between 0 and 2, got %v", *out.Temperature)
+		}
+		t := *out.Temperature
+		out.Temperature = &t
+	}
+	if out.MaxTokens < 0 {
+		return nil, ErrInvalidModelConfig.Withf("max_tokens cannot be negative, got %d", out.MaxTokens)
+	}
+
+	if out == (models.ModelConfig{}) {
+		return nil, nil
+	}
+	return &out, nil
+}
+
+// effectiveModel lays a prompt's own settings over the project defaults,
+// field by field. It returns nil when neither sets anything.
+func effectiveModel(defaults, own *models.ModelConfig) *models.ModelConfig {
+	var out models.ModelConfig
+	for _, cfg := range []*models.ModelConfig{defaults, own} {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Provider != "" {
+			out.Provider = cfg.Provider
+		}
+		if cfg.Model != "" {
+			out.Model = cfg.Model
+		}
+		if cfg.Temperature != nil {
+			t := *cfg.Temperature
+			out.Temperature = &t
+		}
+		if cfg.MaxTokens != 0 {
+			out.MaxTokens = cfg.MaxTokens
+		}
+		if cfg.SystemPrompt != "" {
+			out.SystemPrompt = cfg.SystemPrompt
+		}
+	}
+
+	if out == (models.ModelConfig{}) {
+		return nil
+	}
+	return &out
+}
+
+func sameModelConfig(a, b *models.ModelConfig) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if (a.Temperature == nil) != (b.Temperature == nil) {
+		return false
+	}
+	if a.Temperature != nil && *a.Temperature != *b.Temperature {
+		return false
+	}
+	return a.Provider == b.Provider && a.Model == b.Model && a.MaxTokens == b.MaxTokens && a.SystemPrompt == b.SystemPrompt
+}
+
+// withModel applies the model name, temperature and token cap to a request.
+// The provider is not switched: requests go to the configured one.
+func withModel(req llm.Request, cfg *models.ModelConfig) llm.Request {
+	if cfg != nil {
+		req.Model = cfg.Model
+		req.Temperature = cfg.Temperature
+		req.MaxTokens = cfg.MaxTokens
+	}
+	return req
+}
+
+// modelValues exposes settings to the export templates as {{provider}},
+// {{model}}, {{temperature}}, {{max_tokens}} and {{system_prompt}}. Unset
+// settings are empty.
+func modelValues(cfg *models.ModelConfig, values map[string]string) {
+	if cfg == nil {
+		cfg = &models.ModelConfig{}
+	}
+	values["provider"] = cfg.Provider
+	values["model"] = cfg.Model
+	values["temperature"] = ""
+	if cfg.Temperature != nil {
+		values["temperature"] = strconv.FormatFloat(*cfg.Temperature, 'f', -1, 64)
+	}
+	values["max_tokens"] = ""
+	if cfg.MaxTokens != 0 {
+		values["max_tokens"] = strconv.Itoa(cfg.MaxTokens)
+	}
+	values["system_prompt"] = cfg.SystemPrompt
+}
+
+// GetModelDefaults returns the project's default model settings. With none
+// set it returns empty settings rather than nil.
+func (s *PromptService) GetModelDefaults() (*models.ModelConfig, error) {
+	cfg, err := s.repo.GetModelDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &models.ModelConfig{}
+	}
+	return cfg, nil
+}
+
+// SetModelDefaults replaces the project's default model settings, which
+// every prompt inherits; empty settings clear them
+func (s *PromptService) SetModelDefaults(cfg models.ModelConfig) (*models.ModelConfig, error) {
+	normalized, err := normalizeModelConfig(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetModelDefaults(normalized); err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	if normalized == nil {
+		normalized = &models.ModelConfig{}
+	}
+	return normalized, nil
+}
+
+// SetPromptModel replaces a prompt's own model settings; empty settings go
+// back to inheriting the project defaults. It returns the updated prompt.
+func (s *PromptService) SetPromptModel(id int, cfg models.ModelConfig, ifVersion *int) (*models.PromptDetail, error) {
+	normalized, err := normalizeModelConfig(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.SetPromptModel(id, normalized, ifVersion); err != nil {
+		return nil, versionError(err, ErrPromptNotFound)
+	}
+
+	s.notifier.BroadcastPromptChanged(id)
+	return s.GetPrompt(id)
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var ErrNoteIsReply = errs.New(errs.Conflict, "note_is_reply", "replies are resolved with their thread")
+
+// mentionPattern finds @name where the @ does not follow a word, so email
+// addresses are not mentions
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([A-Za-z0-9][A-Za-z0-9_.-]*)`)
+
+// maxMentionLength matches the mentions table's name column
+const maxMentionLength = 64
+
+// parseMentions returns the names @mentioned in content, lowercased,
+// de-duplicated and sorted. Trailing dots and dashes are punctuation.
+func parseMentions(content string) []string {
+	mentions := []string{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.ToLower(strings.TrimRight(match[1], ".-"))
+		if len(name) > maxMentionLength || slices.Contains(mentions, name) {
+			continue
+		}
+		mentions = append(mentions, name)
+	}
+	slices.Sort(mentions)
+	return mentions
+}
+
+// writeNote runs write, which creates or edits a note, and records the
+// note's mentions in the same transaction
+func (s *PromptService) writeNote(write func(tx *PromptService) (*models.Note, error)) (*models.Note, error) {
+	var note *models.Note
+	err := s.inTx(func(tx *PromptService) error {
+		var err error
+		if note, err = write(tx); err != nil {
+			return err
+		}
+		note.Mentions = parseMentions(note.Content)
+		return tx.repo.SetNoteMentions(note.ID, note.Mentions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNoteChanged(note)
+	return note, nil
+}
+
+// threadNotes nests replies under their thread, oldest first, given notes
+// newest first. Threads stay newest first.
+func threadNotes(notes []models.Note) []models.Note {
+	threads := []models.Note{}
+	index := make(map[int]int)
+	for _, note := range notes {
+		if note.ParentID == nil {
+			index[note.ID] = len(threads)
+			threads = append(threads, note)
+		}
+	}
+	for _, note := range slices.Backward(notes) {
+		if note.ParentID == nil {
+			continue
+		}
+		if i, ok := index[*note.ParentID]; ok {
+			threads[i].Replies = append(threads[i].Replies, note)
+		}
+	}
+	return threads
+}
+
+// NoteTarget is the prompt, node or saved tree a route reaches a note
+// through. A note on a node is only reached through that node.
+type NoteTarget struct {
+	PromptID  int
+	NodeID    int
+	SavedTree string
+}
+
+// has reports whether the note is on the target
+func (t NoteTarget) has(note *models.Note) bool {
+	switch {
+	case t.SavedTree != "":
+		return note.SavedTree == t.SavedTree
+	case t.NodeID != 0:
+		return note.NodeID != nil && *note.NodeID == t.NodeID && note.PromptID == t.PromptID
+	}
+	return note.NodeID == nil && note.SavedTree == "" && note.PromptID == t.PromptID
+}
+
+func (t NoteTarget) kind() string {
+	switch {
+	case t.SavedTree != "":
+		return "saved tree"
+	case t.NodeID != 0:
+		return "node"
+	}
+	return "prompt"
+}
+
+// targetNote returns a note, checking it is on the target when one is given;
+// batch operations name notes by ID alone
+func (s *PromptService) targetNote(target *NoteTarget, noteID int) (*models.Note, error) {
+	note, err := s.repo.GetNoteByID(noteID)
+	if err != nil {
+		return nil, err
+	}
+	if note == nil {
+		return nil, ErrNoteNotFound
+	}
+	if target != nil && !target.has(note) {
+		return nil, ErrNoteNotFound.Withf("note %d is not on the addressed %s", noteID, target.kind())
+	}
+	return note, nil
+}
+
+// promptNode returns a node, checking it belongs to the prompt the route
+// names
+func (s *PromptService) promptNode(promptID, nodeID int) (*models.Node, error) {
+	node, err := s.requireNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node.PromptID != promptID {
+		return nil, ErrNodeNotFound.Withf("node %d is not in prompt %d", nodeID, promptID)
+	}
+	return node, nil
+}
+
+// GetNodeNotes returns the threads on one node, newest first
+func (s *PromptService) GetNodeNotes(promptID, nodeID int) ([]models.Note, error) {
+	if _, err := s.promptNode(promptID, nodeID); err != nil {
+		return nil, err
+	}
+
+	notes, err := s.repo.GetNotesByNodeID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return threadNotes(notes), nil
+}
+
+// CreateNodeNote annotates one node rather than its whole prompt
+func (s *PromptService) CreateNodeNote(promptID, nodeID int, req models.CreateNoteRequest) (*models.Note, error) {
+	if _, err := s.promptNode(promptID, nodeID); err != nil {
+		return nil, err
+	}
+
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		return tx.repo.CreateNodeNote(nodeID, req.Author, req.Content)
+	})
+}
+
+// savedTree returns a saved tree by name
+func (s *PromptService) savedTree(name string) (*models.SavedTree, error) {
+	saved, err := s.repo.GetSavedTree(name)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		return nil, ErrSavedTreeNotFound
+	}
+	return saved, nil
+}
+
+// GetSavedTreeNotes returns the threads on a saved tree, newest first
+func (s *PromptService) GetSavedTreeNotes(name string) ([]models.Note, error) {
+	saved, err := s.savedTree(name)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := s.repo.GetNotesBySavedTreeID(saved.ID)
+	if err != nil {
+		return nil, err
+	}
+	return threadNotes(notes), nil
+}
+
+// CreateSavedTreeNote annotates a saved tree. The note stays with the saved
+// tree when it is saved over and goes when it is deleted.
+func (s *PromptService) CreateSavedTreeNote(name string, req models.CreateNoteRequest) (*models.Note, error) {
+	saved, err := s.savedTree(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		return tx.repo.CreateSavedTreeNote(saved.ID, req.Author, req.Content)
+	})
+}
+
+// ReplyToNote adds a reply to a note's thread. Threads are one level deep,
+// so replying to a reply answers the note it replies to.
+func (s *PromptService) ReplyToNote(target *NoteTarget, noteID int, req models.CreateNoteRequest) (*models.Note, error) {
+	parent, err := s.targetNote(target, noteID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != nil {
+		noteID = *parent.ParentID
+	}
+
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		note, err := tx.repo.CreateNoteReply(noteID, req.Author, req.Content)
+		if err != nil {
+			return nil, err
+		}
+		if note == nil {
+			return nil, ErrNoteNotFound
+		}
+		return note, nil
+	})
+}
+
+// ResolveNote resolves a thread, or reopens it when resolved is false. The
+// note stays locked from the checks to the update.
+func (s *PromptService) ResolveNote(target *NoteTarget, noteID int, resolved bool, ifVersion *int) (*models.Note, error) {
+	var updated *models.Note
+	err := s.inTx(func(tx *PromptService) error {
+		if err := tx.repo.LockNote(noteID); err != nil {
+			return err
+		}
+		note, err := tx.targetNote(target, noteID)
+		if err != nil {
+			return err
+		}
+		if note.ParentID != nil {
+			return ErrNoteIsReply.Withf("note %d is a reply; resolve note %d instead", noteID, *note.ParentID)
+		}
+
+		updated, err = tx.repo.SetNoteResolved(noteID, resolved, ifVersion)
+		return versionError(err, ErrNoteNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNoteChanged(updated)
+	return updated, nil
+}
+
+// BackfillMentions records the mentions of notes written before mentions
+// were tracked. Notes with an @ but no recorded mentions are parsed on every
+// start, which finds nothing again for ones that only hold email addresses.
+func (s *PromptService) BackfillMentions() error {
+	notes, err := s.repo.GetNotesWithoutMentions()
+	if err != nil {
+		return err
+	}
+
+	backfilled := 0
+	err = s.inTx(func(tx *PromptService) error {
+		for _, note := range notes {
+			mentions := parseMentions(note.Content)
+			if len(mentions) == 0 {
+				continue
+			}
+			if err := tx.repo.SetNoteMentions(note.ID, mentions); err != nil {
+				return err
+			}
+			backfilled++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if backfilled > 0 {
+		fmt.Printf("✓ Mentions recorded for %d existing notes\n", backfilled)
+	}
+	return nil
+}
+
+// GetMentions returns the notes that @mention name, newest first, leaving out
+// resolved threads when unresolved is set
+func (s *PromptService) GetMentions(name string, unresolved bool) ([]models.Note, error) {
+	name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+	if name == "" || len(name) > maxMentionLength {
+		return nil, ErrInvalidInput.Withf("name must be 1-%d characters", maxMentionLength)
+	}
+
+	notes, err := s.repo.ListNotesByMention(name, unresolved)
+	if err != nil {
+		return nil, err
+	}
+	if notes == nil {
+		notes = []models.Note{}
+	}
+	return notes, nil
+}
+
+// CountNodeNotes fills in how many notes each node of the tree has
+func (s *PromptService) CountNodeNotes(tree *models.TreeResponse) error {
+	counts, err := s.repo.CountNotesByNode()
+	if err != nil {
+		return err
+	}
+	for i := range tree.Prompts {
+		for j := range tree.Prompts[i].Nodes {
+			node := &tree.Prompts[i].Nodes[j]
+			node.Notes = counts[node.ID]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func TestNoteTargetHas(t *testing.T) {
+	nodeID := 9
+	promptNote := &models.Note{ID: 1, PromptID: 3}
+	nodeNote := &models.Note{ID: 2, PromptID: 3, NodeID: &nodeID}
+	savedNote := &models.Note{ID: 3, SavedTree: "baseline"}
+
+	tests := []struct {
+		name   string
+		target NoteTarget
+		note   *models.Note
+		want   bool
+	}{
+		{"prompt note on its prompt", NoteTarget{PromptID: 3}, promptNote, true},
+		{"prompt note on another prompt", NoteTarget{PromptID: 4}, promptNote, false},
+		{"node note through its prompt", NoteTarget{PromptID: 3}, nodeNote, false},
+		{"node note on its node", NoteTarget{PromptID: 3, NodeID: 9}, nodeNote, true},
+		{"node note on another node", NoteTarget{PromptID: 3, NodeID: 10}, nodeNote, false},
+		{"node note under another prompt", NoteTarget{PromptID: 4, NodeID: 9}, nodeNote, false},
+		{"prompt note through a node", NoteTarget{PromptID: 3, NodeID: 9}, promptNote, false},
+		{"saved tree note on its tree", NoteTarget{SavedTree: "baseline"}, savedNote, true},
+		{"saved tree note on another tree", NoteTarget{SavedTree: "other"}, savedNote, false},
+		{"saved tree note through a prompt", NoteTarget{}, savedNote, false},
+		{"prompt note through a saved tree", NoteTarget{SavedTree: "baseline"}, promptNote, false},
+	}
+	for _, tt := range tests {
+		if got := tt.target.has(tt.note); got != tt.want {
+			t.Errorf("%s: has = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	long := strings.Repeat("a", maxMentionLength)
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"none", "no one here", []string{}},
+		{"start of text", "@ada please look", []string{"ada"}},
+		{"after punctuation", "(@ada) and \"@grace\"", []string{"ada", "grace"}},
+		{"email address", "mail ada@example.com", []string{}},
+		{"after a dot", "see x.@ada", []string{}},
+		{"double at", "@@ada", []string{}},
+		{"trailing dot", "thanks @ada.", []string{"ada"}},
+		{"trailing dashes and dots", "ask @ada.-.- now", []string{"ada"}},
+		{"inner dots, dashes and underscores", "@ada.l-ove_lace", []string{"ada.l-ove_lace"}},
+		{"case folded", "@Ada and @ADA", []string{"ada"}},
+		{"sorted and de-duplicated", "@grace @ada @grace", []string{"ada", "grace"}},
+		{"must start with a letter or digit", "@_ada @-ada @.ada @9lives", []string{"9lives"}},
+		{"stops at other characters", "@ada's @grace!", []string{"ada", "grace"}},
+		{"longest name", "@" + long, []string{long}},
+		{"too long", "@" + long + "b", []string{}},
+		{"longest name ending a sentence", "@" + long + ".", []string{long}},
+		{"lone at", "@ and @.", []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: mentions = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
 )
 
 // EventType represents the type of data change event
@@ -15,12 +17,22 @@ const (
 	EventTypePromptChanged EventType = "prompt_changed"
 	EventTypeNodeChanged   EventType = "node_changed"
 	EventTypeNoteChanged   EventType = "note_changed"
+	// Command runs report when they start and finish, and their output in
+	// chunks of whole lines in between
+	EventTypeCommandRunChanged EventType = "command_run_changed"
+	EventTypeCommandOutput     EventType = "command_output"
 )
 
 // Event represents a notification event
 type Event struct {
 	Type      EventType `json:"type"`
 	PromptID  *int      `json:"prompt_id,omitempty"`
+	NodeID    *int      `json:"node_id,omitempty"`
+	RunID     *int      `json:"run_id,omitempty"`
+	SavedTree string    `json:"saved_tree,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Stream    string    `json:"stream,omitempty"`
+	Output    *string   `json:"output,omitempty"`
 	Message   string    `json:"message"`
 	Timestamp int64     `json:"timestamp"`
 }
@@ -73,10 +85,16 @@ func (n *Notifier) UnregisterClient(clientID string) {
 	}
 }
 
-// Broadcast sends an event to all connected clients
+// Broadcast sends an event to all connected clients. A nil Notifier drops
+// events, which lets transaction-scoped services stay silent until commit.
 func (n *Notifier) Broadcast(event Event) {
-	n.mu.RLock()
-	defer n.mu.RUnlock()
+	if n == nil {
+		return
+	}
+
+	// Write lock, as a slow client is marked inactive below
+	n.mu.Lock()
+	defer n.mu.Unlock()
 
 	// Send event to all active clients
 	for _, client := range n.clients {
@@ -85,9 +103,14 @@ func (n *Notifier) Broadcast(event Event) {
 			case client.Send <- event:
 				// Event sent successfully
 			default:
-				// Channel is full, client might be slow
-				// Mark as inactive but don't remove yet
+				// Channel is full, client might be slow. Command output
+				// can be skipped, as the run's log keeps all of it; any
+				// other event it would miss, so it is told to reconnect.
+				if event.Type == EventTypeCommandOutput {
+					continue
+				}
 				client.IsActive = false
+				close(client.Done)
 			}
 		}
 	}
@@ -125,12 +148,54 @@ func (n *Notifier) BroadcastNodeChanged(promptID int) {
 	n.Broadcast(event)
 }
 
-// BroadcastNoteChanged notifies all clients that a note changed
-func (n *Notifier) BroadcastNoteChanged(promptID int) {
+// BroadcastNoteChanged notifies all clients that a note on a prompt, node or
+// saved tree changed
+func (n *Notifier) BroadcastNoteChanged(note *models.Note) {
 	event := Event{
 		Type:      EventTypeNoteChanged,
+		Timestamp: time.Now().Unix(),
+	}
+	switch {
+	case note.NodeID != nil:
+		event.PromptID = &note.PromptID
+		event.NodeID = note.NodeID
+		event.Message = fmt.Sprintf("Notes for node %d have been updated", *note.NodeID)
+	case note.SavedTree != "":
+		event.SavedTree = note.SavedTree
+		event.Message = fmt.Sprintf("Notes for saved tree %q have been updated", note.SavedTree)
+	default:
+		event.PromptID = &note.PromptID
+		event.Message = fmt.Sprintf("Notes for prompt %d have been updated", note.PromptID)
+	}
+	n.Broadcast(event)
+}
+
+// BroadcastCommandRunChanged notifies all clients that a command run started
+// or finished
+func (n *Notifier) BroadcastCommandRunChanged(promptID int, run *models.CommandRun) {
+	event := Event{
+		Type:      EventTypeCommandRunChanged,
+		PromptID:  &promptID,
+		NodeID:    &run.NodeID,
+		RunID:     &run.ID,
+		Status:    run.Status,
+		Message:   fmt.Sprintf("Command run %d for node %d is %s", run.ID, run.NodeID, run.Status),
+		Timestamp: time.Now().Unix(),
+	}
+	n.Broadcast(event)
+}
+
+// BroadcastCommandOutput sends one or more whole lines a running command
+// printed to one stream
+func (n *Notifier) BroadcastCommandOutput(promptID int, run *models.CommandRun, stream, output string) {
+	event := Event{
+		Type:      EventTypeCommandOutput,
 		PromptID:  &promptID,
-		Message:   fmt.Sprintf("Notes for prompt %d have been updated", promptID),
+		NodeID:    &run.NodeID,
+		RunID:     &run.ID,
+		Stream:    stream,
+		Output:    &output,
+		Message:   fmt.Sprintf("Output from command run %d", run.ID),
 		Timestamp: time.Now().Unix(),
 	}
 	n.Broadcast(event)
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroadcastSlowClient(t *testing.T) {
+	n := NewNotifier()
+	client := n.RegisterClient("slow")
+	for len(client.Send) < cap(client.Send) {
+		n.Broadcast(Event{Type: EventTypeNodeChanged})
+	}
+
+	// Output it has no room for is skipped
+	n.Broadcast(Event{Type: EventTypeCommandOutput})
+	if !client.IsActive {
+		t.Fatal("client deactivated by command output")
+	}
+
+	// Other events it would miss, so it is dropped to reconnect
+	<-client.Send
+	n.Broadcast(Event{Type: EventTypeTreeChanged})
+	n.Broadcast(Event{Type: EventTypeTreeChanged})
+	if client.IsActive {
+		t.Fatal("client still active after missing an event")
+	}
+	select {
+	case <-client.Done:
+	default:
+		t.Fatal("client not told to reconnect")
+	}
+
+	// A second full buffer does not close Done twice
+	n.Broadcast(Event{Type: EventTypeTreeChanged})
+	n.UnregisterClient("slow")
+}
+
+type chunk struct{ stream, output string }
+
+func TestOutputChunks(t *testing.T) {
+	var sent []chunk
+	c := &outputChunks{send: func(stream, output string) {
+		sent = append(sent, chunk{stream, output})
+	}}
+
+	c.add("stdout", "one")
+	c.add("stdout", "two")
+	c.add("stderr", "oops")
+	c.add("stdout", "three")
+	c.flush()
+	c.flush()
+
+	want := []chunk{
+		{"stdout", "one\ntwo\n"},
+		{"stderr", "oops\n"},
+		{"stdout", "three\n"},
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %q, want %q", sent, want)
+	}
+	for i := range want {
+		if sent[i] != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, sent[i], want[i])
+		}
+	}
+}
+
+func TestOutputChunksBySize(t *testing.T) {
+	var sent []chunk
+	c := &outputChunks{send: func(stream, output string) {
+		sent = append(sent, chunk{stream, output})
+	}}
+
+	line := strings.Repeat("x", 1023)
+	for range 20 {
+		c.add("stdout", line)
+	}
+	c.flush()
+
+	// 1 KiB lines fill an 8 KiB chunk every 8
+	if len(sent) != 3 {
+		t.Fatalf("sent %d chunks, want 3", len(sent))
+	}
+	for i, size := range []int{8, 8, 4} {
+		if got := len(sent[i].output); got != size<<10 {
+			t.Errorf("chunk %d has %d bytes, want %d", i, got, size<<10)
+		}
+	}
+}
+
+func TestOutputChunksByTime(t *testing.T) {
+	sent := make(chan chunk, 1)
+	c := &outputChunks{send: func(stream, output string) {
+		sent <- chunk{stream, output}
+	}}
+
+	c.add("stdout", "waiting")
+	select {
+	case got := <-sent:
+		if got != (chunk{"stdout", "waiting\n"}) {
+			t.Errorf("sent %q", got)
+		}
+	case <-time.After(10 * outputChunkInterval):
+		t.Fatal("a lone line was never sent")
+	}
+}
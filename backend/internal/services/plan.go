@@ -0,0 +1,333 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrDependencyNotFound = errs.New(errs.NotFound, "dependency_not_found", "dependency not found")
+	ErrDependencyCycle    = errs.New(errs.Conflict, "dependency_cycle", "dependency would create a cycle")
+)
+
+// dependencyPath returns a chain of dependencies leading from one node to
+// another, both included, or nil if to cannot be reached from from
+func dependencyPath(deps map[int][]int, from, to int) []int {
+	visited := make(map[int]bool)
+	var walk func(id int) []int
+	walk = func(id int) []int {
+		if id == to {
+			return []int{id}
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+		for _, next := range deps[id] {
+			if path := walk(next); path != nil {
+				return append([]int{id}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// findCycle returns a dependency cycle among ids, with its first node
+// repeated at the end, or nil if there is none
+func findCycle(deps map[int][]int, ids []int) []int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[int]int)
+	var stack []int
+
+	var walk func(id int) []int
+	walk = func(id int) []int {
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, dep := range deps[id] {
+			switch state[dep] {
+			case visiting:
+				start := slices.Index(stack, dep)
+				return append(slices.Clone(stack[start:]), dep)
+			case unvisited:
+				if cycle := walk(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = visited
+		return nil
+	}
+
+	for _, id := range ids {
+		if state[id] == unvisited {
+			if cycle := walk(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// formatPath renders a chain of node IDs as "3 -> 5 -> 8"
+func formatPath(path []int) string {
+	parts := make([]string, len(path))
+	for i, id := range path {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, " -> ")
+}
+
+// validateDependencies checks that every dependency in a tree document names
+// exactly one node of that document and that there are no cycles. Each
+// node's dependencies are sorted and de-duplicated in place.
+func validateDependencies(tree *models.TreeResponse) error {
+	count := make(map[int]int)
+	var ids []int
+	for _, p := range tree.Prompts {
+		for _, n := range p.Nodes {
+			count[n.ID]++
+			ids = append(ids, n.ID)
+		}
+	}
+
+	deps := make(map[int][]int)
+	for i := range tree.Prompts {
+		for j := range tree.Prompts[i].Nodes {
+			node := &tree.Prompts[i].Nodes[j]
+			if len(node.DependsOn) == 0 {
+				continue
+			}
+			if node.ID == 0 || count[node.ID] > 1 {
+				return ErrInvalidInput.Withf("node %q has dependencies, so it needs an id no other node uses", node.Name)
+			}
+			for _, dep := range node.DependsOn {
+				if dep == 0 || count[dep] != 1 {
+					return ErrInvalidInput.Withf("node %q depends on %d, which does not name exactly one node in the tree", node.Name, dep)
+				}
+			}
+
+			slices.Sort(node.DependsOn)
+			node.DependsOn = slices.Compact(node.DependsOn)
+			deps[node.ID] = node.DependsOn
+		}
+	}
+
+	if cycle := findCycle(deps, ids); cycle != nil {
+		return ErrInvalidInput.Withf("dependencies form a cycle: %s", formatPath(cycle))
+	}
+	return nil
+}
+
+// nodeDependencies picks the edges touching one node out of the full map
+func nodeDependencies(deps map[int][]int, nodeID int) *models.NodeDependencies {
+	result := &models.NodeDependencies{
+		NodeID:     nodeID,
+		DependsOn:  append([]int{}, deps[nodeID]...),
+		Dependents: []int{},
+	}
+	for id, dependsOn := range deps {
+		if slices.Contains(dependsOn, nodeID) {
+			result.Dependents = append(result.Dependents, id)
+		}
+	}
+	slices.Sort(result.Dependents)
+	return result
+}
+
+func (s *PromptService) GetNodeDependencies(nodeID int) (*models.NodeDependencies, error) {
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	deps, err := s.repo.ListDependencies()
+	if err != nil {
+		return nil, err
+	}
+	return nodeDependencies(deps, nodeID), nil
+}
+
+// AddNodeDependency makes nodeID wait on dependsOnID. It is rejected if
+// dependsOnID already depends on nodeID, directly or not.
+func (s *PromptService) AddNodeDependency(nodeID, dependsOnID int) (*models.NodeDependencies, error) {
+	var node *models.Node
+	err := s.inTx(func(tx *PromptService) error {
+		// Holding the tree lock serializes dependency writes, so two
+		// requests cannot each add half of a cycle
+		if _, err := tx.repo.LockTreeVersion(); err != nil {
+			return err
+		}
+
+		var err error
+		node, err = tx.repo.GetNodeByID(nodeID)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return ErrNodeNotFound
+		}
+
+		dependsOn, err := tx.repo.GetNodeByID(dependsOnID)
+		if err != nil {
+			return err
+		}
+		if dependsOn == nil {
+			return ErrNodeNotFound.Withf("node %d not found", dependsOnID)
+		}
+
+		deps, err := tx.repo.ListDependencies()
+		if err != nil {
+			return err
+		}
+		if path := dependencyPath(deps, dependsOnID, nodeID); path != nil {
+			cycle := append([]int{nodeID}, path...)
+			return ErrDependencyCycle.Withf("node %d cannot depend on node %d: %s", nodeID, dependsOnID, formatPath(cycle))
+		}
+
+		return tx.repo.AddNodeDependency(nodeID, dependsOnID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return s.GetNodeDependencies(nodeID)
+}
+
+func (s *PromptService) RemoveNodeDependency(nodeID, dependsOnID int) (*models.NodeDependencies, error) {
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	removed, err := s.repo.RemoveNodeDependency(nodeID, dependsOnID)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrDependencyNotFound
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return s.GetNodeDependencies(nodeID)
+}
+
+// finished reports whether a status no longer holds up dependent nodes
+func finished(status string) bool {
+	return status == models.NodeStatusDone || status == models.NodeStatusSkipped
+}
+
+// planSteps lists every node of tree after the nodes it depends on, ties
+// kept in tree order
+func planSteps(tree *models.TreeResponse) []models.PlanStep {
+	steps := []models.PlanStep{}
+	status := make(map[int]string)
+	for _, p := range tree.Prompts {
+		for _, n := range p.Nodes {
+			steps = append(steps, models.PlanStep{
+				ID:          n.ID,
+				PromptID:    p.ID,
+				PromptTitle: p.Title,
+				Name:        n.Name,
+				Status:      n.Status,
+				DependsOn:   append([]int{}, n.DependsOn...),
+			})
+			status[n.ID] = n.Status
+		}
+	}
+
+	deps := make(map[int][]int, len(steps))
+	for _, step := range steps {
+		deps[step.ID] = step.DependsOn
+	}
+
+	level := make(map[int]int, len(steps))
+	var levelOf func(id int) int
+	levelOf = func(id int) int {
+		if l, ok := level[id]; ok {
+			return l
+		}
+		// Writes reject cycles; the placeholder only guarantees this ends
+		level[id] = 0
+		l := 0
+		for _, dep := range deps[id] {
+			l = max(l, levelOf(dep)+1)
+		}
+		level[id] = l
+		return l
+	}
+
+	for i := range steps {
+		step := &steps[i]
+		step.Level = levelOf(step.ID)
+		step.Ready = step.Status == models.NodeStatusTodo
+		for _, dep := range step.DependsOn {
+			if !finished(status[dep]) {
+				step.Ready = false
+			}
+		}
+	}
+	slices.SortStableFunc(steps, func(a, b models.PlanStep) int {
+		return a.Level - b.Level
+	})
+	return steps
+}
+
+// GetPlan lists every node after the nodes it depends on and finds the
+// critical path: the longest chain of unfinished nodes, each waiting on the
+// one before it. Finished dependencies no longer constrain anything.
+func (s *PromptService) GetPlan() (*models.PlanResponse, error) {
+	tree, err := s.GetTree()
+	if err != nil {
+		return nil, err
+	}
+	steps := planSteps(tree)
+	return &models.PlanResponse{Steps: steps, CriticalPath: criticalPath(steps)}, nil
+}
+
+// criticalPath returns the longest chain of unfinished steps, first step
+// first. Steps must be in dependency order, as planSteps returns them.
+func criticalPath(steps []models.PlanStep) []int {
+	// Every dependency's chain is known by the time a step is reached
+	chain := make(map[int]int)
+	prev := make(map[int]int)
+	end := 0
+	for _, step := range steps {
+		if finished(step.Status) {
+			continue
+		}
+		chain[step.ID] = 1
+		for _, dep := range step.DependsOn {
+			if c, ok := chain[dep]; ok && c+1 > chain[step.ID] {
+				chain[step.ID] = c + 1
+				prev[step.ID] = dep
+			}
+		}
+		if end == 0 || chain[step.ID] > chain[end] {
+			end = step.ID
+		}
+	}
+
+	path := []int{}
+	for id := end; id != 0; id = prev[id] {
+		path = append(path, id)
+	}
+	slices.Reverse(path)
+	return path
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrPlanRunNotFound     = errs.New(errs.NotFound, "plan_run_not_found", "plan run not found")
+	ErrPlanRunStepNotFound = errs.New(errs.NotFound, "plan_run_step_not_found", "step is not part of the run")
+	ErrPlanRunFinished     = errs.New(errs.Conflict, "plan_run_finished", "plan run is already completed or abandoned")
+)
+
+// slowestSteps is how many steps a run report lists as the slowest
+const slowestSteps = 5
+
+// fillPlanRun attaches a run's steps, working out which are ready, how long
+// each took and the run's progress
+func fillPlanRun(run *models.PlanRun, steps []models.PlanRunStep) {
+	status := make(map[int]string, len(steps))
+	for _, step := range steps {
+		status[step.NodeID] = step.Status
+	}
+
+	var p progress
+	for i := range steps {
+		step := &steps[i]
+		step.Ready = step.Status == models.NodeStatusTodo
+		for _, dep := range step.DependsOn {
+			// Dependencies outside the snapshot cannot hold anything up
+			if s, ok := status[dep]; ok && !finished(s) {
+				step.Ready = false
+			}
+		}
+		if step.StartedAt != nil && step.FinishedAt != nil {
+			ms := int(step.FinishedAt.Sub(*step.StartedAt).Milliseconds())
+			step.DurationMS = &ms
+		}
+		p = p.count(step.Status)
+	}
+	run.Progress = p.finish()
+	run.Steps = steps
+}
+
+// advanceSteps picks the steps advancing a run moves: the first in progress,
+// which finishes with status, and the first step ready once it has. Either
+// is -1 when there is none.
+func advanceSteps(steps []models.PlanRunStep, status string) (current, next int) {
+	current = slices.IndexFunc(steps, func(step models.PlanRunStep) bool {
+		return step.Status == models.NodeStatusInProgress
+	})
+
+	// Readiness depends on the step just finished
+	after := slices.Clone(steps)
+	if current >= 0 {
+		after[current].Status = status
+	}
+	fillPlanRun(&models.PlanRun{}, after)
+	next = slices.IndexFunc(after, func(step models.PlanRunStep) bool { return step.Ready })
+	return current, next
+}
+
+// planRun returns a run with its steps
+func (s *PromptService) planRun(run *models.PlanRun) (*models.PlanRun, error) {
+	steps, err := s.repo.ListPlanRunSteps(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	if steps == nil {
+		steps = []models.PlanRunStep{}
+	}
+	fillPlanRun(run, steps)
+	return run, nil
+}
+
+// activePlanRun locks a run that can still change
+func (s *PromptService) activePlanRun(id int) (*models.PlanRun, error) {
+	run, err := s.repo.LockPlanRun(id)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, ErrPlanRunNotFound
+	}
+	if run.Status != models.PlanRunActive {
+		return nil, ErrPlanRunFinished.Withf("plan run %d is already %s", id, run.Status)
+	}
+	return run, nil
+}
+
+// StartPlanRun begins a run of a saved tree. Every node of the saved tree
+// becomes a todo step, in dependency order; the live tree is not touched.
+func (s *PromptService) StartPlanRun(req models.StartPlanRunRequest) (*models.PlanRun, error) {
+	saved, err := s.repo.GetSavedTree(req.SavedTree)
+	if err != nil {
+		return nil, err
+	}
+	if saved == nil {
+		return nil, ErrSavedTreeNotFound
+	}
+
+	var tree models.TreeResponse
+	if err := json.Unmarshal([]byte(saved.TreeData), &tree); err != nil {
+		return nil, ErrSavedTreeInvalid.Withf("saved tree %q cannot be read: %v", saved.Name, err)
+	}
+
+	var run *models.PlanRun
+	err = s.inTx(func(tx *PromptService) error {
+		run, err = tx.repo.CreatePlanRun(saved.ID, saved.Name, saved.UpdatedAt, req.Label, req.Notes)
+		if err != nil {
+			return err
+		}
+		for i, step := range planSteps(&tree) {
+			err := tx.repo.CreatePlanRunStep(run.ID, i, models.PlanRunStep{
+				NodeID:      step.ID,
+				PromptID:    step.PromptID,
+				PromptTitle: step.PromptTitle,
+				Name:        step.Name,
+				DependsOn:   step.DependsOn,
+				Status:      models.NodeStatusTodo,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.planRun(run)
+}
+
+func (s *PromptService) GetPlanRun(id int) (*models.PlanRun, error) {
+	run, err := s.repo.GetPlanRun(id)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, ErrPlanRunNotFound
+	}
+	return s.planRun(run)
+}
+
+// ListPlanRuns returns runs, newest first, with their progress but not
+// their steps, keeping only those of savedTree and with status when set
+func (s *PromptService) ListPlanRuns(savedTree, status string) ([]models.PlanRun, error) {
+	runs, err := s.repo.ListPlanRuns(savedTree, status)
+	if err != nil {
+		return nil, err
+	}
+	for i := range runs {
+		if _, err := s.planRun(&runs[i]); err != nil {
+			return nil, err
+		}
+		runs[i].Steps = nil
+	}
+	if runs == nil {
+		runs = []models.PlanRun{}
+	}
+	return runs, nil
+}
+
+// UpdatePlanRunStep sets one step's status and notes in an active run and
+// returns the run
+func (s *PromptService) UpdatePlanRunStep(runID, nodeID int, req models.UpdatePlanRunStepRequest) (*models.PlanRun, error) {
+	status, err := validateStatus(req.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	var run *models.PlanRun
+	err = s.inTx(func(tx *PromptService) error {
+		if run, err = tx.activePlanRun(runID); err != nil {
+			return err
+		}
+		step, err := tx.repo.UpdatePlanRunStep(runID, nodeID, status, req.Notes)
+		if err != nil {
+			return err
+		}
+		if step == nil {
+			return ErrPlanRunStepNotFound.Withf("node %d is not a step of plan run %d", nodeID, runID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.planRun(run)
+}
+
+// AdvancePlanRun moves a run along its plan: the first step in progress, if
+// any, becomes done (or the given status) and the first ready step goes in
+// progress. It returns the run.
+func (s *PromptService) AdvancePlanRun(runID int, req models.AdvancePlanRunRequest) (*models.PlanRun, error) {
+	status := req.Status
+	if status == "" {
+		status = models.NodeStatusDone
+	}
+	if !slices.Contains([]string{models.NodeStatusDone, models.NodeStatusSkipped, models.NodeStatusBlocked}, status) {
+		return nil, ErrInvalidInput.Withf("status %q must be one of done, skipped, blocked", status)
+	}
+
+	var run *models.PlanRun
+	err := s.inTx(func(tx *PromptService) error {
+		var err error
+		if run, err = tx.activePlanRun(runID); err != nil {
+			return err
+		}
+		if run, err = tx.planRun(run); err != nil {
+			return err
+		}
+
+		current, next := advanceSteps(run.Steps, status)
+		if current >= 0 {
+			var notes *string
+			if req.Notes != "" {
+				notes = &req.Notes
+			}
+			if _, err := tx.repo.UpdatePlanRunStep(runID, run.Steps[current].NodeID, status, notes); err != nil {
+				return err
+			}
+		} else if req.Notes != "" {
+			return ErrInvalidInput.Withf("no step is in progress to take the notes")
+		}
+		if next >= 0 {
+			if _, err := tx.repo.UpdatePlanRunStep(runID, run.Steps[next].NodeID, models.NodeStatusInProgress, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.planRun(run)
+}
+
+// CompletePlanRun closes an active run as completed or abandoned. Steps keep
+// the status they had.
+func (s *PromptService) CompletePlanRun(runID int, req models.CompletePlanRunRequest) (*models.PlanRun, error) {
+	outcome := req.Outcome
+	if outcome == "" {
+		outcome = models.PlanRunCompleted
+	}
+	if outcome != models.PlanRunCompleted && outcome != models.PlanRunAbandoned {
+		return nil, ErrInvalidInput.Withf("outcome %q must be completed or abandoned", outcome)
+	}
+
+	var run *models.PlanRun
+	err := s.inTx(func(tx *PromptService) error {
+		if _, err := tx.activePlanRun(runID); err != nil {
+			return err
+		}
+		var err error
+		run, err = tx.repo.FinishPlanRun(runID, outcome, req.Notes)
+		if err != nil {
+			return err
+		}
+		if run == nil {
+			return ErrPlanRunNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.planRun(run)
+}
+
+// PlanRunReport sums up a run: how long it and each prompt took, the
+// slowest steps, what is blocked and the notes left along the way
+func (s *PromptService) PlanRunReport(runID int) (*models.PlanRunReport, error) {
+	run, err := s.GetPlanRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	return planRunReport(run, time.Now()), nil
+}
+
+// planRunReport sums up a run with its steps filled in, timing an active
+// run until now
+func planRunReport(run *models.PlanRun, now time.Time) *models.PlanRunReport {
+	steps := run.Steps
+	run.Steps = nil
+
+	end := now
+	if run.CompletedAt != nil {
+		end = *run.CompletedAt
+	}
+	report := &models.PlanRunReport{
+		Run:        *run,
+		DurationMS: max(0, int(end.Sub(run.StartedAt).Milliseconds())),
+		Prompts:    []models.PlanRunPromptReport{},
+		Slowest:    []models.PlanRunStep{},
+		Blocked:    []models.PlanRunStep{},
+		Notes:      []models.PlanRunStep{},
+	}
+
+	prompts := make(map[int]int)
+	counts := make(map[int]progress)
+	for _, step := range steps {
+		i, ok := prompts[step.PromptID]
+		if !ok {
+			i = len(report.Prompts)
+			prompts[step.PromptID] = i
+			report.Prompts = append(report.Prompts, models.PlanRunPromptReport{PromptID: step.PromptID, PromptTitle: step.PromptTitle})
+		}
+		counts[step.PromptID] = counts[step.PromptID].count(step.Status)
+
+		switch {
+		case step.DurationMS != nil:
+			report.StepTimeMS += *step.DurationMS
+			report.Prompts[i].DurationMS += *step.DurationMS
+			report.Slowest = append(report.Slowest, step)
+		case step.FinishedAt != nil:
+			report.Untimed++
+		}
+		if step.Status == models.NodeStatusBlocked {
+			report.Blocked = append(report.Blocked, step)
+		}
+		if step.Notes != "" {
+			report.Notes = append(report.Notes, step)
+		}
+	}
+	for i := range report.Prompts {
+		report.Prompts[i].Progress = *counts[report.Prompts[i].PromptID].finish()
+	}
+
+	slices.SortStableFunc(report.Slowest, func(a, b models.PlanRunStep) int {
+		return *b.DurationMS - *a.DurationMS
+	})
+	if len(report.Slowest) > slowestSteps {
+		report.Slowest = report.Slowest[:slowestSteps]
+	}
+	return report
+}
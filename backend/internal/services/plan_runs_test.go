@@ -0,0 +1,188 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func runStep(nodeID, promptID int, status string, dependsOn ...int) models.PlanRunStep {
+	return models.PlanRunStep{NodeID: nodeID, PromptID: promptID, PromptTitle: "p", Status: status, DependsOn: dependsOn}
+}
+
+// timed sets when a step started and finished, in minutes after start
+func timed(step models.PlanRunStep, start time.Time, from, to int) models.PlanRunStep {
+	if from >= 0 {
+		started := start.Add(time.Duration(from) * time.Minute)
+		step.StartedAt = &started
+	}
+	if to >= 0 {
+		finished := start.Add(time.Duration(to) * time.Minute)
+		step.FinishedAt = &finished
+	}
+	return step
+}
+
+func TestFillPlanRun(t *testing.T) {
+	start := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	steps := []models.PlanRunStep{
+		timed(runStep(1, 1, "done"), start, 0, 30),
+		runStep(2, 1, "skipped"),
+		runStep(3, 1, "todo", 1, 2),  // both finished
+		runStep(4, 1, "todo", 3),     // 3 is not
+		runStep(5, 2, "todo", 99),    // 99 is not in the snapshot
+		runStep(6, 2, "todo", 99, 4), // but 4 still holds it up
+		runStep(7, 2, "blocked"),
+		timed(runStep(8, 2, "in_progress"), start, 10, -1),
+	}
+
+	run := &models.PlanRun{}
+	fillPlanRun(run, steps)
+
+	ready := []int{}
+	for _, step := range run.Steps {
+		if step.Ready {
+			ready = append(ready, step.NodeID)
+		}
+	}
+	if !slices.Equal(ready, []int{3, 5}) {
+		t.Errorf("ready = %v, want [3 5]", ready)
+	}
+
+	if d := run.Steps[0].DurationMS; d == nil || *d != 30*60*1000 {
+		t.Errorf("duration of step 1 = %v, want 30 minutes", d)
+	}
+	if d := run.Steps[7].DurationMS; d != nil {
+		t.Errorf("unfinished step has duration %d", *d)
+	}
+
+	want := models.Progress{Total: 8, Todo: 4, InProgress: 1, Done: 1, Skipped: 1, Blocked: 1, Percent: 25}
+	if *run.Progress != want {
+		t.Errorf("progress = %+v, want %+v", *run.Progress, want)
+	}
+}
+
+func TestAdvanceSteps(t *testing.T) {
+	tests := []struct {
+		name          string
+		steps         []models.PlanRunStep
+		status        string
+		current, next int
+	}{
+		{"first step", []models.PlanRunStep{
+			runStep(1, 1, "todo"), runStep(2, 1, "todo", 1),
+		}, "done", -1, 0},
+		{"finishing unblocks the next", []models.PlanRunStep{
+			runStep(1, 1, "in_progress"), runStep(2, 1, "todo", 1),
+		}, "done", 0, 1},
+		{"skipping unblocks too", []models.PlanRunStep{
+			runStep(1, 1, "in_progress"), runStep(2, 1, "todo", 1),
+		}, "skipped", 0, 1},
+		{"blocking does not", []models.PlanRunStep{
+			runStep(1, 1, "in_progress"), runStep(2, 1, "todo", 1), runStep(3, 1, "todo"),
+		}, "blocked", 0, 2},
+		{"first in progress finishes", []models.PlanRunStep{
+			runStep(1, 1, "done"), runStep(2, 1, "in_progress"), runStep(3, 1, "in_progress"), runStep(4, 1, "todo", 2),
+		}, "done", 1, 3},
+		{"next in plan order", []models.PlanRunStep{
+			runStep(1, 1, "in_progress"), runStep(2, 1, "todo", 5), runStep(3, 2, "todo"), runStep(4, 2, "todo"),
+			runStep(5, 2, "todo"),
+		}, "done", 0, 2},
+		{"nothing left", []models.PlanRunStep{
+			runStep(1, 1, "done"), runStep(2, 1, "in_progress"),
+		}, "done", 1, -1},
+		{"only blocked steps left", []models.PlanRunStep{
+			runStep(1, 1, "blocked"), runStep(2, 1, "todo", 1),
+		}, "done", -1, -1},
+	}
+	for _, tt := range tests {
+		before := slices.Clone(tt.steps)
+		current, next := advanceSteps(tt.steps, tt.status)
+		if current != tt.current || next != tt.next {
+			t.Errorf("%s: advance = %d, %d, want %d, %d", tt.name, current, next, tt.current, tt.next)
+		}
+		for i := range before {
+			if tt.steps[i].Status != before[i].Status || tt.steps[i].Ready != before[i].Ready {
+				t.Errorf("%s: step %d changed", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestPlanRunReport(t *testing.T) {
+	start := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	steps := []models.PlanRunStep{
+		timed(runStep(1, 1, "done"), start, 0, 10),
+		timed(runStep(2, 1, "done"), start, 10, 70),
+		timed(runStep(3, 2, "done"), start, 5, 25),
+		timed(runStep(4, 2, "skipped"), start, -1, 30), // never in progress
+		timed(runStep(5, 2, "done"), start, 30, 50),    // ties 3, stays after it
+		timed(runStep(6, 3, "done"), start, 50, 51),
+		timed(runStep(7, 3, "done"), start, 51, 53),
+		timed(runStep(8, 3, "in_progress"), start, 53, -1),
+		runStep(9, 3, "blocked"),
+	}
+	steps[0].Notes = "fast"
+	steps[8].Notes = "waiting on access"
+
+	run := &models.PlanRun{ID: 1, Status: models.PlanRunActive, StartedAt: start}
+	fillPlanRun(run, steps)
+	report := planRunReport(run, start.Add(2*time.Hour))
+
+	if report.Run.Steps != nil {
+		t.Error("report run keeps its steps")
+	}
+	if report.DurationMS != 120*60*1000 {
+		t.Errorf("duration = %d, want 2 hours", report.DurationMS)
+	}
+	if report.StepTimeMS != (10+60+20+20+1+2)*60*1000 {
+		t.Errorf("step time = %d", report.StepTimeMS)
+	}
+	if report.Untimed != 1 {
+		t.Errorf("untimed = %d, want 1", report.Untimed)
+	}
+
+	ids := func(steps []models.PlanRunStep) []int {
+		var ids []int
+		for _, step := range steps {
+			ids = append(ids, step.NodeID)
+		}
+		return ids
+	}
+	if got := ids(report.Slowest); !slices.Equal(got, []int{2, 3, 5, 1, 7}) {
+		t.Errorf("slowest = %v, want [2 3 5 1 7]", got)
+	}
+	if got := ids(report.Blocked); !slices.Equal(got, []int{9}) {
+		t.Errorf("blocked = %v, want [9]", got)
+	}
+	if got := ids(report.Notes); !slices.Equal(got, []int{1, 9}) {
+		t.Errorf("notes = %v, want [1 9]", got)
+	}
+
+	type prompt struct{ id, durationMS, total, done int }
+	var prompts []prompt
+	for _, p := range report.Prompts {
+		prompts = append(prompts, prompt{p.PromptID, p.DurationMS, p.Progress.Total, p.Progress.Done})
+	}
+	want := []prompt{{1, 70 * 60 * 1000, 2, 2}, {2, 40 * 60 * 1000, 3, 2}, {3, 3 * 60 * 1000, 4, 2}}
+	if !slices.Equal(prompts, want) {
+		t.Errorf("prompts = %v, want %v", prompts, want)
+	}
+}
+
+func TestPlanRunReportCompleted(t *testing.T) {
+	start := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+	completed := start.Add(45 * time.Minute)
+	run := &models.PlanRun{Status: models.PlanRunCompleted, StartedAt: start, CompletedAt: &completed}
+	fillPlanRun(run, []models.PlanRunStep{})
+
+	report := planRunReport(run, start.Add(24*time.Hour))
+	if report.DurationMS != 45*60*1000 {
+		t.Errorf("duration = %d, want 45 minutes", report.DurationMS)
+	}
+	if report.Prompts == nil || report.Slowest == nil || report.Blocked == nil || report.Notes == nil {
+		t.Error("empty report has null lists")
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func planNode(id int, status string, dependsOn ...int) models.NodeSummary {
+	return models.NodeSummary{ID: id, Name: fmt.Sprint("node ", id), Status: status, DependsOn: dependsOn}
+}
+
+// planTree puts each list of nodes in its own prompt, numbered from 1
+func planTree(prompts ...[]models.NodeSummary) *models.TreeResponse {
+	tree := &models.TreeResponse{}
+	for i, nodes := range prompts {
+		tree.Prompts = append(tree.Prompts, models.PromptNode{ID: i + 1, Title: "p", Nodes: nodes})
+	}
+	return tree
+}
+
+func TestDependencyPath(t *testing.T) {
+	deps := map[int][]int{1: {2}, 2: {3}, 4: {1, 5}, 5: {5}}
+
+	tests := []struct {
+		name     string
+		from, to int
+		want     []int
+	}{
+		{"direct", 1, 2, []int{1, 2}},
+		{"indirect", 4, 3, []int{4, 1, 2, 3}},
+		{"against the edges", 3, 1, nil},
+		{"itself", 1, 1, []int{1}},
+		{"through a loop", 5, 1, nil},
+		{"unknown node", 9, 1, nil},
+	}
+	for _, tt := range tests {
+		if got := dependencyPath(deps, tt.from, tt.to); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: path = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindCycle(t *testing.T) {
+	tests := []struct {
+		name string
+		deps map[int][]int
+		ids  []int
+		want []int
+	}{
+		{"none", map[int][]int{1: {2}, 2: {3}, 4: {2}}, []int{1, 2, 3, 4}, nil},
+		{"self-dependency", map[int][]int{1: {2}, 2: {2}}, []int{1, 2}, []int{2, 2}},
+		{"indirect", map[int][]int{1: {2}, 2: {3}, 3: {1}}, []int{1, 2, 3}, []int{1, 2, 3, 1}},
+		{"reached from outside", map[int][]int{4: {1}, 1: {2}, 2: {1}}, []int{4, 1, 2}, []int{1, 2, 1}},
+		{"diamond", map[int][]int{1: {2, 3}, 2: {4}, 3: {4}}, []int{1, 2, 3, 4}, nil},
+	}
+	for _, tt := range tests {
+		if got := findCycle(tt.deps, tt.ids); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: cycle = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	tests := []struct {
+		name string
+		tree *models.TreeResponse
+		// want is part of the error message, or empty for none
+		want string
+	}{
+		{"across prompts", planTree(
+			[]models.NodeSummary{planNode(1, "todo")},
+			[]models.NodeSummary{planNode(2, "todo", 1)},
+		), ""},
+		{"no dependencies without ids", planTree(
+			[]models.NodeSummary{planNode(0, "todo"), planNode(0, "todo")},
+		), ""},
+		{"self-dependency", planTree(
+			[]models.NodeSummary{planNode(1, "todo", 1)},
+		), "cycle: 1 -> 1"},
+		{"indirect cycle", planTree(
+			[]models.NodeSummary{planNode(1, "todo", 3), planNode(2, "todo", 1)},
+			[]models.NodeSummary{planNode(3, "todo", 2)},
+		), "cycle: 1 -> 3 -> 2 -> 1"},
+		{"unknown id", planTree(
+			[]models.NodeSummary{planNode(1, "todo", 7)},
+		), "depends on 7"},
+		{"zero id", planTree(
+			[]models.NodeSummary{planNode(1, "todo", 0)},
+		), "depends on 0"},
+		{"duplicate dependency target", planTree(
+			[]models.NodeSummary{planNode(1, "todo"), planNode(2, "todo", 1)},
+			[]models.NodeSummary{planNode(1, "todo")},
+		), "depends on 1"},
+		{"duplicate id with dependencies", planTree(
+			[]models.NodeSummary{planNode(1, "todo"), planNode(2, "todo", 1)},
+			[]models.NodeSummary{planNode(2, "todo")},
+		), "needs an id"},
+		{"missing id with dependencies", planTree(
+			[]models.NodeSummary{planNode(1, "todo"), planNode(0, "todo", 1)},
+		), "needs an id"},
+	}
+	for _, tt := range tests {
+		err := validateDependencies(tt.tree)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.want == "":
+		case !errors.Is(err, ErrInvalidInput):
+			t.Errorf("%s: err = %v, want invalid input", tt.name, err)
+		case !strings.Contains(err.Error(), tt.want):
+			t.Errorf("%s: err = %q, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidateDependenciesSortsAndCompacts(t *testing.T) {
+	tree := planTree([]models.NodeSummary{
+		planNode(1, "todo"), planNode(2, "todo"), planNode(3, "todo", 2, 1, 2),
+	})
+	if err := validateDependencies(tree); err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.Prompts[0].Nodes[2].DependsOn; !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("depends_on = %v, want [1 2]", got)
+	}
+}
+
+func TestPlanSteps(t *testing.T) {
+	// 5 depends on 4 in a later prompt, which depends on 1; 2 and 3 have
+	// no dependencies, so they share level 0 with 1 in tree order
+	tree := planTree(
+		[]models.NodeSummary{planNode(5, "todo", 4), planNode(1, "done"), planNode(2, "in_progress")},
+		[]models.NodeSummary{planNode(4, "todo", 1), planNode(3, "todo"), planNode(6, "todo", 2, 1), planNode(7, "todo", 8)},
+		[]models.NodeSummary{planNode(8, "skipped"), planNode(9, "blocked", 1)},
+	)
+
+	type want struct {
+		id, prompt, level int
+		ready             bool
+	}
+	wants := []want{
+		{1, 1, 0, false}, // done
+		{2, 1, 0, false}, // in progress
+		{3, 2, 0, true},
+		{8, 3, 0, false}, // skipped
+		{4, 2, 1, true},  // its dependency is done
+		{6, 2, 1, false}, // 2 is still in progress
+		{7, 2, 1, true},  // a skipped dependency counts as finished
+		{9, 3, 1, false}, // blocked, not todo
+		{5, 1, 2, false}, // 4 is not done
+	}
+
+	steps := planSteps(tree)
+	if len(steps) != len(wants) {
+		t.Fatalf("got %d steps, want %d", len(steps), len(wants))
+	}
+	for i, w := range wants {
+		got := want{steps[i].ID, steps[i].PromptID, steps[i].Level, steps[i].Ready}
+		if got != w {
+			t.Errorf("step %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []models.NodeSummary
+		want  []int
+	}{
+		{"empty", nil, []int{}},
+		{"all finished", []models.NodeSummary{planNode(1, "done"), planNode(2, "skipped", 1)}, []int{}},
+		{"longest chain", []models.NodeSummary{
+			planNode(1, "todo"), planNode(2, "todo", 1), planNode(3, "todo", 2),
+			planNode(4, "todo"), planNode(5, "todo", 4),
+		}, []int{1, 2, 3}},
+		{"skips finished nodes", []models.NodeSummary{
+			// 1 -> 2 -> 3 -> 4 is longer, but 1 and 2 are done
+			planNode(1, "done"), planNode(2, "done", 1), planNode(3, "todo", 2), planNode(4, "todo", 3),
+			planNode(5, "todo"), planNode(6, "in_progress", 5), planNode(7, "todo", 6),
+		}, []int{5, 6, 7}},
+		{"ties go to the first in plan order", []models.NodeSummary{
+			planNode(1, "todo"), planNode(2, "todo", 1), planNode(3, "todo"), planNode(4, "todo", 3),
+		}, []int{1, 2}},
+		{"longest dependency wins", []models.NodeSummary{
+			planNode(1, "todo"), planNode(2, "todo"), planNode(3, "todo", 2), planNode(4, "todo", 1, 3),
+		}, []int{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		got := criticalPath(planSteps(planTree(tt.nodes)))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: critical path = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
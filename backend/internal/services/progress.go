@@ -0,0 +1,87 @@
+package services
+
+import (
+	"math"
+	"slices"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// validateStatus checks a node status, treating "" as todo
+func validateStatus(status string) (string, error) {
+	if status == "" {
+		return models.NodeStatusTodo, nil
+	}
+	if !slices.Contains(models.NodeStatuses, status) {
+		return "", ErrInvalidInput.Withf("status %q must be one of todo, in_progress, done, skipped, blocked", status)
+	}
+	return status, nil
+}
+
+// progress accumulates a models.Progress one node at a time
+type progress models.Progress
+
+// count adds one node with the given status to p
+func (p progress) count(status string) progress {
+	p.Total++
+	switch status {
+	case models.NodeStatusInProgress:
+		p.InProgress++
+	case models.NodeStatusDone:
+		p.Done++
+	case models.NodeStatusSkipped:
+		p.Skipped++
+	case models.NodeStatusBlocked:
+		p.Blocked++
+	default:
+		p.Todo++
+	}
+	return p
+}
+
+// finish fills in the completion percentage, rounded to one decimal
+func (p progress) finish() *models.Progress {
+	if p.Total > 0 {
+		p.Percent = math.Round(float64(p.Done+p.Skipped)/float64(p.Total)*1000) / 10
+	}
+	result := models.Progress(p)
+	return &result
+}
+
+// rollupProgress sets the progress of every prompt and of the tree from the
+// statuses of the nodes it contains
+func rollupProgress(tree *models.TreeResponse) {
+	var total progress
+	for i := range tree.Prompts {
+		var prompt progress
+		for _, n := range tree.Prompts[i].Nodes {
+			prompt = prompt.count(n.Status)
+			total = total.count(n.Status)
+		}
+		tree.Prompts[i].Progress = prompt.finish()
+	}
+	tree.Progress = total.finish()
+}
+
+// GetTreeProgress summarizes node statuses per prompt and for the project,
+// over the nodes matching the given tags
+func (s *PromptService) GetTreeProgress(tags []string) (*models.TreeProgressResponse, error) {
+	tree, err := s.GetTreeWithTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &models.TreeProgressResponse{
+		Project:  tree.Project,
+		Progress: *tree.Progress,
+		Prompts:  make([]models.PromptProgress, 0, len(tree.Prompts)),
+	}
+	for _, p := range tree.Prompts {
+		summary.Prompts = append(summary.Prompts, models.PromptProgress{
+			ID:       p.ID,
+			Title:    p.Title,
+			Progress: *p.Progress,
+		})
+	}
+	return summary, nil
+}
@@ -1,31 +1,101 @@
 package services
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 
+	"github.com/pranavturlapati28/merget-takehome/internal/llm"
 	"github.com/pranavturlapati28/merget-takehome/internal/models"
 	"github.com/pranavturlapati28/merget-takehome/internal/repository"
+	"github.com/pranavturlapati28/merget-takehome/internal/runner"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
 )
 
 var (
-	ErrPromptNotFound = errors.New("prompt not found")
-	ErrNodeNotFound   = errors.New("node not found")
-	ErrNoteNotFound   = errors.New("note not found")
+	ErrPromptNotFound    = errs.New(errs.NotFound, "prompt_not_found", "prompt not found")
+	ErrNodeNotFound      = errs.New(errs.NotFound, "node_not_found", "node not found")
+	ErrNoteNotFound      = errs.New(errs.NotFound, "note_not_found", "note not found")
+	ErrSavedTreeNotFound = errs.New(errs.NotFound, "saved_tree_not_found", "saved tree not found")
+	ErrSavedTreeInvalid  = errs.New(errs.Invalid, "saved_tree_invalid", "saved tree cannot be read")
+	ErrVersionConflict   = errs.New(errs.Precondition, "version_conflict", "resource was modified by another request")
+	ErrInvalidInput      = errs.New(errs.Validation, "invalid_input", "invalid input")
+	ErrPatchTestFailed   = errs.New(errs.Conflict, "patch_test_failed", "patch test operation failed")
 )
 
 type PromptService struct {
-	repo *repository.PromptRepository
+	repo     *repository.PromptRepository
+	notifier *Notifier
+	// llm is nil when no provider is configured
+	llm llm.Provider
+	// tokenizer estimates token counts for trees, checked against
+	// tokenBudget unless it is 0
+	tokenizer   llm.Tokenizer
+	tokenBudget int
+	// runner is nil when command execution is disabled
+	runner *runner.Runner
 }
 
-func NewPromptService(repo *repository.PromptRepository) *PromptService {
-	return &PromptService{repo: repo}
+func NewPromptService(repo *repository.PromptRepository, notifier *Notifier, provider llm.Provider, tokenizer llm.Tokenizer, tokenBudget int, commands *runner.Runner) *PromptService {
+	return &PromptService{repo: repo, notifier: notifier, llm: provider, tokenizer: tokenizer, tokenBudget: tokenBudget, runner: commands}
+}
+
+// inTx runs fn with a service bound to one database transaction. The bound
+// service has no notifier, so callers broadcast once fn has committed.
+func (s *PromptService) inTx(fn func(tx *PromptService) error) error {
+	return s.repo.WithTx(func(repo *repository.PromptRepository) error {
+		return fn(&PromptService{repo: repo, llm: s.llm, tokenizer: s.tokenizer, tokenBudget: s.tokenBudget, runner: s.runner})
+	})
+}
+
+// ListPrompts returns every prompt without its nodes or notes, keeping only
+// prompts that carry all of the given tags
+func (s *PromptService) ListPrompts(tags []string) ([]models.Prompt, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	prompts, err := s.repo.GetPromptsByTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if prompts == nil {
+		prompts = []models.Prompt{}
+	}
+	return prompts, nil
 }
 
 func (s *PromptService) GetTree() (*models.TreeResponse, error) {
-	prompts, err := s.repo.GetAllPrompts()
+	return s.getTree(nil)
+}
+
+// getTree builds the tree from the prompts and nodes matching tags, as
+// GetTreeWithTags describes
+func (s *PromptService) getTree(tags []string) (*models.TreeResponse, error) {
+	prompts, err := s.repo.GetTreePromptsByTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, err := s.repo.ListDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+
+	modelDefaults, err := s.repo.GetModelDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	activeVariants, err := s.repo.ActiveVariants()
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +103,7 @@ func (s *PromptService) GetTree() (*models.TreeResponse, error) {
 	var promptNodes []models.PromptNode
 
 	for _, p := range prompts {
-		nodes, err := s.repo.GetNodesByPromptID(p.ID)
+		nodes, err := s.repo.GetNodesByTags(p.ID, tags)
 		if err != nil {
 			return nil, err
 		}
@@ -44,6 +114,11 @@ func (s *PromptService) GetTree() (*models.TreeResponse, error) {
 				ID:     n.ID,
 				Name:   n.Name,
 				Action: n.Action,
+				Status:    n.Status,
+				Kind:      n.Kind,
+				Tags:      n.Tags,
+				DependsOn: deps[n.ID],
+				Variant:   activeVariants[n.ID],
 			})
 		}
 
@@ -52,10 +127,13 @@ func (s *PromptService) GetTree() (*models.TreeResponse, error) {
 		}
 
 		promptNodes = append(promptNodes, models.PromptNode{
-			ID:          p.ID,
-			Title:       p.Title,
-			Description: p.Description,
-			Nodes:       nodeSummaries,
+			ID:             p.ID,
+			Title:          p.Title,
+			Description:    p.Description,
+			Tags:           p.Tags,
+			Model:          p.Model,
+			EffectiveModel: effectiveModel(modelDefaults, p.Model),
+			Nodes:          nodeSummaries,
 		})
 	}
 
@@ -72,11 +150,16 @@ func (s *PromptService) GetTree() (*models.TreeResponse, error) {
 		log.Printf("Retrieved project settings: %s - %s\n", projectName, mainRequest)
 	}
 
-	return &models.TreeResponse{
+	tree := &models.TreeResponse{
 		Project:     projectName,
 		MainRequest: mainRequest,
+		Model:       modelDefaults,
+		Variables:   variables,
 		Prompts:     promptNodes,
-	}, nil
+	}
+	rollupProgress(tree)
+	s.countTokens(tree)
+	return tree, nil
 }
 
 func (s *PromptService) GetPrompt(id int) (*models.PromptDetail, error) {
@@ -88,31 +171,105 @@ func (s *PromptService) GetPrompt(id int) (*models.PromptDetail, error) {
 		return nil, ErrPromptNotFound
 	}
 
+	modelDefaults, err := s.repo.GetModelDefaults()
+	if err != nil {
+		return nil, err
+	}
+
 	return &models.PromptDetail{
-		ID:          prompt.ID,
-		Title:       prompt.Title,
-		Description: prompt.Description,
-		ProjectName: prompt.ProjectName,
+		ID:             prompt.ID,
+		Title:          prompt.Title,
+		Description:    prompt.Description,
+		ProjectName:    prompt.ProjectName,
+		Version:        prompt.Version,
+		Tags:           prompt.Tags,
+		Model:          prompt.Model,
+		EffectiveModel: effectiveModel(modelDefaults, prompt.Model),
 	}, nil
 }
 
+// GetTreeVersion returns the current version of the tree as a whole. Read it
+// before the tree itself so a concurrent write can only make it look stale.
+func (s *PromptService) GetTreeVersion() (int, error) {
+	return s.repo.GetTreeVersion()
+}
+
 func (s *PromptService) CreatePrompt(title, description string) (*models.Prompt, error) {
-	return s.repo.CreatePrompt(title, description)
+	var prompt *models.Prompt
+	err := s.inTx(func(tx *PromptService) error {
+		if err := tx.checkText(description); err != nil {
+			return err
+		}
+
+		var err error
+		prompt, err = tx.repo.CreatePrompt(title, description)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return prompt, nil
 }
 
-func (s *PromptService) UpdatePrompt(id int, title, description string) (*models.Prompt, error) {
-	exists, err := s.repo.PromptExists(id)
+// UpdatePrompt updates a prompt. A non-nil ifVersion makes the write
+// conditional on the prompt still being at that version.
+func (s *PromptService) UpdatePrompt(id int, title, description string, ifVersion *int) (*models.Prompt, error) {
+	prompt, err := s.writePrompt(id, nonEmpty(title), nonEmpty(description), ifVersion)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, ErrPromptNotFound
+
+	s.notifier.BroadcastPromptChanged(id)
+	return prompt, nil
+}
+
+// PatchPrompt applies a JSON Merge Patch: absent fields are left alone and an
+// explicit null clears the field. The title is required and cannot be cleared.
+func (s *PromptService) PatchPrompt(id int, patch models.PatchPromptRequest, ifVersion *int) (*models.Prompt, error) {
+	if patch.Title.Set && patch.Title.Value == "" {
+		return nil, ErrInvalidInput.Withf("title cannot be null or empty")
+	}
+
+	prompt, err := s.writePrompt(id, patch.Title.Ptr(), patch.Description.Ptr(), ifVersion)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.repo.UpdatePrompt(id, title, description)
+	s.notifier.BroadcastPromptChanged(id)
+	return prompt, nil
+}
+
+// writePrompt updates a prompt's title and description, leaving nil fields
+// unchanged, with the description checked in the same transaction
+func (s *PromptService) writePrompt(id int, title, description *string, ifVersion *int) (*models.Prompt, error) {
+	var prompt *models.Prompt
+	err := s.inTx(func(tx *PromptService) error {
+		if description != nil {
+			if err := tx.checkText(*description); err != nil {
+				return err
+			}
+		}
+
+		exists, err := tx.repo.PromptExists(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPromptNotFound
+		}
+
+		prompt, err = tx.repo.UpdatePrompt(id, title, description, ifVersion)
+		return versionError(err, ErrPromptNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prompt, nil
 }
 
-func (s *PromptService) DeletePrompt(id int) error {
+func (s *PromptService) DeletePrompt(id int, ifVersion *int) error {
 	exists, err := s.repo.PromptExists(id)
 	if err != nil {
 		return err
@@ -121,29 +278,39 @@ func (s *PromptService) DeletePrompt(id int) error {
 		return ErrPromptNotFound
 	}
 
-	return s.repo.DeletePrompt(id)
+	if err := s.repo.DeletePrompt(id, ifVersion); err != nil {
+		return versionError(err, ErrPromptNotFound)
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return nil
 }
 
 // =============================================================================
 // NODE OPERATIONS
 // =============================================================================
 
-// GetPromptNodes retrieves all nodes for a prompt
-func (s *PromptService) GetPromptNodes(promptID int) ([]models.Node, error) {
+// GetPromptNodes retrieves the nodes for a prompt that, together with the
+// prompt's own tags, carry all of the given tags
+func (s *PromptService) GetPromptNodes(promptID int, tags []string) ([]models.Node, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
 	// First check if the prompt exists
-	exists, err := s.repo.PromptExists(promptID)
+	prompt, err := s.repo.GetPromptByID(promptID)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
+	if prompt == nil {
 		return nil, ErrPromptNotFound
 	}
 
-	nodes, err := s.repo.GetNodesByPromptID(promptID)
+	nodes, err := s.repo.GetNodesByTags(promptID, tags)
 	if err != nil {
 		return nil, err
 	}
-
 	if nodes == nil {
 		nodes = []models.Node{}
 	}
@@ -151,31 +318,116 @@ func (s *PromptService) GetPromptNodes(promptID int) ([]models.Node, error) {
 	return nodes, nil
 }
 
-func (s *PromptService) CreateNode(promptID int, name, action string) (*models.Node, error) {
-	exists, err := s.repo.PromptExists(promptID)
+func (s *PromptService) CreateNode(promptID int, name, action, kind string) (*models.Node, error) {
+	kind, err := validateKind(kind)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, ErrPromptNotFound
+
+	var node *models.Node
+	err = s.inTx(func(tx *PromptService) error {
+		if err := tx.checkText(action); err != nil {
+			return err
+		}
+
+		exists, err := tx.repo.PromptExists(promptID)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrPromptNotFound
+		}
+
+		node, err = tx.repo.CreateNode(promptID, name, action, kind)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return s.repo.CreateNode(promptID, name, action)
+	s.notifier.BroadcastNodeChanged(promptID)
+	return node, nil
 }
 
-func (s *PromptService) UpdateNode(nodeID int, name, action string) (*models.Node, error) {
-	node, err := s.repo.GetNodeByID(nodeID)
+func (s *PromptService) UpdateNode(nodeID int, name, action, status, kind string, ifVersion *int) (*models.Node, error) {
+	if status != "" {
+		if _, err := validateStatus(status); err != nil {
+			return nil, err
+		}
+	}
+	if kind != "" {
+		if _, err := validateKind(kind); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := s.writeNode(nodeID, nonEmpty(name), nonEmpty(action), nonEmpty(status), nonEmpty(kind), ifVersion)
 	if err != nil {
 		return nil, err
 	}
-	if node == nil {
-		return nil, ErrNodeNotFound
+
+	s.notifier.BroadcastNodeChanged(updated.PromptID)
+	return updated, nil
+}
+
+// PatchNode applies a JSON Merge Patch to a node. The name, status and kind
+// are required and cannot be cleared; a null action clears it.
+func (s *PromptService) PatchNode(nodeID int, patch models.PatchNodeRequest, ifVersion *int) (*models.Node, error) {
+	if patch.Name.Set && patch.Name.Value == "" {
+		return nil, ErrInvalidInput.Withf("name cannot be null or empty")
+	}
+	if patch.Status.Set {
+		if patch.Status.Value == "" {
+			return nil, ErrInvalidInput.Withf("status cannot be null or empty")
+		}
+		if _, err := validateStatus(patch.Status.Value); err != nil {
+			return nil, err
+		}
+	}
+	if patch.Kind.Set {
+		if patch.Kind.Value == "" {
+			return nil, ErrInvalidInput.Withf("kind cannot be null or empty")
+		}
+		if _, err := validateKind(patch.Kind.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	updated, err := s.writeNode(nodeID, patch.Name.Ptr(), patch.Action.Ptr(), patch.Status.Ptr(), patch.Kind.Ptr(), ifVersion)
+	if err != nil {
+		return nil, err
 	}
 
-	return s.repo.UpdateNode(nodeID, name, action)
+	s.notifier.BroadcastNodeChanged(updated.PromptID)
+	return updated, nil
 }
 
-func (s *PromptService) DeleteNode(nodeID int) error {
+// writeNode updates a node, leaving nil fields unchanged, with the action
+// checked in the same transaction
+func (s *PromptService) writeNode(nodeID int, name, action, status, kind *string, ifVersion *int) (*models.Node, error) {
+	var updated *models.Node
+	err := s.inTx(func(tx *PromptService) error {
+		if action != nil {
+			if err := tx.checkText(*action); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.requireNode(nodeID); err != nil {
+			return err
+		}
+
+		var err error
+		updated, err = tx.repo.UpdateNode(nodeID, name, action, status, kind, ifVersion)
+		return versionError(err, ErrNodeNotFound)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+func (s *PromptService) DeleteNode(nodeID int, ifVersion *int) error {
 	node, err := s.repo.GetNodeByID(nodeID)
 	if err != nil {
 		return err
@@ -184,7 +436,12 @@ func (s *PromptService) DeleteNode(nodeID int) error {
 		return ErrNodeNotFound
 	}
 
-	return s.repo.DeleteNode(nodeID)
+	if err := s.repo.DeleteNode(nodeID, ifVersion); err != nil {
+		return versionError(err, ErrNodeNotFound)
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return nil
 }
 
 func (s *PromptService) GetNotes(promptID int) ([]models.Note, error) {
@@ -201,15 +458,10 @@ func (s *PromptService) GetNotes(promptID int) ([]models.Note, error) {
 		return nil, err
 	}
 
-	// Ensure never nil
-	if notes == nil {
-		notes = []models.Note{}
-	}
-
-	return notes, nil
+	return threadNotes(notes), nil
 }
 
-func (s *PromptService) CreateNote(promptID int, content string) (*models.Note, error) {
+func (s *PromptService) CreateNote(promptID int, req models.CreateNoteRequest) (*models.Note, error) {
 	exists, err := s.repo.PromptExists(promptID)
 	if err != nil {
 		return nil, err
@@ -218,65 +470,181 @@ func (s *PromptService) CreateNote(promptID int, content string) (*models.Note,
 		return nil, ErrPromptNotFound
 	}
 
-	return s.repo.CreateNote(promptID, content)
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		return tx.repo.CreateNote(promptID, req.Author, req.Content)
+	})
 }
 
-func (s *PromptService) UpdateNote(noteID int, content string) (*models.Note, error) {
-	note, err := s.repo.GetNoteByID(noteID)
+func (s *PromptService) UpdateNote(target *NoteTarget, noteID int, content string, ifVersion *int) (*models.Note, error) {
+	if _, err := s.targetNote(target, noteID); err != nil {
+		return nil, err
+	}
+
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		updated, err := tx.repo.UpdateNote(noteID, content, ifVersion)
+		if err != nil {
+			return nil, versionError(err, ErrNoteNotFound)
+		}
+		return updated, nil
+	})
+}
+
+// PatchNote applies a JSON Merge Patch to a note. Content is the only field
+// and cannot be cleared, so an empty patch just checks the version.
+func (s *PromptService) PatchNote(target *NoteTarget, noteID int, patch models.PatchNoteRequest, ifVersion *int) (*models.Note, error) {
+	if patch.Content.Set && patch.Content.Value == "" {
+		return nil, ErrInvalidInput.Withf("content cannot be null or empty")
+	}
+
+	note, err := s.targetNote(target, noteID)
 	if err != nil {
 		return nil, err
 	}
-	if note == nil {
-		return nil, ErrNoteNotFound
+
+	if !patch.Content.Set {
+		if ifVersion != nil && note.Version != *ifVersion {
+			return nil, ErrVersionConflict
+		}
+		return note, nil
 	}
 
-	return s.repo.UpdateNote(noteID, content)
+	return s.writeNote(func(tx *PromptService) (*models.Note, error) {
+		updated, err := tx.repo.UpdateNote(noteID, patch.Content.Value, ifVersion)
+		if err != nil {
+			return nil, versionError(err, ErrNoteNotFound)
+		}
+		return updated, nil
+	})
 }
 
-func (s *PromptService) DeleteNote(noteID int) error {
-	note, err := s.repo.GetNoteByID(noteID)
+func (s *PromptService) DeleteNote(target *NoteTarget, noteID int, ifVersion *int) error {
+	note, err := s.targetNote(target, noteID)
 	if err != nil {
 		return err
 	}
-	if note == nil {
-		return ErrNoteNotFound
+
+	if err := s.repo.DeleteNote(noteID, ifVersion); err != nil {
+		return versionError(err, ErrNoteNotFound)
+	}
+
+	s.notifier.BroadcastNoteChanged(note)
+	return nil
+}
+
+// nonEmpty adapts PUT semantics, where an empty field means "leave as is",
+// to the repository's nil-means-unchanged convention.
+func nonEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// versionError maps repository write failures onto service errors: a stale
+// version becomes ErrVersionConflict and a row that vanished mid-request
+// becomes the given not-found error.
+func versionError(err error, notFound error) error {
+	switch {
+	case errors.Is(err, repository.ErrVersionMismatch):
+		return ErrVersionConflict
+	case errors.Is(err, sql.ErrNoRows):
+		return notFound
+	}
+	return err
+}
+
+// ImportTree replaces the current tree. A non-nil ifVersion makes the import
+// conditional on the tree still being at that version.
+func (s *PromptService) ImportTree(treeData *models.TreeResponse, ifVersion *int) error {
+	if err := validateTree(treeData); err != nil {
+		return err
+	}
+
+	log.Printf("Importing tree with project: %s, mainRequest: %s\n", treeData.Project, treeData.MainRequest)
+	err := s.repo.ImportTree(treeData, ifVersion)
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		log.Printf("Error importing tree: %v\n", err)
+		return err
 	}
+	log.Printf("Tree imported successfully\n")
 
-	return s.repo.DeleteNote(noteID)
+	s.notifier.BroadcastTreeChanged()
+	return nil
 }
 
-func (s *PromptService) ImportTree(treeData *models.TreeResponse) error {
+// validateTree enforces the rules every full tree must satisfy, whether it
+// arrives by import, by loading a save or as the result of a patch. Tags,
+// node statuses and dependencies are normalized in place, and placeholders
+// must name one of the tree's own variables.
+func validateTree(treeData *models.TreeResponse) error {
 	if treeData.Project == "" {
-		return errors.New("project name is required")
+		return ErrInvalidInput.Withf("project name is required")
 	}
 	if len(treeData.Prompts) == 0 {
-		return errors.New("at least one prompt is required")
+		return ErrInvalidInput.Withf("at least one prompt is required")
 	}
 
-	for _, prompt := range treeData.Prompts {
+	model, err := normalizeModelConfig(treeData.Model)
+	if err != nil {
+		return err
+	}
+	treeData.Model = model
+
+	for i := range treeData.Prompts {
+		prompt := &treeData.Prompts[i]
 		if prompt.Title == "" {
-			return errors.New("all prompts must have a title")
+			return ErrInvalidInput.Withf("all prompts must have a title")
+		}
+
+		tags, err := normalizeTags(prompt.Tags)
+		if err != nil {
+			return err
 		}
-		for _, node := range prompt.Nodes {
+		prompt.Tags = tags
+
+		model, err := normalizeModelConfig(prompt.Model)
+		if err != nil {
+			return ErrInvalidModelConfig.Withf("prompt %q: %v", prompt.Title, err)
+		}
+		prompt.Model = model
+
+		for j := range prompt.Nodes {
+			node := &prompt.Nodes[j]
 			if node.Name == "" {
-				return errors.New("all nodes must have a name")
+				return ErrInvalidInput.Withf("all nodes must have a name")
+			}
+
+			status, err := validateStatus(node.Status)
+			if err != nil {
+				return err
 			}
+			node.Status = status
+
+			kind, err := validateKind(node.Kind)
+			if err != nil {
+				return err
+			}
+			node.Kind = kind
+
+			tags, err := normalizeTags(node.Tags)
+			if err != nil {
+				return err
+			}
+			node.Tags = tags
 		}
 	}
-
-	log.Printf("Importing tree with project: %s, mainRequest: %s\n", treeData.Project, treeData.MainRequest)
-	err := s.repo.ImportTree(treeData)
-	if err != nil {
-		log.Printf("Error importing tree: %v\n", err)
+	if err := validateVariables(treeData); err != nil {
 		return err
 	}
-	log.Printf("Tree imported successfully\n")
-	return nil
+	return validateDependencies(treeData)
 }
 
 func (s *PromptService) SaveTree(name string) error {
 	if name == "" {
-		return errors.New("name is required")
+		return ErrInvalidInput.Withf("name is required")
 	}
 
 	// Get current tree
@@ -295,7 +663,7 @@ func (s *PromptService) SaveTree(name string) error {
 
 func (s *PromptService) LoadTree(name string) error {
 	if name == "" {
-		return errors.New("name is required")
+		return ErrInvalidInput.Withf("name is required")
 	}
 
 	// Get saved tree
@@ -304,16 +672,16 @@ func (s *PromptService) LoadTree(name string) error {
 		return err
 	}
 	if savedTree == nil {
-		return errors.New("saved tree not found")
+		return ErrSavedTreeNotFound
 	}
 
 	var treeData models.TreeResponse
 	err = json.Unmarshal([]byte(savedTree.TreeData), &treeData)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal tree data: %w", err)
+		return ErrSavedTreeInvalid.Withf("saved tree %q cannot be read: %v", name, err)
 	}
 
-	return s.ImportTree(&treeData)
+	return s.ImportTree(&treeData, nil)
 }
 
 func (s *PromptService) ListSavedTrees() ([]models.SavedTreeInfo, error) {
@@ -322,8 +690,12 @@ func (s *PromptService) ListSavedTrees() ([]models.SavedTreeInfo, error) {
 
 func (s *PromptService) DeleteSavedTree(name string) error {
 	if name == "" {
-		return errors.New("name is required")
+		return ErrInvalidInput.Withf("name is required")
 	}
 
-	return s.repo.DeleteSavedTree(name)
+	err := s.repo.DeleteSavedTree(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSavedTreeNotFound
+	}
+	return err
 }
\ No newline at end of file
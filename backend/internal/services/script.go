@@ -0,0 +1,266 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// validateKind returns the kind to store, defaulting to instruction
+func validateKind(kind string) (string, error) {
+	if kind == "" {
+		return models.NodeKindInstruction, nil
+	}
+	if !slices.Contains(models.NodeKinds, kind) {
+		return "", ErrInvalidInput.Withf("kind %q must be one of instruction, command, file", kind)
+	}
+	return kind, nil
+}
+
+// scriptStep is a node in plan order with the prompt it belongs to
+type scriptStep struct {
+	prompt *models.PromptNode
+	node   *models.NodeSummary
+}
+
+// scriptSteps renders the tree and returns its nodes in dependency order
+func (s *PromptService) scriptSteps(vars []string) (*models.TreeResponse, []scriptStep, error) {
+	tree, err := s.RenderTree(vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree, orderSteps(tree), nil
+}
+
+// orderSteps returns the tree's nodes in dependency order
+func orderSteps(tree *models.TreeResponse) []scriptStep {
+	prompts := make(map[int]*models.PromptNode, len(tree.Prompts))
+	nodes := make(map[int]*models.NodeSummary)
+	for i := range tree.Prompts {
+		prompt := &tree.Prompts[i]
+		prompts[prompt.ID] = prompt
+		for j := range prompt.Nodes {
+			nodes[prompt.Nodes[j].ID] = &prompt.Nodes[j]
+		}
+	}
+
+	var steps []scriptStep
+	for _, step := range planSteps(tree) {
+		steps = append(steps, scriptStep{prompt: prompts[step.PromptID], node: nodes[step.ID]})
+	}
+	return steps
+}
+
+// comment turns text into shell/make comment lines. Every line of the text
+// stays behind a #, and trailing backslashes are dropped: make would join
+// the next line onto the comment.
+func comment(b *strings.Builder, prefix, text string) {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimRight(line, " \t\r\\")
+		if line == "" {
+			b.WriteString(prefix + "#\n")
+			continue
+		}
+		b.WriteString(prefix + "# " + line + "\n")
+	}
+}
+
+// shellQuote quotes a word for POSIX sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// heredocDelimiter picks a delimiter that no line of the content matches
+func heredocDelimiter(content string) string {
+	delimiter := "EOF"
+	lines := strings.Split(content, "\n")
+	for n := 1; slices.Contains(lines, delimiter); n++ {
+		delimiter = fmt.Sprintf("EOF_%d", n)
+	}
+	return delimiter
+}
+
+// runnable returns the shell lines that carry out a command or file node
+func runnable(node *models.NodeSummary) []string {
+	switch node.Kind {
+	case models.NodeKindCommand:
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(node.Action), "\n") {
+			lines = append(lines, strings.TrimRight(line, " \t\r"))
+		}
+		return lines
+	case models.NodeKindFile:
+		path := strings.TrimSpace(node.Name)
+		content := strings.TrimRight(node.Action, "\n")
+		delimiter := heredocDelimiter(content)
+		lines := []string{fmt.Sprintf(`mkdir -p "$(dirname %s)"`, shellQuote(path))}
+		lines = append(lines, fmt.Sprintf("cat > %s <<'%s'", shellQuote(path), delimiter))
+		lines = append(lines, strings.Split(content, "\n")...)
+		return append(lines, delimiter)
+	}
+	return nil
+}
+
+// printfFile writes a file node with printf rather than a heredoc. Make
+// strips the indentation from recipe lines, which would change the
+// contents; quoting each line keeps it.
+func printfFile(node *models.NodeSummary) []string {
+	path := shellQuote(strings.TrimSpace(node.Name))
+	content := strings.TrimRight(node.Action, "\n")
+	lines := []string{fmt.Sprintf(`mkdir -p "$(dirname %s)"`, path)}
+	if content == "" {
+		return append(lines, ": > "+path)
+	}
+	lines = append(lines, `printf '%s\n' \`)
+	for _, line := range strings.Split(content, "\n") {
+		lines = append(lines, "  "+shellQuote(line)+" \\")
+	}
+	return append(lines, "  > "+path)
+}
+
+// isRunnable reports whether a node becomes commands rather than comments.
+// Skipped nodes never run.
+func isRunnable(node *models.NodeSummary) bool {
+	if node.Status == models.NodeStatusSkipped {
+		return false
+	}
+	switch node.Kind {
+	case models.NodeKindCommand:
+		return strings.TrimSpace(node.Action) != ""
+	case models.NodeKindFile:
+		return strings.TrimSpace(node.Name) != ""
+	}
+	return false
+}
+
+// scriptHeader comments the project and main request, then the plan note
+func scriptHeader(b *strings.Builder, tree *models.TreeResponse) {
+	comment(b, "", tree.Project)
+	if strings.TrimSpace(tree.MainRequest) != "" {
+		b.WriteString("#\n")
+		comment(b, "", tree.MainRequest)
+	}
+	b.WriteString("#\n# Generated from the prompt tree in dependency order. Command and file\n# nodes run; instruction and skipped nodes are comments.\n")
+}
+
+// stepComment labels a step, opening a section when the prompt changes
+func stepComment(b *strings.Builder, steps []scriptStep, i int) {
+	step := steps[i]
+	if i == 0 || steps[i-1].prompt.ID != step.prompt.ID {
+		b.WriteString("\n")
+		comment(b, "", "==== "+step.prompt.Title+" ====")
+	}
+	b.WriteString("\n")
+	label := fmt.Sprintf("Step %d of %d: %s", i+1, len(steps), step.node.Name)
+	if step.node.Status == models.NodeStatusSkipped {
+		label += " (skipped)"
+	}
+	comment(b, "", label)
+}
+
+// ExportShell renders the tree as a POSIX shell script. Command nodes become
+// their commands and file nodes write their contents to the path in their
+// name; instruction nodes, and skipped nodes of any kind, become comments.
+func (s *PromptService) ExportShell(vars []string) (string, error) {
+	tree, steps, err := s.scriptSteps(vars)
+	if err != nil {
+		return "", err
+	}
+	return shellScript(tree, steps), nil
+}
+
+func shellScript(tree *models.TreeResponse, steps []scriptStep) string {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	scriptHeader(&b, tree)
+	b.WriteString("set -eu\n")
+
+	for i, step := range steps {
+		stepComment(&b, steps, i)
+		if !isRunnable(step.node) {
+			comment(&b, "", step.node.Action)
+			continue
+		}
+		for _, line := range runnable(step.node) {
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// targetName turns a node name into a make target
+var targetUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+func targetName(node *models.NodeSummary, taken map[string]bool) string {
+	name := strings.Trim(targetUnsafe.ReplaceAllString(strings.ToLower(node.Name), "-"), "-")
+	if name == "" || name == "all" || taken[name] {
+		name = strings.TrimPrefix(fmt.Sprintf("%s-%d", name, node.ID), "-")
+		if name == fmt.Sprint(node.ID) {
+			name = fmt.Sprintf("step-%d", node.ID)
+		}
+	}
+	taken[name] = true
+	return name
+}
+
+// ExportMakefile renders the tree as a GNU Makefile with one target per
+// command or file node. Each target depends on the one before it in plan
+// order, so "make" runs everything and "make <target>" runs up to that step.
+// Instruction and skipped nodes become comments.
+func (s *PromptService) ExportMakefile(vars []string) (string, error) {
+	tree, steps, err := s.scriptSteps(vars)
+	if err != nil {
+		return "", err
+	}
+	return makefile(tree, steps), nil
+}
+
+func makefile(tree *models.TreeResponse, steps []scriptStep) string {
+	taken := make(map[string]bool)
+	targets := make(map[int]string)
+	var order []string
+	for _, step := range steps {
+		if isRunnable(step.node) {
+			targets[step.node.ID] = targetName(step.node, taken)
+			order = append(order, targets[step.node.ID])
+		}
+	}
+
+	var b strings.Builder
+	scriptHeader(&b, tree)
+	b.WriteString("\nSHELL := /bin/sh\n.SHELLFLAGS := -eu -c\n.ONESHELL:\n")
+	if len(order) > 0 {
+		b.WriteString(".PHONY: all " + strings.Join(order, " ") + "\n\nall: " + order[len(order)-1] + "\n")
+	} else {
+		b.WriteString(".PHONY: all\n\nall:\n")
+	}
+
+	previous := ""
+	for i, step := range steps {
+		stepComment(&b, steps, i)
+		target, ok := targets[step.node.ID]
+		if !ok {
+			comment(&b, "", step.node.Action)
+			continue
+		}
+
+		b.WriteString(target + ":")
+		if previous != "" {
+			b.WriteString(" " + previous)
+		}
+		b.WriteString("\n")
+		lines := runnable(step.node)
+		if step.node.Kind == models.NodeKindFile {
+			lines = printfFile(step.node)
+		}
+		for _, line := range lines {
+			// Make expands $ itself, so the shell's are doubled
+			b.WriteString("\t" + strings.ReplaceAll(line, "$", "$$") + "\n")
+		}
+		previous = target
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// hostileTree has text in every commented field that would run if it
+// escaped its comment
+func hostileTree() *models.TreeResponse {
+	return &models.TreeResponse{
+		Project:     "Racing\necho pwned-project",
+		MainRequest: "Build it \\",
+		Prompts: []models.PromptNode{{
+			ID:    1,
+			Title: "Setup\necho pwned-title",
+			Nodes: []models.NodeSummary{
+				{ID: 1, Name: "Read me\necho pwned-name", Action: "Plan first \\", Kind: models.NodeKindInstruction},
+				{ID: 2, Name: "Say ok", Action: "echo ok", Kind: models.NodeKindCommand, DependsOn: []int{1}},
+			},
+		}},
+	}
+}
+
+func TestExportsKeepTextInComments(t *testing.T) {
+	tree := hostileTree()
+	steps := orderSteps(tree)
+	dir := t.TempDir()
+
+	scripts := map[string]string{
+		"sh":   shellScript(tree, steps),
+		"make": makefile(tree, steps),
+	}
+	for format, script := range scripts {
+		for _, line := range strings.Split(script, "\n") {
+			if strings.HasPrefix(line, "echo pwned") {
+				t.Errorf("%s: text escaped its comment: %q", format, line)
+			}
+			if strings.HasPrefix(line, "#") && strings.HasSuffix(line, "\\") {
+				t.Errorf("%s: comment ends in a backslash: %q", format, line)
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "setup.sh")
+	if err := os.WriteFile(path, []byte(scripts["sh"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", path).CombinedOutput()
+	if err != nil || string(out) != "ok\n" {
+		t.Errorf("sh printed %q (%v), want only ok", out, err)
+	}
+
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not installed")
+	}
+	path = filepath.Join(dir, "Makefile")
+	if err := os.WriteFile(path, []byte(scripts["make"]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err = exec.Command("make", "-s", "-f", path).CombinedOutput()
+	if err != nil || string(out) != "ok\n" {
+		t.Errorf("make printed %q (%v), want only ok", out, err)
+	}
+}
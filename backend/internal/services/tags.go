@@ -0,0 +1,183 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var ErrTagNotFound = errs.New(errs.NotFound, "tag_not_found", "tag not found")
+
+// tagPattern is what a tag looks like after normalization
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// normalizeTag trims and lowercases a tag so "Frontend " and "frontend" are
+// the same tag.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !tagPattern.MatchString(tag) {
+		return "", ErrInvalidInput.Withf("tag %q must be 1-64 letters, digits, '-', '_' or '.'", tag)
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes, de-duplicates and sorts tags
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func (s *PromptService) ListTags() ([]models.TagInfo, error) {
+	tags, err := s.repo.ListTags()
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = []models.TagInfo{}
+	}
+	return tags, nil
+}
+
+// AddPromptTag tags a prompt and returns it. Adding a tag twice is a no-op.
+func (s *PromptService) AddPromptTag(promptID int, tag string) (*models.Prompt, error) {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := s.repo.PromptExists(promptID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPromptNotFound
+	}
+
+	if err := s.repo.AddPromptTag(promptID, tag); err != nil {
+		return nil, err
+	}
+	return s.taggedPrompt(promptID)
+}
+
+func (s *PromptService) RemovePromptTag(promptID int, tag string) (*models.Prompt, error) {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := s.repo.PromptExists(promptID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrPromptNotFound
+	}
+
+	removed, err := s.repo.RemovePromptTag(promptID, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrTagNotFound
+	}
+	return s.taggedPrompt(promptID)
+}
+
+func (s *PromptService) taggedPrompt(promptID int) (*models.Prompt, error) {
+	prompt, err := s.repo.GetPromptByID(promptID)
+	if err != nil {
+		return nil, err
+	}
+	if prompt == nil {
+		return nil, ErrPromptNotFound
+	}
+
+	s.notifier.BroadcastPromptChanged(promptID)
+	return prompt, nil
+}
+
+// AddNodeTag tags a node and returns it. Adding a tag twice is a no-op.
+func (s *PromptService) AddNodeTag(nodeID int, tag string) (*models.Node, error) {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	if err := s.repo.AddNodeTag(nodeID, tag); err != nil {
+		return nil, err
+	}
+	return s.taggedNode(nodeID)
+}
+
+func (s *PromptService) RemoveNodeTag(nodeID int, tag string) (*models.Node, error) {
+	tag, err := normalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	removed, err := s.repo.RemoveNodeTag(nodeID, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrTagNotFound
+	}
+	return s.taggedNode(nodeID)
+}
+
+func (s *PromptService) taggedNode(nodeID int) (*models.Node, error) {
+	node, err := s.repo.GetNodeByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return node, nil
+}
+
+// GetTreeWithTags returns the tree narrowed to the given tags. A node matches
+// when it and its prompt together carry every tag; a prompt is kept when it
+// matches itself or still has matching nodes. With no tags the full tree is
+// returned.
+func (s *PromptService) GetTreeWithTags(tags []string) (*models.TreeResponse, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getTree(tags)
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrTemplateNotFound = errs.New(errs.NotFound, "template_not_found", "template not found")
+	ErrTemplateReadOnly = errs.New(errs.Conflict, "template_read_only", "built-in templates cannot be changed")
+)
+
+// substitute replaces the placeholders naming one of values and leaves any
+// others in place
+func substitute(text string, values map[string]string) string {
+	return placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		if value, ok := values[placeholder.FindStringSubmatch(match)[1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// instantiateTree returns a copy of the template's tree with the parameter
+// values substituted into every piece of text
+func instantiateTree(t *models.Template, values map[string]string) *models.TreeResponse {
+	tree := &models.TreeResponse{
+		Project:     substitute(t.Tree.Project, values),
+		MainRequest: substitute(t.Tree.MainRequest, values),
+		Model:       instantiateModel(t.Tree.Model, values),
+		Prompts:     make([]models.PromptNode, 0, len(t.Tree.Prompts)),
+	}
+
+	for _, v := range t.Tree.Variables {
+		v.Default = substitute(v.Default, values)
+		v.Description = substitute(v.Description, values)
+		tree.Variables = append(tree.Variables, v)
+	}
+
+	for _, p := range t.Tree.Prompts {
+		prompt := models.PromptNode{
+			ID:          p.ID,
+			Title:       substitute(p.Title, values),
+			Description: substitute(p.Description, values),
+			Tags:        slices.Clone(p.Tags),
+			Model:       instantiateModel(p.Model, values),
+		}
+		for _, n := range p.Nodes {
+			prompt.Nodes = append(prompt.Nodes, models.NodeSummary{
+				ID:        n.ID,
+				Name:      substitute(n.Name, values),
+				Action:    substitute(n.Action, values),
+				Status:    n.Status,
+				Kind:      n.Kind,
+				Tags:      slices.Clone(n.Tags),
+				DependsOn: slices.Clone(n.DependsOn),
+			})
+		}
+		tree.Prompts = append(tree.Prompts, prompt)
+	}
+	return tree
+}
+
+// instantiateModel copies model settings, substituting into the system prompt
+func instantiateModel(cfg *models.ModelConfig, values map[string]string) *models.ModelConfig {
+	if cfg == nil {
+		return nil
+	}
+	out := *cfg
+	out.SystemPrompt = substitute(cfg.SystemPrompt, values)
+	return &out
+}
+
+// parameterValues lays the given values over the template's defaults. Every
+// value must name a parameter, and every parameter must end up non-empty.
+func parameterValues(t *models.Template, given map[string]string) (map[string]string, error) {
+	values := make(map[string]string, len(t.Parameters))
+	for _, p := range t.Parameters {
+		values[p.Name] = p.Default
+	}
+	for name, value := range given {
+		if _, ok := values[name]; !ok {
+			return nil, ErrInvalidInput.Withf("template %q has no parameter %q", t.Name, name)
+		}
+		values[name] = value
+	}
+	for _, p := range t.Parameters {
+		if values[p.Name] == "" {
+			return nil, ErrMissingVariable.Withf("parameter %q has no default and must be given", p.Name)
+		}
+	}
+	return values, nil
+}
+
+// validateTemplate checks the declared parameters and that the tree
+// instantiates into a valid tree. Required parameters stand in as their own
+// names for the check.
+func validateTemplate(t *models.Template) error {
+	if t.Name == "" {
+		return ErrInvalidInput.Withf("name is required")
+	}
+	fillTags(&t.Tree)
+
+	values := make(map[string]string, len(t.Parameters))
+	for _, p := range t.Parameters {
+		if err := validateVariableName(p.Name); err != nil {
+			return err
+		}
+		if _, ok := values[p.Name]; ok {
+			return ErrInvalidInput.Withf("parameter %q is declared twice", p.Name)
+		}
+		if slices.ContainsFunc(t.Tree.Variables, func(v models.Variable) bool { return v.Name == p.Name }) {
+			return ErrInvalidInput.Withf("parameter %q has the same name as a tree variable", p.Name)
+		}
+
+		values[p.Name] = p.Default
+		if p.Default == "" {
+			values[p.Name] = p.Name
+		}
+	}
+
+	return validateTree(instantiateTree(t, values))
+}
+
+func (s *PromptService) ListTemplates() ([]models.TemplateInfo, error) {
+	templates, err := s.repo.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = []models.TemplateInfo{}
+	}
+	return templates, nil
+}
+
+func (s *PromptService) GetTemplate(name string) (*models.Template, error) {
+	template, err := s.repo.GetTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, ErrTemplateNotFound
+	}
+	fillTags(&template.Tree)
+	return template, nil
+}
+
+// fillTags gives prompts and nodes without tags an empty list, so a stored
+// tree lists tags like one read from the database
+func fillTags(tree *models.TreeResponse) {
+	for i := range tree.Prompts {
+		prompt := &tree.Prompts[i]
+		if prompt.Tags == nil {
+			prompt.Tags = []string{}
+		}
+		for j := range prompt.Nodes {
+			if prompt.Nodes[j].Tags == nil {
+				prompt.Nodes[j].Tags = []string{}
+			}
+		}
+	}
+}
+
+// PutTemplate creates or replaces a user template
+func (s *PromptService) PutTemplate(name string, req models.PutTemplateRequest) (*models.Template, error) {
+	template := &models.Template{
+		Name:        name,
+		Description: req.Description,
+		Parameters:  req.Parameters,
+		Tree:        req.Tree,
+	}
+	if template.Parameters == nil {
+		template.Parameters = []models.Variable{}
+	}
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+
+	err := s.inTx(func(tx *PromptService) error {
+		existing, err := tx.repo.GetTemplate(name)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.Builtin {
+			return ErrTemplateReadOnly
+		}
+		return tx.repo.PutTemplate(template)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTemplate(name)
+}
+
+func (s *PromptService) DeleteTemplate(name string) error {
+	return s.inTx(func(tx *PromptService) error {
+		existing, err := tx.repo.GetTemplate(name)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrTemplateNotFound
+		}
+		if existing.Builtin {
+			return ErrTemplateReadOnly
+		}
+
+		_, err = tx.repo.DeleteTemplate(name)
+		return err
+	})
+}
+
+// InstantiateTemplate replaces the current tree with one built from a
+// template. A non-nil ifVersion makes it conditional like ImportTree.
+func (s *PromptService) InstantiateTemplate(name string, values map[string]string, ifVersion *int) error {
+	template, err := s.GetTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	values, err = parameterValues(template, values)
+	if err != nil {
+		return err
+	}
+	return s.ImportTree(instantiateTree(template, values), ifVersion)
+}
+
+// InstallTemplates stores templates as the built-ins, replacing earlier
+// copies so changes shipped with the server take effect and removing
+// built-ins that are no longer shipped
+func (s *PromptService) InstallTemplates(templates []models.Template) error {
+	var removed int64
+	err := s.inTx(func(tx *PromptService) error {
+		names := make([]string, 0, len(templates))
+		for _, template := range templates {
+			template.Builtin = true
+			if err := validateTemplate(&template); err != nil {
+				return err
+			}
+			if err := tx.repo.PutTemplate(&template); err != nil {
+				return err
+			}
+			names = append(names, template.Name)
+		}
+
+		var err error
+		removed, err = tx.repo.DeleteBuiltinTemplatesExcept(names)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		fmt.Printf("✓ Removed %d built-in templates no longer in the seeds\n", removed)
+	}
+	return nil
+}
+
+// SeedIfEmpty instantiates the named template with its defaults when there
+// are no prompts yet
+func (s *PromptService) SeedIfEmpty(name string) error {
+	prompts, err := s.repo.GetAllPrompts()
+	if err != nil {
+		return err
+	}
+	if len(prompts) > 0 {
+		fmt.Println("✓ Data already seeded (skipping)")
+		return nil
+	}
+
+	if err := s.InstantiateTemplate(name, nil, nil); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Database seeded from template %q\n", name)
+	return nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// usage wraps a count with the budget check. A budget of 0 means none.
+func usage(count, budget int) *models.TokenUsage {
+	return &models.TokenUsage{
+		Count:      count,
+		Budget:     budget,
+		OverBudget: budget > 0 && count > budget,
+	}
+}
+
+// countTokens estimates tokens for every node, every prompt (its title,
+// description and nodes) and the tree as a whole, and lists on the tree the
+// prompts, and the tree itself, that exceed the context budget
+func (s *PromptService) countTokens(tree *models.TreeResponse) {
+	if s.tokenizer == nil {
+		return
+	}
+
+	total := s.tokenizer.Count(tree.Project) + s.tokenizer.Count(tree.MainRequest)
+	var warnings []string
+	for i := range tree.Prompts {
+		prompt := &tree.Prompts[i]
+		count := s.tokenizer.Count(prompt.Title) + s.tokenizer.Count(prompt.Description)
+		for j := range prompt.Nodes {
+			node := &prompt.Nodes[j]
+			node.Tokens = s.tokenizer.Count(node.Name) + s.tokenizer.Count(node.Action)
+			count += node.Tokens
+		}
+
+		prompt.Tokens = usage(count, s.tokenBudget)
+		if prompt.Tokens.OverBudget {
+			warnings = append(warnings, fmt.Sprintf("prompt %d %q needs about %d tokens, over the budget of %d", prompt.ID, prompt.Title, count, s.tokenBudget))
+		}
+		total += count
+	}
+
+	tree.Tokens = usage(total, s.tokenBudget)
+	if tree.Tokens.OverBudget {
+		warnings = append(warnings, fmt.Sprintf("the whole tree needs about %d tokens, over the budget of %d", total, s.tokenBudget))
+	}
+	tree.Tokens.Warnings = warnings
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/jsonpatch"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// PatchTree applies an RFC 6902 patch to the tree as returned by GetTree and
+// writes the result back in one transaction. Unlike ImportTree it reconciles
+// by ID: prompts and nodes that keep their ID are updated in place (so their
+// notes survive), entries without a known ID are created, and entries the
+// patch removed are deleted. Order within a list is not persisted; prompts
+// and nodes always come back sorted by ID. It returns the tree and tree
+// version as the patch left them, before any later write.
+func (s *PromptService) PatchTree(ops []jsonpatch.Operation, ifVersion *int) (*models.TreeResponse, int, error) {
+	var tree *models.TreeResponse
+	var version int
+	err := s.inTx(func(tx *PromptService) error {
+		treeVersion, err := tx.repo.LockTreeVersion()
+		if err != nil {
+			return err
+		}
+		if ifVersion != nil && treeVersion != *ifVersion {
+			return ErrVersionConflict
+		}
+
+		current, err := tx.GetTree()
+		if err != nil {
+			return err
+		}
+
+		patched, err := applyTreePatch(current, ops)
+		if err != nil {
+			return err
+		}
+		if err := validateTree(patched); err != nil {
+			return err
+		}
+
+		if err := syncTree(tx.repo, current, patched); err != nil {
+			return err
+		}
+
+		if tree, err = tx.GetTree(); err != nil {
+			return err
+		}
+		version, err = tx.repo.GetTreeVersion()
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return tree, version, nil
+}
+
+// treeDocument renders the tree with every member present, so patches can
+// replace a description or append to a prompt's nodes even when they are
+// empty and would be omitted from the regular JSON.
+func treeDocument(tree *models.TreeResponse) map[string]any {
+	prompts := make([]any, 0, len(tree.Prompts))
+	for _, p := range tree.Prompts {
+		nodes := make([]any, 0, len(p.Nodes))
+		for _, n := range p.Nodes {
+			nodes = append(nodes, map[string]any{
+				"id":         n.ID,
+				"name":       n.Name,
+				"action":     n.Action,
+				"status":     n.Status,
+				"kind":       n.Kind,
+				"tags":       append([]string{}, n.Tags...),
+				"depends_on": append([]int{}, n.DependsOn...),
+			})
+		}
+		prompts = append(prompts, map[string]any{
+			"id":          p.ID,
+			"title":       p.Title,
+			"description": p.Description,
+			"tags":        append([]string{}, p.Tags...),
+			"model":       p.Model,
+			"nodes":       nodes,
+		})
+	}
+
+	variables := make([]any, 0, len(tree.Variables))
+	for _, v := range tree.Variables {
+		variables = append(variables, map[string]any{
+			"name":        v.Name,
+			"default":     v.Default,
+			"description": v.Description,
+		})
+	}
+
+	return map[string]any{
+		"project":     tree.Project,
+		"mainRequest": tree.MainRequest,
+		"model":       tree.Model,
+		"variables":   variables,
+		"prompts":     prompts,
+	}
+}
+
+func applyTreePatch(current *models.TreeResponse, ops []jsonpatch.Operation) (*models.TreeResponse, error) {
+	doc, err := json.Marshal(treeDocument(current))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tree: %w", err)
+	}
+
+	result, err := jsonpatch.Apply(doc, ops)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return nil, ErrPatchTestFailed.Withf("%v", err)
+	}
+	if err != nil {
+		return nil, ErrInvalidInput.Withf("%v", err)
+	}
+
+	// Reject members the tree does not have rather than silently dropping
+	// them, so a typo like /prompts/0/titel fails loudly.
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.DisallowUnknownFields()
+
+	var patched models.TreeResponse
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, ErrInvalidInput.Withf("patched tree does not match the tree shape: %v", err)
+	}
+	return &patched, nil
+}
+
+// treeWriter is the part of the repository syncTree writes through
+type treeWriter interface {
+	UpdateProjectSettings(projectName, mainRequest string) error
+	SetVariables(variables []models.Variable) error
+	SetModelDefaults(cfg *models.ModelConfig) error
+	CreatePrompt(title, description string) (*models.Prompt, error)
+	UpdatePrompt(id int, title, description *string, expectedVersion *int) (*models.Prompt, error)
+	SetPromptTags(promptID int, tags []string) error
+	SetPromptModel(id int, cfg *models.ModelConfig, expectedVersion *int) (*models.Prompt, error)
+	DeletePrompt(id int, expectedVersion *int) error
+	CreateNode(promptID int, name, action, kind string) (*models.Node, error)
+	UpdateNode(nodeID int, name, action, status, kind *string, expectedVersion *int) (*models.Node, error)
+	SetNodeTags(nodeID int, tags []string) error
+	SetNodeDependencies(nodeID int, dependsOn []int) error
+	MoveNode(nodeID, promptID int) error
+	DeleteNode(nodeID int, expectedVersion *int) error
+}
+
+// syncTree writes the difference between current and patched. Deletes run
+// last so a node moved out of a removed prompt is re-parented before the
+// prompt's cascade delete could take it along.
+func syncTree(repo treeWriter, current, patched *models.TreeResponse) error {
+	if current.Project != patched.Project || current.MainRequest != patched.MainRequest {
+		if err := repo.UpdateProjectSettings(patched.Project, patched.MainRequest); err != nil {
+			return err
+		}
+	}
+	if !slices.Equal(current.Variables, patched.Variables) {
+		if err := repo.SetVariables(patched.Variables); err != nil {
+			return err
+		}
+	}
+	if !sameModelConfig(current.Model, patched.Model) {
+		if err := repo.SetModelDefaults(patched.Model); err != nil {
+			return err
+		}
+	}
+
+	existingPrompts := make(map[int]models.PromptNode)
+	existingNodes := make(map[int]models.NodeSummary)
+	nodeParent := make(map[int]int)
+	for _, p := range current.Prompts {
+		existingPrompts[p.ID] = p
+		for _, n := range p.Nodes {
+			existingNodes[n.ID] = n
+			nodeParent[n.ID] = p.ID
+		}
+	}
+
+	keptPrompts := make(map[int]bool)
+	keptNodes := make(map[int]bool)
+
+	// Dependencies may name nodes created further down, so they are written
+	// once every node in the patched tree has its real ID
+	type nodeDeps struct {
+		id        int
+		old, next []int
+	}
+	realIDs := make(map[int]int)
+	var pendingDeps []nodeDeps
+
+	for _, p := range patched.Prompts {
+		promptID := p.ID
+		old, exists := existingPrompts[p.ID]
+
+		if exists && !keptPrompts[p.ID] {
+			keptPrompts[p.ID] = true
+			title, description := changed(old.Title, p.Title), changed(old.Description, p.Description)
+			if title != nil || description != nil {
+				if _, err := repo.UpdatePrompt(p.ID, title, description, nil); err != nil {
+					return err
+				}
+			}
+			if !slices.Equal(old.Tags, p.Tags) {
+				if err := repo.SetPromptTags(p.ID, p.Tags); err != nil {
+					return err
+				}
+			}
+			if !sameModelConfig(old.Model, p.Model) {
+				if _, err := repo.SetPromptModel(p.ID, p.Model, nil); err != nil {
+					return err
+				}
+			}
+		} else {
+			created, err := repo.CreatePrompt(p.Title, p.Description)
+			if err != nil {
+				return err
+			}
+			promptID = created.ID
+			if len(p.Tags) > 0 {
+				if err := repo.SetPromptTags(promptID, p.Tags); err != nil {
+					return err
+				}
+			}
+			if p.Model != nil {
+				if _, err := repo.SetPromptModel(promptID, p.Model, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, n := range p.Nodes {
+			old, exists := existingNodes[n.ID]
+			if !exists || keptNodes[n.ID] {
+				created, err := repo.CreateNode(promptID, n.Name, n.Action, n.Kind)
+				if err != nil {
+					return err
+				}
+				if n.Status != created.Status {
+					if _, err := repo.UpdateNode(created.ID, nil, nil, &n.Status, nil, nil); err != nil {
+						return err
+					}
+				}
+				if len(n.Tags) > 0 {
+					if err := repo.SetNodeTags(created.ID, n.Tags); err != nil {
+						return err
+					}
+				}
+				if !exists {
+					realIDs[n.ID] = created.ID
+				}
+				pendingDeps = append(pendingDeps, nodeDeps{id: created.ID, next: n.DependsOn})
+				continue
+			}
+
+			keptNodes[n.ID] = true
+			realIDs[n.ID] = n.ID
+			pendingDeps = append(pendingDeps, nodeDeps{id: n.ID, old: old.DependsOn, next: n.DependsOn})
+			name, action, status, kind := changed(old.Name, n.Name), changed(old.Action, n.Action), changed(old.Status, n.Status), changed(old.Kind, n.Kind)
+			if name != nil || action != nil || status != nil || kind != nil {
+				if _, err := repo.UpdateNode(n.ID, name, action, status, kind, nil); err != nil {
+					return err
+				}
+			}
+			if !slices.Equal(old.Tags, n.Tags) {
+				if err := repo.SetNodeTags(n.ID, n.Tags); err != nil {
+					return err
+				}
+			}
+			if nodeParent[n.ID] != promptID {
+				if err := repo.MoveNode(n.ID, promptID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, d := range pendingDeps {
+		next := make([]int, 0, len(d.next))
+		for _, id := range d.next {
+			next = append(next, realIDs[id])
+		}
+		slices.Sort(next)
+		if !slices.Equal(d.old, next) {
+			if err := repo.SetNodeDependencies(d.id, next); err != nil {
+				return err
+			}
+		}
+	}
+
+	for id := range existingNodes {
+		if !keptNodes[id] && keptPrompts[nodeParent[id]] {
+			if err := repo.DeleteNode(id, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for id := range existingPrompts {
+		if !keptPrompts[id] {
+			if err := repo.DeletePrompt(id, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// changed returns a pointer to next when it differs from prev, matching the
+// repository's nil-means-unchanged update convention.
+func changed(prev, next string) *string {
+	if prev == next {
+		return nil
+	}
+	return &next
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/jsonpatch"
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// memTree is an in-memory treeWriter. Deleting a prompt deletes its nodes,
+// like the foreign key does.
+type memTree struct {
+	tree    models.TreeResponse
+	prompts map[int]*models.PromptNode
+	nodes   map[int]*models.NodeSummary
+	parent  map[int]int
+	nextID  int
+	calls   []string
+}
+
+func newMemTree(tree models.TreeResponse) *memTree {
+	m := &memTree{
+		tree:    tree,
+		prompts: make(map[int]*models.PromptNode),
+		nodes:   make(map[int]*models.NodeSummary),
+		parent:  make(map[int]int),
+		nextID:  100,
+	}
+	for _, p := range tree.Prompts {
+		for _, n := range p.Nodes {
+			m.nodes[n.ID] = &n
+			m.parent[n.ID] = p.ID
+		}
+		p.Nodes = nil
+		m.prompts[p.ID] = &p
+	}
+	m.tree.Prompts = nil
+	return m
+}
+
+// current renders the store the way GetTree does, sorted by ID
+func (m *memTree) current() *models.TreeResponse {
+	tree := m.tree
+	for _, id := range slices.Sorted(maps.Keys(m.prompts)) {
+		p := *m.prompts[id]
+		for _, nodeID := range slices.Sorted(maps.Keys(m.nodes)) {
+			if m.parent[nodeID] == id {
+				p.Nodes = append(p.Nodes, *m.nodes[nodeID])
+			}
+		}
+		tree.Prompts = append(tree.Prompts, p)
+	}
+	return &tree
+}
+
+func (m *memTree) record(format string, args ...any) {
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *memTree) UpdateProjectSettings(projectName, mainRequest string) error {
+	m.record("UpdateProjectSettings")
+	m.tree.Project, m.tree.MainRequest = projectName, mainRequest
+	return nil
+}
+
+func (m *memTree) SetVariables(variables []models.Variable) error {
+	m.record("SetVariables")
+	m.tree.Variables = variables
+	return nil
+}
+
+func (m *memTree) SetModelDefaults(cfg *models.ModelConfig) error {
+	m.record("SetModelDefaults")
+	m.tree.Model = cfg
+	return nil
+}
+
+func (m *memTree) CreatePrompt(title, description string) (*models.Prompt, error) {
+	m.nextID++
+	m.record("CreatePrompt %d", m.nextID)
+	m.prompts[m.nextID] = &models.PromptNode{ID: m.nextID, Title: title, Description: description}
+	return &models.Prompt{ID: m.nextID, Title: title, Description: description}, nil
+}
+
+func (m *memTree) UpdatePrompt(id int, title, description *string, expectedVersion *int) (*models.Prompt, error) {
+	m.record("UpdatePrompt %d", id)
+	p := m.prompts[id]
+	if title != nil {
+		p.Title = *title
+	}
+	if description != nil {
+		p.Description = *description
+	}
+	return &models.Prompt{ID: id, Title: p.Title, Description: p.Description}, nil
+}
+
+func (m *memTree) SetPromptTags(promptID int, tags []string) error {
+	m.record("SetPromptTags %d", promptID)
+	m.prompts[promptID].Tags = tags
+	return nil
+}
+
+func (m *memTree) SetPromptModel(id int, cfg *models.ModelConfig, expectedVersion *int) (*models.Prompt, error) {
+	m.record("SetPromptModel %d", id)
+	m.prompts[id].Model = cfg
+	return &models.Prompt{ID: id}, nil
+}
+
+func (m *memTree) DeletePrompt(id int, expectedVersion *int) error {
+	m.record("DeletePrompt %d", id)
+	delete(m.prompts, id)
+	for nodeID, parent := range m.parent {
+		if parent == id {
+			delete(m.nodes, nodeID)
+			delete(m.parent, nodeID)
+		}
+	}
+	return nil
+}
+
+func (m *memTree) CreateNode(promptID int, name, action, kind string) (*models.Node, error) {
+	m.nextID++
+	m.record("CreateNode %d", m.nextID)
+	m.nodes[m.nextID] = &models.NodeSummary{ID: m.nextID, Name: name, Action: action, Kind: kind, Status: models.NodeStatusTodo}
+	m.parent[m.nextID] = promptID
+	return &models.Node{ID: m.nextID, PromptID: promptID, Name: name, Action: action, Kind: kind, Status: models.NodeStatusTodo}, nil
+}
+
+func (m *memTree) UpdateNode(nodeID int, name, action, status, kind *string, expectedVersion *int) (*models.Node, error) {
+	m.record("UpdateNode %d", nodeID)
+	n := m.nodes[nodeID]
+	for field, value := range map[*string]*string{&n.Name: name, &n.Action: action, &n.Status: status, &n.Kind: kind} {
+		if value != nil {
+			*field = *value
+		}
+	}
+	return &models.Node{ID: nodeID}, nil
+}
+
+func (m *memTree) SetNodeTags(nodeID int, tags []string) error {
+	m.record("SetNodeTags %d", nodeID)
+	m.nodes[nodeID].Tags = tags
+	return nil
+}
+
+func (m *memTree) SetNodeDependencies(nodeID int, dependsOn []int) error {
+	m.record("SetNodeDependencies %d", nodeID)
+	m.nodes[nodeID].DependsOn = dependsOn
+	return nil
+}
+
+func (m *memTree) MoveNode(nodeID, promptID int) error {
+	m.record("MoveNode %d", nodeID)
+	m.parent[nodeID] = promptID
+	return nil
+}
+
+func (m *memTree) DeleteNode(nodeID int, expectedVersion *int) error {
+	m.record("DeleteNode %d", nodeID)
+	delete(m.nodes, nodeID)
+	delete(m.parent, nodeID)
+	return nil
+}
+
+func patchTree() models.TreeResponse {
+	return models.TreeResponse{
+		Project:     "Racing",
+		MainRequest: "Build a racing game",
+		Prompts: []models.PromptNode{
+			{ID: 1, Title: "Setup", Nodes: []models.NodeSummary{
+				{ID: 1, Name: "Init", Action: "npm init", Status: models.NodeStatusDone, Kind: models.NodeKindCommand},
+				{ID: 2, Name: "Install", Action: "npm install", Status: models.NodeStatusTodo, Kind: models.NodeKindCommand, DependsOn: []int{1}},
+			}},
+			{ID: 2, Title: "Game", Tags: []string{"core"}, Nodes: []models.NodeSummary{
+				{ID: 3, Name: "Track", Action: "Draw the track", Status: models.NodeStatusTodo, Kind: models.NodeKindInstruction},
+			}},
+		},
+	}
+}
+
+// patch runs ops the way PatchTree does, against an in-memory tree
+func patch(t *testing.T, store *memTree, patch string) error {
+	t.Helper()
+	var ops []jsonpatch.Operation
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		t.Fatal(err)
+	}
+
+	current := store.current()
+	patched, err := applyTreePatch(current, ops)
+	if err != nil {
+		return err
+	}
+	if err := validateTree(patched); err != nil {
+		return err
+	}
+	return syncTree(store, current, patched)
+}
+
+func TestApplyTreePatch(t *testing.T) {
+	store := newMemTree(patchTree())
+	current := store.current()
+
+	var ops []jsonpatch.Operation
+	if err := json.Unmarshal([]byte(`[
+		{"op":"replace","path":"/prompts/0/description","value":"First"},
+		{"op":"add","path":"/prompts/1/nodes/-","value":{"id":0,"name":"Cars","action":"Add cars"}}
+	]`), &ops); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := applyTreePatch(current, ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.Prompts[0].Description != "First" {
+		t.Errorf("description = %q", patched.Prompts[0].Description)
+	}
+	if nodes := patched.Prompts[1].Nodes; len(nodes) != 2 || nodes[1].Name != "Cars" {
+		t.Errorf("nodes = %+v", nodes)
+	}
+	if current.Prompts[0].Description != "" || len(current.Prompts[1].Nodes) != 1 {
+		t.Error("patch changed the current tree")
+	}
+}
+
+func TestApplyTreePatchErrors(t *testing.T) {
+	tests := []struct {
+		name, patch string
+		want        error
+	}{
+		{"failed test", `[{"op":"test","path":"/project","value":"Other"}]`, ErrPatchTestFailed},
+		{"bad pointer", `[{"op":"remove","path":"/prompts/5"}]`, ErrInvalidInput},
+		{"unknown member", `[{"op":"add","path":"/prompts/0/titel","value":"x"}]`, ErrInvalidInput},
+		{"wrong type", `[{"op":"replace","path":"/prompts/0/title","value":7}]`, ErrInvalidInput},
+		{"invalid tree", `[{"op":"replace","path":"/prompts/0/title","value":""}]`, ErrInvalidInput},
+		{"dependency cycle", `[{"op":"add","path":"/prompts/0/nodes/0/depends_on/-","value":2}]`, ErrInvalidInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemTree(patchTree())
+			if err := patch(t, store, tt.patch); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if len(store.calls) > 0 {
+				t.Errorf("failed patch wrote %v", store.calls)
+			}
+		})
+	}
+}
+
+func TestSyncTree(t *testing.T) {
+	store := newMemTree(patchTree())
+	err := patch(t, store, `[
+		{"op":"replace","path":"/project","value":"Rally"},
+		{"op":"replace","path":"/prompts/0/nodes/1/status","value":"in_progress"},
+		{"op":"add","path":"/prompts/1/nodes/-","value":{"id":-1,"name":"Cars","action":"Add cars","depends_on":[3]}},
+		{"op":"add","path":"/prompts/1/nodes/0/depends_on/-","value":2}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := store.current()
+	if tree.Project != "Rally" {
+		t.Errorf("project = %q", tree.Project)
+	}
+	if n := tree.Prompts[0].Nodes[1]; n.ID != 2 || n.Status != models.NodeStatusInProgress {
+		t.Errorf("node 2 = %+v", n)
+	}
+	game := tree.Prompts[1].Nodes
+	if len(game) != 2 || game[1].Name != "Cars" || game[1].ID != 101 || game[1].Kind != models.NodeKindInstruction {
+		t.Fatalf("game nodes = %+v", game)
+	}
+	if !slices.Equal(game[0].DependsOn, []int{2}) || !slices.Equal(game[1].DependsOn, []int{3}) {
+		t.Errorf("dependencies = %v, %v", game[0].DependsOn, game[1].DependsOn)
+	}
+
+	// Only what changed is written
+	want := []string{"UpdateProjectSettings", "UpdateNode 2", "CreateNode 101", "SetNodeDependencies 3", "SetNodeDependencies 101"}
+	if !slices.Equal(store.calls, want) {
+		t.Errorf("calls = %v, want %v", store.calls, want)
+	}
+}
+
+func TestSyncTreeNewNodeIDs(t *testing.T) {
+	store := newMemTree(patchTree())
+	// A new node's id is a placeholder other new nodes can depend on
+	err := patch(t, store, `[
+		{"op":"add","path":"/prompts/-","value":{"id":0,"title":"Polish","nodes":[
+			{"id":500,"name":"Sound","action":"Add sound"},
+			{"id":501,"name":"Music","action":"Add music","depends_on":[500,3]}
+		]}}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	polish := store.current().Prompts[2]
+	if polish.ID != 101 || len(polish.Nodes) != 2 {
+		t.Fatalf("prompt = %+v", polish)
+	}
+	if music := polish.Nodes[1]; !slices.Equal(music.DependsOn, []int{3, polish.Nodes[0].ID}) {
+		t.Errorf("music depends on %v", music.DependsOn)
+	}
+}
+
+func TestSyncTreeMovesAndCopies(t *testing.T) {
+	store := newMemTree(patchTree())
+	// Moving a node out of a prompt that is then removed keeps it; copying
+	// a node creates a new one rather than sharing its ID
+	err := patch(t, store, `[
+		{"op":"move","from":"/prompts/0/nodes/0","path":"/prompts/1/nodes/-"},
+		{"op":"copy","from":"/prompts/1/nodes/0","path":"/prompts/1/nodes/-"},
+		{"op":"remove","path":"/prompts/0"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := store.current()
+	if len(tree.Prompts) != 1 || tree.Prompts[0].ID != 2 {
+		t.Fatalf("prompts = %+v", tree.Prompts)
+	}
+	var ids []int
+	for _, n := range tree.Prompts[0].Nodes {
+		ids = append(ids, n.ID)
+	}
+	if !slices.Equal(ids, []int{1, 3, 101}) {
+		t.Errorf("node ids = %v, want the moved node 1, 3 and a copy of 3", ids)
+	}
+	if store.nodes[101].Name != "Track" {
+		t.Errorf("copy = %+v", store.nodes[101])
+	}
+	if _, ok := store.nodes[2]; ok {
+		t.Error("node 2 survived its prompt's removal")
+	}
+	if last := store.calls[len(store.calls)-1]; last != "DeletePrompt 1" {
+		t.Errorf("last call = %q, want the delete", last)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrVariableNotFound  = errs.New(errs.NotFound, "variable_not_found", "variable not found")
+	ErrVariableInUse     = errs.New(errs.Conflict, "variable_in_use", "variable is still used")
+	ErrUndefinedVariable = errs.New(errs.Validation, "undefined_variable", "text uses an undefined variable")
+	ErrMissingVariable   = errs.New(errs.Validation, "missing_variable", "variable has no value")
+)
+
+// variableName is what a variable name looks like, e.g. lap_count
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// placeholder matches {{name}}, allowing spaces inside the braces
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+func validateVariableName(name string) error {
+	if !variableName.MatchString(name) {
+		return ErrInvalidInput.Withf("variable name %q must be 1-64 letters, digits or '_' and not start with a digit", name)
+	}
+	return nil
+}
+
+// placeholders returns the variable names used in text, in order of first use
+func placeholders(text string) []string {
+	var names []string
+	for _, match := range placeholder.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// checkPlaceholders fails if any text uses a variable not in defined
+func checkPlaceholders(defined []models.Variable, texts ...string) error {
+	for _, text := range texts {
+		for _, name := range placeholders(text) {
+			known := slices.ContainsFunc(defined, func(v models.Variable) bool { return v.Name == name })
+			if !known {
+				return ErrUndefinedVariable.Withf("{{%s}} is not a defined variable", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkText is checkPlaceholders against the stored variables. Call it in
+// the transaction that writes the texts: when they use variables it takes the
+// tree version lock, so DeleteVariable cannot remove one before the commit.
+func (s *PromptService) checkText(texts ...string) error {
+	if !slices.ContainsFunc(texts, func(text string) bool { return placeholder.MatchString(text) }) {
+		return nil
+	}
+	if _, err := s.repo.LockTreeVersion(); err != nil {
+		return err
+	}
+
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return err
+	}
+	return checkPlaceholders(variables, texts...)
+}
+
+// validateVariables checks a tree document's variables and that its prompt
+// descriptions and node actions only use those variables
+func validateVariables(tree *models.TreeResponse) error {
+	seen := make(map[string]bool)
+	for _, v := range tree.Variables {
+		if err := validateVariableName(v.Name); err != nil {
+			return err
+		}
+		if seen[v.Name] {
+			return ErrInvalidInput.Withf("variable %q is defined twice", v.Name)
+		}
+		seen[v.Name] = true
+	}
+
+	for _, p := range tree.Prompts {
+		if err := checkPlaceholders(tree.Variables, p.Description); err != nil {
+			return err
+		}
+		for _, n := range p.Nodes {
+			if err := checkPlaceholders(tree.Variables, n.Action); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PromptService) ListVariables() ([]models.Variable, error) {
+	variables, err := s.repo.ListVariables()
+	if err != nil {
+		return nil, err
+	}
+	if variables == nil {
+		variables = []models.Variable{}
+	}
+	return variables, nil
+}
+
+// PutVariable creates a variable or replaces its default and description
+func (s *PromptService) PutVariable(name, defaultValue, description string) (*models.Variable, error) {
+	if err := validateVariableName(name); err != nil {
+		return nil, err
+	}
+
+	variable := models.Variable{Name: name, Default: defaultValue, Description: description}
+	if err := s.repo.PutVariable(variable); err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return &variable, nil
+}
+
+// DeleteVariable removes a variable no prompt, node or variant still uses
+func (s *PromptService) DeleteVariable(name string) error {
+	err := s.inTx(func(tx *PromptService) error {
+		if _, err := tx.repo.LockTreeVersion(); err != nil {
+			return err
+		}
+
+		tree, err := tx.GetTree()
+		if err != nil {
+			return err
+		}
+		for _, p := range tree.Prompts {
+			if slices.Contains(placeholders(p.Description), name) {
+				return ErrVariableInUse.Withf("variable %q is used by prompt %d", name, p.ID)
+			}
+			for _, n := range p.Nodes {
+				if slices.Contains(placeholders(n.Action), name) {
+					return ErrVariableInUse.Withf("variable %q is used by node %d", name, n.ID)
+				}
+			}
+		}
+
+		// Variants become a node's action when promoted
+		variants, err := tx.repo.ListInactiveVariantsWithPlaceholders()
+		if err != nil {
+			return err
+		}
+		for _, v := range variants {
+			if slices.Contains(placeholders(v.Action), name) {
+				return ErrVariableInUse.Withf("variable %q is used by variant %q of node %d", name, v.Name, v.NodeID)
+			}
+		}
+
+		deleted, err := tx.repo.DeleteVariable(name)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return ErrVariableNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifier.BroadcastTreeChanged()
+	return nil
+}
+
+// RenderTree returns the tree with every placeholder replaced. Overrides are
+// "name=value" pairs; variables not overridden use their default, and one
+// with neither an override nor a default is an error. An override may be
+// empty to render a variable as nothing.
+func (s *PromptService) RenderTree(overrides []string) (*models.TreeResponse, error) {
+	tree, err := s.GetTree()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := variableValues(tree.Variables, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	render := func(text string) (string, error) {
+		if err := checkPlaceholders(tree.Variables, text); err != nil {
+			return "", err
+		}
+		for _, name := range placeholders(text) {
+			if _, ok := values[name]; !ok {
+				return "", ErrMissingVariable.Withf("variable %q has no default; pass vars=%s=<value>", name, name)
+			}
+		}
+		return placeholder.ReplaceAllStringFunc(text, func(match string) string {
+			return values[placeholder.FindStringSubmatch(match)[1]]
+		}), nil
+	}
+
+	for i := range tree.Prompts {
+		prompt := &tree.Prompts[i]
+		if prompt.Description, err = render(prompt.Description); err != nil {
+			return nil, err
+		}
+		for j := range prompt.Nodes {
+			node := &prompt.Nodes[j]
+			if node.Action, err = render(node.Action); err != nil {
+				return nil, err
+			}
+		}
+	}
+	tree.Variables = nil
+	return tree, nil
+}
+
+// variableValues returns the value of each variable that has one: its
+// override, else a non-empty default
+func variableValues(variables []models.Variable, overrides []string) (map[string]string, error) {
+	values := make(map[string]string, len(variables))
+	defined := make(map[string]bool, len(variables))
+	for _, v := range variables {
+		defined[v.Name] = true
+		if v.Default != "" {
+			values[v.Name] = v.Default
+		}
+	}
+	for _, override := range overrides {
+		name, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, ErrInvalidInput.Withf("vars entry %q must look like name=value", override)
+		}
+		if !defined[name] {
+			return nil, ErrUndefinedVariable.Withf("%q is not a defined variable", name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+func TestVariableValues(t *testing.T) {
+	variables := []models.Variable{{Name: "laps", Default: "3"}, {Name: "track"}, {Name: "car", Default: "kart"}}
+	tests := []struct {
+		name      string
+		overrides []string
+		want      map[string]string
+	}{
+		{"defaults", nil, map[string]string{"laps": "3", "car": "kart"}},
+		{"override", []string{"laps=5", "track=oval"}, map[string]string{"laps": "5", "track": "oval", "car": "kart"}},
+		{"empty override", []string{"track=", "car="}, map[string]string{"laps": "3", "track": "", "car": ""}},
+		{"value with =", []string{"track=a=b"}, map[string]string{"laps": "3", "track": "a=b", "car": "kart"}},
+		{"last override wins", []string{"laps=5", "laps=7"}, map[string]string{"laps": "7", "car": "kart"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := variableValues(variables, tt.overrides)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(values, tt.want) {
+				t.Errorf("values = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariableValuesRejects(t *testing.T) {
+	variables := []models.Variable{{Name: "laps"}}
+	if _, err := variableValues(variables, []string{"laps"}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("err = %v, want ErrInvalidInput", err)
+	}
+	if _, err := variableValues(variables, []string{"speed=1"}); !errors.Is(err, ErrUndefinedVariable) {
+		t.Errorf("err = %v, want ErrUndefinedVariable", err)
+	}
+}
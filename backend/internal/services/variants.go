@@ -0,0 +1,390 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+	"github.com/pranavturlapati28/merget-takehome/internal/services/errs"
+)
+
+var (
+	ErrVariantNotFound = errs.New(errs.NotFound, "variant_not_found", "variant not found")
+	ErrVariantActive   = errs.New(errs.Conflict, "variant_active", "the active variant cannot be deleted")
+)
+
+// originalVariant names the variant that keeps a node's action from before
+// its first variant was added
+const originalVariant = "original"
+
+// variant returns the variant if it belongs to the node
+func (s *PromptService) variant(nodeID, variantID int) (*models.NodeVariant, error) {
+	v, err := s.repo.GetVariant(variantID)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil || v.NodeID != nodeID {
+		return nil, ErrVariantNotFound
+	}
+	return v, nil
+}
+
+func (s *PromptService) ListVariants(nodeID int) ([]models.NodeVariant, error) {
+	if _, err := s.requireNode(nodeID); err != nil {
+		return nil, err
+	}
+
+	variants, err := s.repo.ListVariants(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if variants == nil {
+		variants = []models.NodeVariant{}
+	}
+	return variants, nil
+}
+
+// CreateVariant adds a variant to a node. The node's first variant also
+// saves its current action as the active "original" variant, so switching
+// to the new one never loses the old phrasing.
+func (s *PromptService) CreateVariant(nodeID int, req models.CreateVariantRequest) (*models.NodeVariant, error) {
+	var created *models.NodeVariant
+	var promptID int
+	err := s.inTx(func(tx *PromptService) error {
+		if err := tx.checkText(req.Action); err != nil {
+			return err
+		}
+
+		node, err := tx.requireNode(nodeID)
+		if err != nil {
+			return err
+		}
+		promptID = node.PromptID
+
+		existing, err := tx.repo.ListVariants(nodeID)
+		if err != nil {
+			return err
+		}
+		if len(existing) == 0 {
+			if req.Name == originalVariant {
+				return ErrInvalidInput.Withf("%q is kept for the node's current action; choose another name", originalVariant)
+			}
+			if _, err := tx.repo.CreateVariant(nodeID, originalVariant, node.Action, true); err != nil {
+				return err
+			}
+		}
+
+		created, err = tx.repo.CreateVariant(nodeID, req.Name, req.Action, false)
+		if err != nil {
+			return err
+		}
+		if req.Activate {
+			if _, err := tx.promote(nodeID, created, nil); err != nil {
+				return err
+			}
+			created.Active = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNodeChanged(promptID)
+	return created, nil
+}
+
+// UpdateVariant renames a variant and replaces its action. Editing the
+// active variant changes the node's action too.
+func (s *PromptService) UpdateVariant(nodeID, variantID int, req models.UpdateVariantRequest) (*models.NodeVariant, error) {
+	var updated *models.NodeVariant
+	var promptID int
+	err := s.inTx(func(tx *PromptService) error {
+		if err := tx.checkText(req.Action); err != nil {
+			return err
+		}
+
+		node, err := tx.requireNode(nodeID)
+		if err != nil {
+			return err
+		}
+		promptID = node.PromptID
+
+		if _, err := tx.variant(nodeID, variantID); err != nil {
+			return err
+		}
+		updated, err = tx.repo.UpdateVariant(variantID, req.Name, req.Action)
+		if err != nil {
+			return err
+		}
+		if updated == nil {
+			return ErrVariantNotFound
+		}
+		if updated.Active && node.Action != updated.Action {
+			if _, err := tx.repo.UpdateNode(nodeID, nil, &updated.Action, nil, nil, nil); err != nil {
+				return versionError(err, ErrNodeNotFound)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNodeChanged(promptID)
+	return updated, nil
+}
+
+// DeleteVariant removes a variant other than the active one
+func (s *PromptService) DeleteVariant(nodeID, variantID int) error {
+	var node *models.Node
+	err := s.inTx(func(tx *PromptService) error {
+		var err error
+		if node, err = tx.requireNode(nodeID); err != nil {
+			return err
+		}
+		v, err := tx.variant(nodeID, variantID)
+		if err != nil {
+			return err
+		}
+		if v.Active {
+			return ErrVariantActive.Withf("variant %q is the node's action; promote another variant first", v.Name)
+		}
+
+		deleted, err := tx.repo.DeleteVariant(variantID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return nil
+		}
+		// Deleted or promoted since we read it
+		if v, err = tx.variant(nodeID, variantID); err != nil {
+			return err
+		}
+		return ErrVariantActive.Withf("variant %q is the node's action; promote another variant first", v.Name)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return nil
+}
+
+// PromoteVariant makes the variant active and its text the node's action.
+// When ifVersion is set the node must still be at that version.
+func (s *PromptService) PromoteVariant(nodeID, variantID int, ifVersion *int) (*models.Node, error) {
+	var node *models.Node
+	err := s.inTx(func(tx *PromptService) error {
+		if _, err := tx.requireNode(nodeID); err != nil {
+			return err
+		}
+		v, err := tx.variant(nodeID, variantID)
+		if err != nil {
+			return err
+		}
+		// Its variables may have been deleted while it was inactive
+		if err := tx.checkText(v.Action); err != nil {
+			return err
+		}
+		node, err = tx.promote(nodeID, v, ifVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifier.BroadcastNodeChanged(node.PromptID)
+	return node, nil
+}
+
+func (s *PromptService) promote(nodeID int, v *models.NodeVariant, ifVersion *int) (*models.Node, error) {
+	if err := s.repo.ActivateVariant(nodeID, v.ID); err != nil {
+		return nil, err
+	}
+	node, err := s.repo.UpdateNode(nodeID, nil, &v.Action, nil, nil, ifVersion)
+	if err != nil {
+		return nil, versionError(err, ErrNodeNotFound)
+	}
+	return node, nil
+}
+
+// CompareVariants sets two of a node's variants side by side: their token
+// estimates, how their test runs went and a word-level diff of their text
+func (s *PromptService) CompareVariants(nodeID, a, b int) (*models.VariantComparison, error) {
+	if _, err := s.requireNode(nodeID); err != nil {
+		return nil, err
+	}
+
+	comparison := &models.VariantComparison{NodeID: nodeID}
+	for _, side := range []struct {
+		id      int
+		summary *models.VariantSummary
+	}{{a, &comparison.A}, {b, &comparison.B}} {
+		v, err := s.variant(nodeID, side.id)
+		if err != nil {
+			return nil, err
+		}
+		runs, passed, err := s.repo.CountVariantRuns(v.ID)
+		if err != nil {
+			return nil, err
+		}
+		side.summary.Variant = *v
+		side.summary.Runs = runs
+		side.summary.Passed = passed
+		if s.tokenizer != nil {
+			side.summary.Tokens = s.tokenizer.Count(v.Action)
+		}
+	}
+
+	comparison.Diff = diffWords(comparison.A.Variant.Action, comparison.B.Variant.Action)
+	return comparison, nil
+}
+
+// words splits text into words and the whitespace between them, so a diff
+// can be joined back into either text exactly
+var words = regexp.MustCompile(`\S+|\s+`)
+
+// maxDiffWords bounds the words and spaces a diff compares once the texts'
+// common start and end are set aside. Longer middles are shown as removed
+// and added whole rather than risk a quadratic search.
+const maxDiffWords = 10000
+
+// diffWords returns the shortest diff of two texts, word by word, with
+// neighbouring words of the same kind merged into one segment
+func diffWords(a, b string) []models.DiffSegment {
+	x, y := words.FindAllString(a, -1), words.FindAllString(b, -1)
+
+	d := &differ{}
+	pre, suf := commonAffixes(x, y)
+	d.emit("equal", x[:pre]...)
+	if mx, my := x[pre:len(x)-suf], y[pre:len(y)-suf]; len(mx)+len(my) > maxDiffWords {
+		d.emit("removed", mx...)
+		d.emit("added", my...)
+	} else {
+		d.compare(mx, my)
+	}
+	d.emit("equal", x[len(x)-suf:]...)
+	return d.segments()
+}
+
+// commonAffixes returns how many words x and y share at the start and, after
+// those, at the end
+func commonAffixes(x, y []string) (pre, suf int) {
+	for pre < len(x) && pre < len(y) && x[pre] == y[pre] {
+		pre++
+	}
+	for suf < len(x)-pre && suf < len(y)-pre && x[len(x)-1-suf] == y[len(y)-1-suf] {
+		suf++
+	}
+	return pre, suf
+}
+
+// differ builds a diff with Myers' linear-space algorithm: it finds the
+// middle snake of the shortest edit path and diffs either side of it
+type differ struct {
+	ops   []string
+	texts []string
+}
+
+func (d *differ) emit(op string, texts ...string) {
+	for _, text := range texts {
+		d.ops = append(d.ops, op)
+		d.texts = append(d.texts, text)
+	}
+}
+
+// segments merges neighbouring words of the same kind
+func (d *differ) segments() []models.DiffSegment {
+	diff := []models.DiffSegment{}
+	for start := 0; start < len(d.ops); {
+		end := start + 1
+		for end < len(d.ops) && d.ops[end] == d.ops[start] {
+			end++
+		}
+		diff = append(diff, models.DiffSegment{Op: d.ops[start], Text: strings.Join(d.texts[start:end], "")})
+		start = end
+	}
+	return diff
+}
+
+func (d *differ) compare(x, y []string) {
+	pre, suf := commonAffixes(x, y)
+	d.emit("equal", x[:pre]...)
+	tail := x[len(x)-suf:]
+	x, y = x[pre:len(x)-suf], y[pre:len(y)-suf]
+
+	switch {
+	case len(x) == 0:
+		d.emit("added", y...)
+	case len(y) == 0:
+		d.emit("removed", x...)
+	default:
+		// With the ends differing the path has at least two edits, so both
+		// halves are smaller than the whole
+		x0, y0, x1, y1 := middleSnake(x, y)
+		d.compare(x[:x0], y[:y0])
+		d.emit("equal", x[x0:x1]...)
+		d.compare(x[x1:], y[y1:])
+	}
+
+	d.emit("equal", tail...)
+}
+
+// middleSnake returns where the diagonal run in the middle of a shortest
+// edit path from x to y starts and ends. It searches from both ends at once,
+// keeping only the furthest point reached on each diagonal.
+func middleSnake(x, y []string) (x0, y0, x1, y1 int) {
+	n, m := len(x), len(y)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+
+	// forward[k] is how far along x the forward search got on diagonal
+	// k = x - y; backward[k] the same for the search from the ends, counted
+	// from the ends
+	off := limit + 1
+	forward := make([]int, 2*limit+3)
+	backward := make([]int, 2*limit+3)
+
+	for e := 0; e <= limit; e++ {
+		for k := -e; k <= e; k += 2 {
+			var i int
+			if k == -e || (k != e && forward[off+k-1] < forward[off+k+1]) {
+				i = forward[off+k+1]
+			} else {
+				i = forward[off+k-1] + 1
+			}
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			forward[off+k] = i
+			if c := delta - k; odd && c >= -(e-1) && c <= e-1 && i+backward[off+c] >= n {
+				return si, sj, i, j
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var i int
+			if k == -e || (k != e && backward[off+k-1] < backward[off+k+1]) {
+				i = backward[off+k+1]
+			} else {
+				i = backward[off+k-1] + 1
+			}
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && x[n-1-i] == y[m-1-j] {
+				i++
+				j++
+			}
+			backward[off+k] = i
+			if c := delta - k; !odd && c >= -e && c <= e && i+forward[off+c] >= n {
+				return n - i, m - j, n - si, m - sj
+			}
+		}
+	}
+	panic("middleSnake: no overlap")
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pranavturlapati28/merget-takehome/internal/models"
+)
+
+// sides joins a diff back into the two texts it compares
+func sides(diff []models.DiffSegment) (a, b string) {
+	var sa, sb strings.Builder
+	for _, seg := range diff {
+		if seg.Op != "added" {
+			sa.WriteString(seg.Text)
+		}
+		if seg.Op != "removed" {
+			sb.WriteString(seg.Text)
+		}
+	}
+	return sa.String(), sb.String()
+}
+
+// lcsLength is the quadratic reference for how many words two texts share
+func lcsLength(x, y []string) int {
+	prev := make([]int, len(y)+1)
+	for i := range x {
+		cur := make([]int, len(y)+1)
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(y)]
+}
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []models.DiffSegment
+	}{
+		{"", "", []models.DiffSegment{}},
+		{"same text", "same text", []models.DiffSegment{{Op: "equal", Text: "same text"}}},
+		{"", "new", []models.DiffSegment{{Op: "added", Text: "new"}}},
+		{"old", "", []models.DiffSegment{{Op: "removed", Text: "old"}}},
+		{"add a button", "add a red button", []models.DiffSegment{
+			{Op: "equal", Text: "add a "},
+			{Op: "added", Text: "red "},
+			{Op: "equal", Text: "button"},
+		}},
+		{"use the cache", "skip the cache", []models.DiffSegment{
+			{Op: "removed", Text: "use"},
+			{Op: "added", Text: "skip"},
+			{Op: "equal", Text: " the cache"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := diffWords(tt.a, tt.b); !slices.Equal(got, tt.want) {
+			t.Errorf("diffWords(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDiffWordsIsShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vocab := []string{"a", "b", "c", "d"}
+	text := func() string {
+		n := rng.Intn(12)
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = vocab[rng.Intn(len(vocab))]
+		}
+		return strings.Join(parts, " ")
+	}
+
+	for range 2000 {
+		a, b := text(), text()
+		diff := diffWords(a, b)
+		if gotA, gotB := sides(diff); gotA != a || gotB != b {
+			t.Fatalf("diff of %q and %q joins back into %q and %q", a, b, gotA, gotB)
+		}
+
+		shared := 0
+		for _, seg := range diff {
+			if seg.Op == "equal" {
+				shared += len(words.FindAllString(seg.Text, -1))
+			}
+		}
+		if want := lcsLength(words.FindAllString(a, -1), words.FindAllString(b, -1)); shared != want {
+			t.Fatalf("diff of %q and %q keeps %d words, want %d", a, b, shared, want)
+		}
+	}
+}
+
+func TestDiffWordsLongTexts(t *testing.T) {
+	a := strings.Repeat("x ", maxDiffWords)
+	b := strings.Repeat("y ", maxDiffWords)
+	diff := diffWords("start "+a+"end", "start "+b+"end")
+
+	want := []string{"equal", "removed", "added", "equal"}
+	var ops []string
+	for _, seg := range diff {
+		ops = append(ops, seg.Op)
+	}
+	if !slices.Equal(ops, want) {
+		t.Errorf("ops = %v, want %v", ops, want)
+	}
+}
@@ -3,7 +3,7 @@ import axios from 'axios';
 const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080';
 
 const api = axios.create({
-  baseURL: API_URL,
+  baseURL: `${API_URL}/v1`,
   headers: {
     'Content-Type': 'application/json',
   },
@@ -49,7 +49,7 @@ export const getPrompt = async (id) => {
 };
 
 export const createPrompt = async (prompt) => {
-  const response = await api.post('/prompts/0', prompt);
+  const response = await api.post('/prompts', prompt);
   return response.data;
 };
 